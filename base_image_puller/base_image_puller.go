@@ -3,11 +3,13 @@ package base_image_puller // import "code.cloudfoundry.org/grootfs/base_image_pu
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,6 +21,9 @@ import (
 const MetricsUnpackTimeName = "UnpackTime"
 const MetricsDownloadTimeName = "DownloadTime"
 
+// DefaultMaxParallelDownloads matches the default used by the docker daemon
+const DefaultMaxParallelDownloads = 3
+
 //go:generate counterfeiter . Fetcher
 //go:generate counterfeiter . Unpacker
 //go:generate counterfeiter . DependencyRegisterer
@@ -66,29 +71,64 @@ type VolumeDriver interface {
 }
 
 type BaseImagePuller struct {
-	fetcher        Fetcher
-	unpacker       Unpacker
-	volumeDriver   VolumeDriver
-	metricsEmitter groot.MetricsEmitter
-	locksmith      groot.Locksmith
+	fetcher              Fetcher
+	unpacker             Unpacker
+	volumeDriver         VolumeDriver
+	metricsEmitter       groot.MetricsEmitter
+	locksmith            groot.Locksmith
+	maxParallelDownloads int
+	progressReporter     groot.ProgressReporter
+	pipelinedUnpack      bool
 }
 
 func NewBaseImagePuller(fetcher Fetcher, unpacker Unpacker, volumeDriver VolumeDriver, metricsEmitter groot.MetricsEmitter, locksmith groot.Locksmith) *BaseImagePuller {
 	return &BaseImagePuller{
-		fetcher:        fetcher,
-		unpacker:       unpacker,
-		volumeDriver:   volumeDriver,
-		metricsEmitter: metricsEmitter,
-		locksmith:      locksmith,
+		fetcher:              fetcher,
+		unpacker:             unpacker,
+		volumeDriver:         volumeDriver,
+		metricsEmitter:       metricsEmitter,
+		locksmith:            locksmith,
+		maxParallelDownloads: DefaultMaxParallelDownloads,
 	}
 }
 
+func (p *BaseImagePuller) WithMaxParallelDownloads(maxParallelDownloads int) *BaseImagePuller {
+	if maxParallelDownloads > 0 {
+		p.maxParallelDownloads = maxParallelDownloads
+	}
+	return p
+}
+
+// WithPipelinedUnpack makes Pull unpack the missing layers concurrently into
+// their incomplete volumes, as soon as their blobs are downloaded, and only
+// finalize them in chain order
+func (p *BaseImagePuller) WithPipelinedUnpack(pipelinedUnpack bool) *BaseImagePuller {
+	p.pipelinedUnpack = pipelinedUnpack
+	return p
+}
+
+func (p *BaseImagePuller) WithProgressReporter(progressReporter groot.ProgressReporter) *BaseImagePuller {
+	p.progressReporter = progressReporter
+	return p
+}
+
 func (p *BaseImagePuller) FetchBaseImageInfo(logger lager.Logger) (groot.BaseImageInfo, error) {
 	logger = logger.Session("fetching-image-info")
 	logger.Info("starting")
 	defer logger.Info("ending")
 
-	return p.fetcher.BaseImageInfo(logger)
+	baseImageInfo, err := p.fetcher.BaseImageInfo(logger)
+	if err != nil {
+		return groot.BaseImageInfo{}, err
+	}
+
+	p.reportProgress(logger, groot.ProgressEvent{
+		Event:      groot.ProgressManifestResolved,
+		Layers:     len(baseImageInfo.LayerInfos),
+		TotalBytes: p.layersSize(baseImageInfo.LayerInfos),
+	})
+
+	return baseImageInfo, nil
 }
 
 func (p *BaseImagePuller) Pull(logger lager.Logger, baseImageInfo groot.BaseImageInfo, spec groot.BaseImageSpec) error {
@@ -100,7 +140,14 @@ func (p *BaseImagePuller) Pull(logger lager.Logger, baseImageInfo groot.BaseImag
 		return err
 	}
 
-	return p.buildLayer(logger, len(baseImageInfo.LayerInfos)-1, baseImageInfo.LayerInfos, spec)
+	if p.pipelinedUnpack {
+		return p.pipelineLayers(logger, baseImageInfo.LayerInfos, spec)
+	}
+
+	downloads := p.startDownloads(logger, baseImageInfo.LayerInfos, p.firstMissingLayer(logger, baseImageInfo.LayerInfos))
+	defer downloads.stop()
+
+	return p.buildLayer(logger, len(baseImageInfo.LayerInfos)-1, baseImageInfo.LayerInfos, downloads, spec)
 }
 
 func (p *BaseImagePuller) quotaExceeded(logger lager.Logger, layerInfos []groot.LayerInfo, spec groot.BaseImageSpec) error {
@@ -135,7 +182,7 @@ func (p *BaseImagePuller) volumeExists(logger lager.Logger, chainID string) bool
 	return false
 }
 
-func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos []groot.LayerInfo, spec groot.BaseImageSpec) error {
+func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos []groot.LayerInfo, downloads *layerDownloads, spec groot.BaseImageSpec) error {
 	if index < 0 {
 		return nil
 	}
@@ -147,6 +194,7 @@ func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos
 		"parentChainID": layerInfo.ParentChainID,
 	})
 	if p.volumeExists(logger, layerInfo.ChainID) {
+		downloads.discard(layerInfos[:index+1])
 		return nil
 	}
 
@@ -156,11 +204,14 @@ func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos
 	}
 	defer p.locksmith.Unlock(lockFile)
 
+	// another create might have added the layer while this one was waiting for
+	// the lock
 	if p.volumeExists(logger, layerInfo.ChainID) {
+		downloads.discard(layerInfos[:index+1])
 		return nil
 	}
 
-	if err := p.buildLayer(logger, index-1, layerInfos, spec); err != nil {
+	if err := p.buildLayer(logger, index-1, layerInfos, downloads, spec); err != nil {
 		return err
 	}
 
@@ -169,11 +220,218 @@ func (p *BaseImagePuller) buildLayer(logger lager.Logger, index int, layerInfos
 		parentLayerInfo = layerInfos[index-1]
 	}
 
-	return p.downloadLayer(logger, layerInfo, parentLayerInfo, spec)
+	stream, err := downloads.take(logger, layerInfo, nil)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return p.unpackLayer(logger, layerInfo, parentLayerInfo, spec, stream)
+}
+
+type layerUnpack struct {
+	tempVolumeName string
+	volumePath     string
+	volSize        int64
+	err            error
+	done           chan struct{}
+	finalized      chan struct{}
+}
+
+// pipelineLayers unpacks the missing layers concurrently and finalizes them in
+// chain order. Once a layer fails, none of the layers above it is finalized, so
+// that the store never holds a partial chain.
+//
+// The locks are taken from the top of the chain down, the same order as
+// buildLayer, so that pipelined and sequential pulls of the same image do not
+// deadlock. Only the layers still missing once they are locked are
+// downloaded.
+func (p *BaseImagePuller) pipelineLayers(logger lager.Logger, layerInfos []groot.LayerInfo, spec groot.BaseImageSpec) error {
+	logger = logger.Session("pipeline-layers")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	firstMissingLayer := len(layerInfos)
+	for firstMissingLayer > 0 {
+		layerInfo := layerInfos[firstMissingLayer-1]
+		if p.volumeExists(logger, layerInfo.ChainID) {
+			break
+		}
+
+		lockFile, err := p.locksmith.Lock(layerInfo.ChainID)
+		if err != nil {
+			return errorspkg.Wrap(err, "acquiring lock")
+		}
+		defer p.locksmith.Unlock(lockFile)
+
+		if p.volumeExists(logger, layerInfo.ChainID) {
+			break
+		}
+		firstMissingLayer--
+	}
+
+	downloads := p.startDownloads(logger, layerInfos, firstMissingLayer)
+	defer downloads.stop()
+
+	unpacks := make([]*layerUnpack, len(layerInfos))
+	for index := firstMissingLayer; index < len(layerInfos); index++ {
+		unpacks[index] = &layerUnpack{
+			done:      make(chan struct{}),
+			finalized: make(chan struct{}),
+		}
+	}
+
+	abort := make(chan struct{})
+	for index := firstMissingLayer; index < len(layerInfos); index++ {
+		go p.pipelineLayer(logger, index, layerInfos, unpacks, downloads, spec, abort)
+	}
+
+	var pullErr error
+	for index := firstMissingLayer; index < len(layerInfos); index++ {
+		unpack := unpacks[index]
+		<-unpack.done
+
+		if pullErr == nil && unpack.err != nil {
+			pullErr = unpack.err
+			close(abort)
+		}
+
+		if pullErr != nil {
+			if unpack.err == nil {
+				p.destroyVolume(logger, unpack.tempVolumeName)
+			}
+			continue
+		}
+
+		if err := p.finalizeVolume(logger, unpack.tempVolumeName, unpack.volumePath, layerInfos[index].ChainID, unpack.volSize); err != nil {
+			pullErr = err
+			close(abort)
+			continue
+		}
+		close(unpack.finalized)
+	}
+
+	return pullErr
+}
+
+// pipelineLayer unpacks a layer into its incomplete volume. A layer with a
+// base directory needs the contents of its parent, so it waits for the parent
+// to be finalized first.
+//
+// Once the pull is aborted, the layer stops waiting for its parent and for its
+// download. The blobs it did not take are closed by layerDownloads.stop, which
+// gives their download slots back.
+func (p *BaseImagePuller) pipelineLayer(logger lager.Logger, index int, layerInfos []groot.LayerInfo, unpacks []*layerUnpack, downloads *layerDownloads, spec groot.BaseImageSpec, abort chan struct{}) {
+	unpack := unpacks[index]
+	defer close(unpack.done)
+
+	layerInfo := layerInfos[index]
+	var parentLayerInfo groot.LayerInfo
+	if index > 0 {
+		parentLayerInfo = layerInfos[index-1]
+	}
+
+	aborted := errorspkg.Errorf("unpacking layer `%s`: aborted", layerInfo.BlobID)
+	if layerInfo.BaseDirectory != "" && index > 0 && unpacks[index-1] != nil {
+		select {
+		case <-unpacks[index-1].finalized:
+		case <-abort:
+			unpack.err = aborted
+			return
+		}
+	}
+
+	stream, err := downloads.take(logger, layerInfo, abort)
+	if err != nil {
+		unpack.err = err
+		return
+	}
+	defer stream.Close()
+
+	// the pull might have been aborted while the blob was downloading
+	select {
+	case <-abort:
+		unpack.err = aborted
+		return
+	default:
+	}
+
+	unpack.tempVolumeName, unpack.volumePath, unpack.volSize, unpack.err = p.unpackLayerToVolume(logger, layerInfo, parentLayerInfo, spec, stream)
+}
+
+// firstMissingLayer returns the index of the first layer that needs to be
+// downloaded, walking down the chain from the top layer until a volume that
+// already exists is found. The layers are not locked, so buildLayer discards
+// the downloads of the layers that turn out to exist once they are locked.
+func (p *BaseImagePuller) firstMissingLayer(logger lager.Logger, layerInfos []groot.LayerInfo) int {
+	index := len(layerInfos)
+	for index > 0 && !p.volumeExists(logger, layerInfos[index-1].ChainID) {
+		index--
+	}
+
+	return index
+}
+
+// startDownloads downloads the blobs of the layers from firstMissingLayer up
+func (p *BaseImagePuller) startDownloads(logger lager.Logger, layerInfos []groot.LayerInfo, firstMissingLayer int) *layerDownloads {
+	downloads := &layerDownloads{
+		puller:    p,
+		downloads: make(map[string]*layerDownload),
+		cancel:    make(chan struct{}),
+	}
+
+	for _, layerInfo := range layerInfos[firstMissingLayer:] {
+		downloads.downloads[layerInfo.ChainID] = &layerDownload{done: make(chan struct{})}
+	}
+
+	logger.Debug("starting-downloads", lager.Data{
+		"layersCount":          len(layerInfos) - firstMissingLayer,
+		"maxParallelDownloads": p.maxParallelDownloads,
+	})
+
+	downloads.wg.Add(1)
+	go func() {
+		defer downloads.wg.Done()
+
+		slots := make(chan struct{}, p.maxParallelDownloads)
+		for _, layerInfo := range layerInfos[firstMissingLayer:] {
+			download := downloads.downloads[layerInfo.ChainID]
+			if downloads.isDiscarded(download) {
+				close(download.done)
+				continue
+			}
+
+			select {
+			case slots <- struct{}{}:
+			case <-downloads.cancel:
+				return
+			}
+
+			downloads.wg.Add(1)
+			go func(layerInfo groot.LayerInfo) {
+				defer downloads.wg.Done()
+				defer close(download.done)
+
+				release := func() { <-slots }
+				stream, size, err := p.downloadLayer(logger, layerInfo)
+				if err != nil {
+					release()
+					download.err = err
+					return
+				}
+
+				// the slot is only given back once the blob has been consumed, so
+				// that streamed blobs do not keep an unbounded number of
+				// connections open
+				downloads.finish(download, &slotStream{ReadCloser: stream, release: release}, size)
+			}(layerInfo)
+		}
+	}()
 
+	return downloads
 }
 
-func (p *BaseImagePuller) downloadLayer(logger lager.Logger, layerInfo, parentLayerInfo groot.LayerInfo, spec groot.BaseImageSpec) error {
+func (p *BaseImagePuller) downloadLayer(logger lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
 	logger = logger.Session("downloading-layer", lager.Data{"LayerInfo": layerInfo})
 	logger.Debug("starting")
 	defer logger.Debug("ending")
@@ -181,39 +439,185 @@ func (p *BaseImagePuller) downloadLayer(logger lager.Logger, layerInfo, parentLa
 
 	stream, size, err := p.fetcher.StreamBlob(logger, layerInfo)
 	if err != nil {
-		return errorspkg.Wrapf(err, "streaming blob `%s`", layerInfo.BlobID)
+		return nil, 0, errorspkg.Wrapf(err, "streaming blob `%s`", layerInfo.BlobID)
 	}
-	defer stream.Close()
 
 	logger.Debug("got-stream-for-blob", lager.Data{"size": size})
 
-	return p.unpackLayer(logger, layerInfo, parentLayerInfo, spec, stream)
+	return stream, size, nil
+}
+
+type layerDownload struct {
+	stream    io.ReadCloser
+	size      int64
+	err       error
+	taken     bool
+	discarded bool
+	done      chan struct{}
+}
+
+// layerDownloads fetches the blobs of an image concurrently, while the
+// layers are still unpacked one at a time in chain order.
+type layerDownloads struct {
+	puller    *BaseImagePuller
+	downloads map[string]*layerDownload
+	cancel    chan struct{}
+	wg        sync.WaitGroup
+	// mutex guards the streams of the downloads, as they can be discarded
+	// while they finish
+	mutex sync.Mutex
+}
+
+// slotStream gives back its download slot when closed
+type slotStream struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (s *slotStream) Close() error {
+	defer s.once.Do(s.release)
+	return s.ReadCloser.Close()
+}
+
+// take waits for the blob of the layer to be downloaded, unless abort is
+// closed first. A blob that is not taken is closed by stop.
+func (d *layerDownloads) take(logger lager.Logger, layerInfo groot.LayerInfo, abort <-chan struct{}) (io.ReadCloser, error) {
+	download, ok := d.downloads[layerInfo.ChainID]
+	if !ok {
+		// the volume was removed after the downloads were started
+		stream, _, err := d.puller.downloadLayer(logger, layerInfo)
+		return stream, err
+	}
+
+	select {
+	case <-download.done:
+	case <-abort:
+		return nil, errorspkg.Errorf("downloading layer `%s`: aborted", layerInfo.BlobID)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	download.taken = true
+
+	return download.stream, download.err
+}
+
+// discard closes the blobs of layers that turned out to be in the store
+// already, giving their download slots back. The ones that have not been
+// downloaded yet are closed as soon as they are, or skipped altogether.
+func (d *layerDownloads) discard(layerInfos []groot.LayerInfo) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, layerInfo := range layerInfos {
+		download, ok := d.downloads[layerInfo.ChainID]
+		if !ok || download.taken || download.discarded {
+			continue
+		}
+
+		download.discarded = true
+		if download.stream != nil {
+			download.stream.Close()
+			download.stream = nil
+		}
+	}
+}
+
+func (d *layerDownloads) isDiscarded(download *layerDownload) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return download.discarded
+}
+
+func (d *layerDownloads) finish(download *layerDownload, stream io.ReadCloser, size int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if download.discarded {
+		stream.Close()
+		return
+	}
+
+	download.stream = stream
+	download.size = size
+}
+
+func (d *layerDownloads) stop() {
+	close(d.cancel)
+	d.wg.Wait()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, download := range d.downloads {
+		select {
+		case <-download.done:
+		default:
+			continue
+		}
+
+		if !download.taken && download.stream != nil {
+			download.stream.Close()
+		}
+	}
 }
 
 func (p *BaseImagePuller) unpackLayer(logger lager.Logger, layerInfo, parentLayerInfo groot.LayerInfo, spec groot.BaseImageSpec, stream io.ReadCloser) error {
+	tempVolumeName, volumePath, volSize, err := p.unpackLayerToVolume(logger, layerInfo, parentLayerInfo, spec, stream)
+	if err != nil {
+		return err
+	}
+
+	return p.finalizeVolume(logger, tempVolumeName, volumePath, layerInfo.ChainID, volSize)
+}
+
+// unpackLayerToVolume unpacks the layer into a new incomplete volume, which is
+// left for the caller to finalize
+func (p *BaseImagePuller) unpackLayerToVolume(logger lager.Logger, layerInfo, parentLayerInfo groot.LayerInfo, spec groot.BaseImageSpec, stream io.ReadCloser) (string, string, int64, error) {
 	logger = logger.Session("unpacking-layer", lager.Data{"LayerInfo": layerInfo})
 	logger.Debug("starting")
 	defer logger.Debug("ending")
 
 	tempVolumeName, volumePath, err := p.createTemporaryVolumeDirectory(logger, layerInfo, spec)
 	if err != nil {
-		return err
+		return "", "", 0, err
 	}
 
 	unpackSpec := UnpackSpec{
 		TargetPath:    volumePath,
-		Stream:        stream,
+		Stream:        &readErrorRecorder{ReadCloser: stream},
 		UIDMappings:   spec.UIDMappings,
 		GIDMappings:   spec.GIDMappings,
 		BaseDirectory: layerInfo.BaseDirectory,
 	}
 
+	p.reportProgress(logger, groot.ProgressEvent{
+		Event:   groot.ProgressUnpackStarted,
+		BlobID:  layerInfo.BlobID,
+		ChainID: layerInfo.ChainID,
+	})
+
 	volSize, err := p.unpackLayerToTemporaryDirectory(logger, unpackSpec, layerInfo, parentLayerInfo)
 	if err != nil {
-		return err
+		return "", "", 0, err
 	}
 
-	return p.finalizeVolume(logger, tempVolumeName, volumePath, layerInfo.ChainID, volSize)
+	p.reportProgress(logger, groot.ProgressEvent{
+		Event:   groot.ProgressUnpackFinished,
+		BlobID:  layerInfo.BlobID,
+		ChainID: layerInfo.ChainID,
+		Bytes:   volSize,
+	})
+
+	return tempVolumeName, volumePath, volSize, nil
+}
+
+func (p *BaseImagePuller) reportProgress(logger lager.Logger, event groot.ProgressEvent) {
+	if p.progressReporter != nil {
+		p.progressReporter.Report(logger, event)
+	}
 }
 
 func (p *BaseImagePuller) createTemporaryVolumeDirectory(logger lager.Logger, layerInfo groot.LayerInfo, spec groot.BaseImageSpec) (string, string, error) {
@@ -253,12 +657,21 @@ func (p *BaseImagePuller) unpackLayerToTemporaryDirectory(logger lager.Logger, u
 
 	var unpackOutput UnpackOutput
 	if unpackOutput, err = p.unpacker.Unpack(logger, unpackSpec); err != nil {
-		if errD := p.volumeDriver.DestroyVolume(logger, layerInfo.ChainID); errD != nil {
-			logger.Error("volume-cleanup-failed", errD)
+		p.destroyIncompleteVolume(logger, unpackSpec)
+		if readErr := readError(unpackSpec.Stream); readErr != nil {
+			return 0, errorspkg.Wrapf(readErr, "verifying layer `%s`", layerInfo.BlobID)
 		}
 		return 0, errorspkg.Wrapf(err, "unpacking layer `%s`", layerInfo.BlobID)
 	}
 
+	// streamed blobs are only verified once they have been read to the end,
+	// which the unpacker does not necessarily do
+	if _, err := io.Copy(ioutil.Discard, unpackSpec.Stream); err != nil {
+		logger.Error("verifying-layer-failed", err)
+		p.destroyIncompleteVolume(logger, unpackSpec)
+		return 0, errorspkg.Wrapf(err, "verifying layer `%s`", layerInfo.BlobID)
+	}
+
 	if err := p.volumeDriver.HandleOpaqueWhiteouts(logger, path.Base(unpackSpec.TargetPath), unpackOutput.OpaqueWhiteouts); err != nil {
 		logger.Error("handling-opaque-whiteouts", err)
 		return 0, errorspkg.Wrap(err, "handling opaque whiteouts")
@@ -268,6 +681,40 @@ func (p *BaseImagePuller) unpackLayerToTemporaryDirectory(logger lager.Logger, u
 	return unpackOutput.BytesWritten, nil
 }
 
+// readErrorRecorder keeps the error a blob stream failed with, as the
+// unpacker only reports it as a broken input
+type readErrorRecorder struct {
+	io.ReadCloser
+	err error
+}
+
+func (r *readErrorRecorder) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+
+	return n, err
+}
+
+func readError(stream io.Reader) error {
+	if recorder, ok := stream.(*readErrorRecorder); ok {
+		return recorder.err
+	}
+
+	return nil
+}
+
+func (p *BaseImagePuller) destroyIncompleteVolume(logger lager.Logger, unpackSpec UnpackSpec) {
+	p.destroyVolume(logger, path.Base(unpackSpec.TargetPath))
+}
+
+func (p *BaseImagePuller) destroyVolume(logger lager.Logger, id string) {
+	if err := p.volumeDriver.DestroyVolume(logger, id); err != nil {
+		logger.Error("volume-cleanup-failed", err)
+	}
+}
+
 func (p *BaseImagePuller) finalizeVolume(logger lager.Logger, tempVolumeName, volumePath, chainID string, volSize int64) error {
 	if err := p.volumeDriver.WriteVolumeMeta(logger, chainID, VolumeMeta{Size: volSize}); err != nil {
 		return errorspkg.Wrapf(err, "writing volume `%s` metadata", chainID)
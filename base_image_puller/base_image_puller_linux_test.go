@@ -22,6 +22,7 @@ import (
 	"code.cloudfoundry.org/lager/lagertest"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -104,6 +105,32 @@ var _ = Describe("Base Image Puller", func() {
 			Expect(chainIDs(baseImage.LayerInfos)).To(ConsistOf("layer-111", "chain-222", "chain-333"))
 		})
 
+		Context("when a progress reporter is provided", func() {
+			var fakeProgressReporter *grootfakes.FakeProgressReporter
+
+			BeforeEach(func() {
+				layerInfos[0].Size = 100
+				layerInfos[2].Size = 200
+				fakeFetcher.BaseImageInfoReturns(groot.BaseImageInfo{LayerInfos: layerInfos}, nil)
+
+				fakeProgressReporter = new(grootfakes.FakeProgressReporter)
+				baseImagePuller = baseImagePuller.WithProgressReporter(fakeProgressReporter)
+			})
+
+			It("reports the resolved manifest", func() {
+				_, err := baseImagePuller.FetchBaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeProgressReporter.ReportCallCount()).To(Equal(1))
+				_, event := fakeProgressReporter.ReportArgsForCall(0)
+				Expect(event).To(Equal(groot.ProgressEvent{
+					Event:      groot.ProgressManifestResolved,
+					Layers:     3,
+					TotalBytes: 300,
+				}))
+			})
+		})
+
 		Context("when fetching the list of layers fails", func() {
 			BeforeEach(func() {
 				fakeFetcher.BaseImageInfoReturns(groot.BaseImageInfo{
@@ -317,23 +344,392 @@ var _ = Describe("Base Image Puller", func() {
 				return ioutil.NopCloser(buffer), 1200, nil
 			}
 
+			unpackedContents := []string{}
+			fakeUnpacker.UnpackStub = func(_ lager.Logger, unpackSpec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+				gzipReader, err := gzip.NewReader(unpackSpec.Stream)
+				Expect(err).NotTo(HaveOccurred())
+				contents, err := ioutil.ReadAll(gzipReader)
+				Expect(err).NotTo(HaveOccurred())
+				unpackedContents = append(unpackedContents, string(contents))
+
+				return base_image_puller.UnpackOutput{}, nil
+			}
+
 			err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(fakeUnpacker.UnpackCallCount()).To(Equal(3))
+			Expect(unpackedContents).To(Equal([]string{
+				"layer-i-am-a-layer-contents",
+				"layer-i-am-another-layer-contents",
+				"layer-i-am-the-last-layer-contents",
+			}))
+		})
 
-			validateLayer := func(idx int, expected string) {
-				_, unpackSpec := fakeUnpacker.UnpackArgsForCall(idx)
-				gzipReader, err := gzip.NewReader(unpackSpec.Stream)
+		Describe("parallel downloads", func() {
+			var (
+				mutex       *sync.Mutex
+				inFlight    int
+				maxInFlight int
+			)
+
+			BeforeEach(func() {
+				mutex = &sync.Mutex{}
+				inFlight = 0
+				maxInFlight = 0
+
+				fakeFetcher.StreamBlobStub = func(_ lager.Logger, _ groot.LayerInfo) (io.ReadCloser, int64, error) {
+					mutex.Lock()
+					inFlight++
+					if inFlight > maxInFlight {
+						maxInFlight = inFlight
+					}
+					mutex.Unlock()
+
+					time.Sleep(100 * time.Millisecond)
+
+					mutex.Lock()
+					inFlight--
+					mutex.Unlock()
+
+					return ioutil.NopCloser(bytes.NewBuffer([]byte{})), 0, nil
+				}
+			})
+
+			It("downloads the blobs concurrently", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
 				Expect(err).NotTo(HaveOccurred())
-				contents, err := ioutil.ReadAll(gzipReader)
+
+				Expect(fakeFetcher.StreamBlobCallCount()).To(Equal(3))
+				Expect(maxInFlight).To(Equal(3))
+			})
+
+			Context("when the max parallel downloads is set", func() {
+				BeforeEach(func() {
+					baseImagePuller.WithMaxParallelDownloads(2)
+				})
+
+				It("does not exceed the limit", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeFetcher.StreamBlobCallCount()).To(Equal(3))
+					Expect(maxInFlight).To(Equal(2))
+				})
+
+				Context("when another create adds the bottom layers while waiting for a lock", func() {
+					BeforeEach(func() {
+						layerInfos = append(layerInfos, groot.LayerInfo{BlobID: "i-am-layer-4", ChainID: "chain-444", ParentChainID: "chain-333"})
+						baseImageInfo.LayerInfos = layerInfos
+
+						fakeLocksmith.LockStub = func(key string) (*os.File, error) {
+							if key == "chain-222" {
+								Expect(os.MkdirAll(filepath.Join(tmpVolumesDir, "layer-111"), 0777)).To(Succeed())
+								Expect(os.MkdirAll(filepath.Join(tmpVolumesDir, "chain-222"), 0777)).To(Succeed())
+							}
+							return nil, nil
+						}
+					})
+
+					It("gives back the download slots of those layers", func() {
+						errs := make(chan error)
+						go func() {
+							defer GinkgoRecover()
+							errs <- baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+						}()
+
+						var err error
+						Eventually(errs, "2s").Should(Receive(&err))
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(fakeUnpacker.UnpackCallCount()).To(Equal(2))
+						_, unpackSpec := fakeUnpacker.UnpackArgsForCall(0)
+						Expect(unpackSpec.TargetPath).To(ContainSubstring("chain-333-incomplete-"))
+					})
+				})
+			})
+
+			Context("when the blobs finish downloading out of order", func() {
+				BeforeEach(func() {
+					fakeFetcher.StreamBlobStub = func(_ lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
+						if layerInfo.ChainID == "layer-111" {
+							time.Sleep(100 * time.Millisecond)
+						}
+
+						return ioutil.NopCloser(bytes.NewBufferString(layerInfo.BlobID)), 0, nil
+					}
+				})
+
+				It("still unpacks the layers in chain order", func() {
+					unpackedContents := []string{}
+					fakeUnpacker.UnpackStub = func(_ lager.Logger, unpackSpec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+						contents, err := ioutil.ReadAll(unpackSpec.Stream)
+						Expect(err).NotTo(HaveOccurred())
+						unpackedContents = append(unpackedContents, string(contents))
+
+						return base_image_puller.UnpackOutput{}, nil
+					}
+
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeUnpacker.UnpackCallCount()).To(Equal(3))
+					for i, layerInfo := range layerInfos {
+						_, unpackSpec := fakeUnpacker.UnpackArgsForCall(i)
+						Expect(unpackSpec.TargetPath).To(ContainSubstring(layerInfo.ChainID + "-incomplete-"))
+						Expect(unpackedContents[i]).To(Equal(layerInfo.BlobID))
+					}
+				})
+			})
+
+			Context("when one of the downloads fails", func() {
+				var streams []*gbytes.Buffer
+
+				BeforeEach(func() {
+					streams = []*gbytes.Buffer{}
+					fakeFetcher.StreamBlobStub = func(_ lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
+						if layerInfo.ChainID == "layer-111" {
+							return nil, 0, errors.New("failed to stream blob")
+						}
+
+						mutex.Lock()
+						defer mutex.Unlock()
+						stream := gbytes.NewBuffer()
+						streams = append(streams, stream)
+						return stream, 0, nil
+					}
+				})
+
+				It("returns an error", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).To(MatchError(ContainSubstring("failed to stream blob")))
+					Expect(fakeUnpacker.UnpackCallCount()).To(Equal(0))
+				})
+
+				It("closes the streams of the other downloaded blobs", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).To(HaveOccurred())
+
+					Expect(streams).To(HaveLen(2))
+					for _, stream := range streams {
+						Expect(stream.Closed()).To(BeTrue())
+					}
+				})
+			})
+		})
+
+		Describe("pipelined unpack", func() {
+			var (
+				mutex       *sync.Mutex
+				inFlight    int
+				maxInFlight int
+			)
+
+			BeforeEach(func() {
+				mutex = &sync.Mutex{}
+				inFlight = 0
+				maxInFlight = 0
+
+				baseImagePuller.WithPipelinedUnpack(true)
+				fakeUnpacker.UnpackStub = func(_ lager.Logger, unpackSpec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+					mutex.Lock()
+					inFlight++
+					if inFlight > maxInFlight {
+						maxInFlight = inFlight
+					}
+					mutex.Unlock()
+
+					if strings.Contains(unpackSpec.TargetPath, "layer-111-incomplete-") {
+						time.Sleep(200 * time.Millisecond)
+					} else {
+						time.Sleep(100 * time.Millisecond)
+					}
+
+					mutex.Lock()
+					inFlight--
+					mutex.Unlock()
+
+					return base_image_puller.UnpackOutput{}, nil
+				}
+			})
+
+			It("unpacks the layers concurrently", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
 				Expect(err).NotTo(HaveOccurred())
-				Expect(string(contents)).To(Equal(expected))
-			}
 
-			validateLayer(0, "layer-i-am-a-layer-contents")
-			validateLayer(1, "layer-i-am-another-layer-contents")
-			validateLayer(2, "layer-i-am-the-last-layer-contents")
+				Expect(fakeUnpacker.UnpackCallCount()).To(Equal(3))
+				Expect(maxInFlight).To(Equal(3))
+			})
+
+			It("finalizes the volumes in chain order", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeVolumeDriver.MoveVolumeCallCount()).To(Equal(3))
+				for i, layerInfo := range layerInfos {
+					_, from, to := fakeVolumeDriver.MoveVolumeArgsForCall(i)
+					Expect(from).To(ContainSubstring(layerInfo.ChainID + "-incomplete-"))
+					Expect(to).To(Equal(filepath.Join(tmpVolumesDir, layerInfo.ChainID)))
+				}
+			})
+
+			It("uses the locksmith for each layer", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeLocksmith.LockCallCount()).To(Equal(3))
+				Expect(fakeLocksmith.UnlockCallCount()).To(Equal(3))
+
+				for i, layer := range layerInfos {
+					chainID := fakeLocksmith.LockArgsForCall(len(layerInfos) - 1 - i)
+					Expect(chainID).To(Equal(layer.ChainID))
+				}
+			})
+
+			Context("when a layer has a base directory", func() {
+				BeforeEach(func() {
+					layerInfos[1].BaseDirectory = "/"
+				})
+
+				It("unpacks it once its parent is finalized", func() {
+					parentFinalized := false
+					fakeUnpacker.UnpackStub = func(_ lager.Logger, unpackSpec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+						if strings.Contains(unpackSpec.TargetPath, "layer-111-incomplete-") {
+							time.Sleep(100 * time.Millisecond)
+						}
+
+						if strings.Contains(unpackSpec.TargetPath, "chain-222-incomplete-") {
+							_, err := os.Stat(filepath.Join(tmpVolumesDir, "layer-111"))
+							mutex.Lock()
+							parentFinalized = err == nil
+							mutex.Unlock()
+						}
+
+						return base_image_puller.UnpackOutput{}, nil
+					}
+
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeUnpacker.UnpackCallCount()).To(Equal(3))
+					Expect(parentFinalized).To(BeTrue())
+				})
+			})
+
+			Context("when unpacking a layer fails", func() {
+				BeforeEach(func() {
+					fakeVolumeDriver.DestroyVolumeStub = func(_ lager.Logger, id string) error {
+						return os.RemoveAll(filepath.Join(tmpVolumesDir, id))
+					}
+
+					fakeUnpacker.UnpackStub = func(_ lager.Logger, unpackSpec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+						if strings.Contains(unpackSpec.TargetPath, "chain-222-incomplete-") {
+							time.Sleep(100 * time.Millisecond)
+							return base_image_puller.UnpackOutput{}, errors.New("failed to unpack the blob")
+						}
+
+						return base_image_puller.UnpackOutput{}, nil
+					}
+				})
+
+				It("returns an error", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).To(MatchError(ContainSubstring("failed to unpack the blob")))
+				})
+
+				It("only finalizes the layers below it", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).To(HaveOccurred())
+
+					Expect(fakeVolumeDriver.MoveVolumeCallCount()).To(Equal(1))
+					_, _, to := fakeVolumeDriver.MoveVolumeArgsForCall(0)
+					Expect(to).To(Equal(filepath.Join(tmpVolumesDir, "layer-111")))
+				})
+
+				It("deletes the incomplete volumes of the layers above it", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).To(HaveOccurred())
+
+					volumes, err := ioutil.ReadDir(tmpVolumesDir)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(volumes).To(HaveLen(1))
+					Expect(volumes[0].Name()).To(Equal("layer-111"))
+				})
+			})
+
+			Context("when another create adds the bottom layers while waiting for a lock", func() {
+				BeforeEach(func() {
+					layerInfos = append(layerInfos, groot.LayerInfo{BlobID: "i-am-layer-4", ChainID: "chain-444", ParentChainID: "chain-333"})
+					baseImageInfo.LayerInfos = layerInfos
+					baseImagePuller.WithMaxParallelDownloads(2)
+
+					fakeLocksmith.LockStub = func(key string) (*os.File, error) {
+						if key == "chain-222" {
+							Expect(os.MkdirAll(filepath.Join(tmpVolumesDir, "layer-111"), 0777)).To(Succeed())
+							Expect(os.MkdirAll(filepath.Join(tmpVolumesDir, "chain-222"), 0777)).To(Succeed())
+						}
+						return nil, nil
+					}
+				})
+
+				It("only downloads the layers above them", func() {
+					errs := make(chan error)
+					go func() {
+						defer GinkgoRecover()
+						errs <- baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					}()
+
+					var err error
+					Eventually(errs, "2s").Should(Receive(&err))
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeFetcher.StreamBlobCallCount()).To(Equal(2))
+					Expect(fakeUnpacker.UnpackCallCount()).To(Equal(2))
+				})
+			})
+
+			Context("when a layer fails below several layers with a base directory", func() {
+				BeforeEach(func() {
+					layerInfos = append(layerInfos,
+						groot.LayerInfo{BlobID: "i-am-layer-4", ChainID: "chain-444", ParentChainID: "chain-333", BaseDirectory: "/"},
+						groot.LayerInfo{BlobID: "i-am-layer-5", ChainID: "chain-555", ParentChainID: "chain-444", BaseDirectory: "/"},
+						groot.LayerInfo{BlobID: "i-am-layer-6", ChainID: "chain-666", ParentChainID: "chain-555"},
+					)
+					layerInfos[2].BaseDirectory = "/"
+					baseImageInfo.LayerInfos = layerInfos
+					baseImagePuller.WithMaxParallelDownloads(2)
+
+					fakeUnpacker.UnpackStub = func(_ lager.Logger, unpackSpec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+						if strings.Contains(unpackSpec.TargetPath, "chain-222-incomplete-") {
+							time.Sleep(100 * time.Millisecond)
+							return base_image_puller.UnpackOutput{}, errors.New("failed to unpack the blob")
+						}
+
+						return base_image_puller.UnpackOutput{}, nil
+					}
+				})
+
+				It("returns the error without waiting for the layers above it", func() {
+					errs := make(chan error)
+					go func() {
+						defer GinkgoRecover()
+						errs <- baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					}()
+
+					var err error
+					Eventually(errs, "2s").Should(Receive(&err))
+					Expect(err).To(MatchError(ContainSubstring("failed to unpack the blob")))
+				})
+
+				It("does not unpack the layers with a base directory", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).To(HaveOccurred())
+
+					for i := 0; i < fakeUnpacker.UnpackCallCount(); i++ {
+						_, unpackSpec := fakeUnpacker.UnpackArgsForCall(i)
+						Expect(unpackSpec.BaseDirectory).To(BeEmpty())
+					}
+				})
+			})
 		})
 
 		It("writes the metadata for each volume", func() {
@@ -367,6 +763,24 @@ var _ = Describe("Base Image Puller", func() {
 			Eventually(fakeMetricsEmitter.TryEmitDurationFromCallCount).Should(Equal(2 * len(layerInfos)))
 		})
 
+		It("reports the start and finish of each unpack", func() {
+			fakeProgressReporter := new(grootfakes.FakeProgressReporter)
+			baseImagePuller = baseImagePuller.WithProgressReporter(fakeProgressReporter)
+			fakeUnpacker.UnpackReturns(base_image_puller.UnpackOutput{BytesWritten: 1024}, nil)
+
+			err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeProgressReporter.ReportCallCount()).To(Equal(2 * len(layerInfos)))
+			for i, layer := range layerInfos {
+				_, started := fakeProgressReporter.ReportArgsForCall(2 * i)
+				Expect(started).To(Equal(groot.ProgressEvent{Event: groot.ProgressUnpackStarted, BlobID: layer.BlobID, ChainID: layer.ChainID}))
+
+				_, finished := fakeProgressReporter.ReportArgsForCall(2*i + 1)
+				Expect(finished).To(Equal(groot.ProgressEvent{Event: groot.ProgressUnpackFinished, BlobID: layer.BlobID, ChainID: layer.ChainID, Bytes: 1024}))
+			}
+		})
+
 		It("uses the locksmith for each layer", func() {
 			err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
 			Expect(err).NotTo(HaveOccurred())
@@ -629,6 +1043,61 @@ var _ = Describe("Base Image Puller", func() {
 			})
 		})
 
+		Context("when the blob fails verification after being unpacked", func() {
+			BeforeEach(func() {
+				fakeFetcher.StreamBlobStub = func(_ lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
+					if layerInfo.ChainID == "chain-333" {
+						return ioutil.NopCloser(io.MultiReader(
+							bytes.NewBufferString("trailing-data"),
+							&failingReader{err: errors.New("diffID digest mismatch")},
+						)), 0, nil
+					}
+
+					return ioutil.NopCloser(bytes.NewBuffer([]byte{})), 0, nil
+				}
+			})
+
+			It("returns an error", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+				Expect(err).To(MatchError(ContainSubstring("verifying layer")))
+				Expect(err).To(MatchError(ContainSubstring("diffID digest mismatch")))
+			})
+
+			It("deletes the incomplete volume", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+				Expect(err).To(HaveOccurred())
+
+				Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(1))
+				_, path := fakeVolumeDriver.DestroyVolumeArgsForCall(0)
+				Expect(path).To(MatchRegexp("chain-333-incomplete-\\d*-\\d*"))
+			})
+
+			It("does not finalize the volume", func() {
+				err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+				Expect(err).To(HaveOccurred())
+
+				Expect(fakeVolumeDriver.MoveVolumeCallCount()).To(Equal(2))
+			})
+
+			Context("and the unpacker fails because of it", func() {
+				BeforeEach(func() {
+					fakeUnpacker.UnpackStub = func(_ lager.Logger, unpackSpec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+						if _, err := ioutil.ReadAll(unpackSpec.Stream); err != nil {
+							return base_image_puller.UnpackOutput{}, errors.New("unexpected EOF")
+						}
+
+						return base_image_puller.UnpackOutput{}, nil
+					}
+				})
+
+				It("returns the verification error", func() {
+					err := baseImagePuller.Pull(logger, baseImageInfo, groot.BaseImageSpec{})
+					Expect(err).To(MatchError(ContainSubstring("verifying layer")))
+					Expect(err).To(MatchError(ContainSubstring("diffID digest mismatch")))
+				})
+			})
+		})
+
 		Context("when unpacking a blob fails", func() {
 			BeforeEach(func() {
 				count := 0
@@ -653,7 +1122,7 @@ var _ = Describe("Base Image Puller", func() {
 
 				Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(1))
 				_, path := fakeVolumeDriver.DestroyVolumeArgsForCall(0)
-				Expect(path).To(Equal("chain-333"))
+				Expect(path).To(MatchRegexp("chain-333-incomplete-\\d*-\\d*"))
 			})
 
 			It("emits a metric with the unpack and download time for each layer", func() {
@@ -716,7 +1185,7 @@ var _ = Describe("Base Image Puller", func() {
 
 					Expect(fakeVolumeDriver.DestroyVolumeCallCount()).To(Equal(1))
 					_, path := fakeVolumeDriver.DestroyVolumeArgsForCall(0)
-					Expect(path).To(Equal("chain-333"))
+					Expect(path).To(MatchRegexp("chain-333-incomplete-\\d*-\\d*"))
 				})
 			})
 		})
@@ -730,3 +1199,11 @@ func chainIDs(layerInfos []groot.LayerInfo) []string {
 	}
 	return chainIDs
 }
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(_ []byte) (int, error) {
+	return 0, r.err
+}
@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/exec"
+	"strings"
+	"sync"
 	"syscall"
 
 	"code.cloudfoundry.org/commandrunner"
 	"github.com/containers/storage/pkg/reexec"
 	"github.com/tscolari/lagregator"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
 
 	"code.cloudfoundry.org/grootfs/base_image_puller"
 	"code.cloudfoundry.org/grootfs/groot"
@@ -18,6 +24,8 @@ import (
 	errorspkg "github.com/pkg/errors"
 )
 
+const maxUnpackMessageSize = 64 * 1024
+
 //go:generate counterfeiter . IDMapper
 
 type IDMapper interface {
@@ -25,10 +33,35 @@ type IDMapper interface {
 	MapGIDs(logger lager.Logger, pid int, mappings []groot.IDMappingSpec) error
 }
 
+// NSIdMapperUnpacker unpacks the layers in a helper process that runs in a
+// user namespace. The helper is started, and its ids mapped, on the first
+// unpack, and is reused for the following layers until Close is called. It
+// unpacks one layer at a time.
 type NSIdMapperUnpacker struct {
 	commandRunner  commandrunner.CommandRunner
 	idMapper       IDMapper
 	unpackStrategy UnpackStrategy
+
+	helperMutex *sync.Mutex
+	helper      *unpackHelper
+}
+
+type unpackHelper struct {
+	cmd    *exec.Cmd
+	conn   *net.UnixConn
+	output *bytes.Buffer
+}
+
+// unpackRequest is sent to the helper over the control socket, together with
+// the read end of a pipe carrying the layer stream
+type unpackRequest struct {
+	TargetPath    string
+	BaseDirectory string
+}
+
+type unpackResponse struct {
+	Output base_image_puller.UnpackOutput
+	Error  string
 }
 
 func init() {
@@ -38,30 +71,41 @@ func init() {
 		os.Exit(1)
 	}
 
+	// the unpack command waits for its ids to be mapped, and execs the unpack
+	// server, which gets the capabilities of the namespace root
 	reexec.Register("unpack", func() {
 		cli.ErrWriter = os.Stdout
 		logger := lager.NewLogger("unpack")
 		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
 
-		if len(os.Args) != 4 {
-			fail(logger, "parsing-command", errorspkg.New("destination directory or filesystem were not specified"))
+		if len(os.Args) != 2 {
+			fail(logger, "parsing-command", errorspkg.New("unpack strategy was not specified"))
 		}
 
-		ctrlPipeR := os.NewFile(3, "/ctrl/pipe")
 		buffer := make([]byte, 1)
-		logger.Debug("waiting-for-control-pipe")
-		_, err := ctrlPipeR.Read(buffer)
+		logger.Debug("waiting-for-control-socket")
+		n, err := unix.Read(3, buffer)
 		if err != nil {
-			fail(logger, "reading-control-pipe", err)
+			fail(logger, "reading-control-socket", err)
+		}
+		if n == 0 {
+			logger.Debug("control-socket-closed")
+			return
+		}
+		logger.Debug("got-back-from-control-socket")
+
+		if err := unix.Exec("/proc/self/exe", []string{"unpack-server", os.Args[1]}, os.Environ()); err != nil {
+			fail(logger, "exec-unpack-server", err)
 		}
-		logger.Debug("got-back-from-control-pipe")
+	})
 
-		targetDir := os.Args[1]
-		baseDirectory := os.Args[2]
-		unpackStrategyJSON := os.Args[3]
+	reexec.Register("unpack-server", func() {
+		cli.ErrWriter = os.Stdout
+		logger := lager.NewLogger("unpack")
+		logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.DEBUG))
 
 		var unpackStrategy UnpackStrategy
-		if err = json.Unmarshal([]byte(unpackStrategyJSON), &unpackStrategy); err != nil {
+		if err := json.Unmarshal([]byte(os.Args[1]), &unpackStrategy); err != nil {
 			fail(logger, "unmarshal-unpack-strategy-failed", err)
 		}
 
@@ -70,26 +114,83 @@ func init() {
 			fail(logger, "creating-tar-unpacker", err)
 		}
 
-		var unpackOutput base_image_puller.UnpackOutput
-		if unpackOutput, err = unpacker.Unpack(logger, base_image_puller.UnpackSpec{
-			Stream:        os.Stdin,
-			TargetPath:    targetDir,
-			BaseDirectory: baseDirectory,
-		}); err != nil {
-			fail(logger, "unpacking-failed", err)
+		ctrlSocket, err := net.FileConn(os.NewFile(3, "/ctrl/socket"))
+		if err != nil {
+			fail(logger, "opening-control-socket", err)
 		}
+		ctrlConn := ctrlSocket.(*net.UnixConn)
+
+		for {
+			logger.Debug("waiting-for-unpack-request")
+			request, stream, err := receiveUnpackRequest(ctrlConn)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fail(logger, "receiving-unpack-request", err)
+			}
 
-		json.NewEncoder(os.Stdout).Encode(unpackOutput)
+			var response unpackResponse
+			response.Output, err = unpacker.unpackInThread(logger, base_image_puller.UnpackSpec{
+				Stream:        stream,
+				TargetPath:    request.TargetPath,
+				BaseDirectory: request.BaseDirectory,
+			})
+			_ = stream.Close()
+			if err != nil {
+				logger.Error("unpacking-failed", err)
+				response.Error = err.Error()
+			}
 
-		logger.Debug("unpack-command-ending")
+			responseJSON, err := json.Marshal(response)
+			if err != nil {
+				fail(logger, "marshaling-unpack-response", err)
+			}
+			if _, _, err := ctrlConn.WriteMsgUnix(responseJSON, nil, nil); err != nil {
+				fail(logger, "sending-unpack-response", err)
+			}
+		}
+
+		logger.Debug("unpack-server-ending")
 	})
 }
 
+func receiveUnpackRequest(conn *net.UnixConn) (unpackRequest, *os.File, error) {
+	buffer := make([]byte, maxUnpackMessageSize)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buffer, oob)
+	if err != nil {
+		return unpackRequest{}, nil, err
+	}
+	if n == 0 {
+		return unpackRequest{}, nil, io.EOF
+	}
+
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) != 1 {
+		return unpackRequest{}, nil, errorspkg.New("unpack request has no layer stream")
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) != 1 {
+		return unpackRequest{}, nil, errorspkg.New("unpack request has no layer stream")
+	}
+	stream := os.NewFile(uintptr(fds[0]), "/layer/stream")
+
+	var request unpackRequest
+	if err := json.Unmarshal(buffer[:n], &request); err != nil {
+		_ = stream.Close()
+		return unpackRequest{}, nil, errorspkg.Wrap(err, "parsing unpack request")
+	}
+
+	return request, stream, nil
+}
+
 func NewNSIdMapperUnpacker(commandRunner commandrunner.CommandRunner, idMapper IDMapper, strategy UnpackStrategy) *NSIdMapperUnpacker {
 	return &NSIdMapperUnpacker{
 		commandRunner:  commandRunner,
 		idMapper:       idMapper,
 		unpackStrategy: strategy,
+		helperMutex:    &sync.Mutex{},
 	}
 }
 
@@ -98,19 +199,67 @@ func (u *NSIdMapperUnpacker) Unpack(logger lager.Logger, spec base_image_puller.
 	logger.Debug("starting")
 	defer logger.Debug("ending")
 
-	ctrlPipeR, ctrlPipeW, err := os.Pipe()
+	u.helperMutex.Lock()
+	defer u.helperMutex.Unlock()
+
+	if u.helper == nil {
+		helper, err := u.startHelper(logger, spec)
+		if err != nil {
+			return base_image_puller.UnpackOutput{}, err
+		}
+		u.helper = helper
+	}
+
+	unpackOutput, err := u.sendUnpackRequest(logger, spec)
+	if err != nil {
+		return base_image_puller.UnpackOutput{}, err
+	}
+
+	return unpackOutput, nil
+}
+
+// Close stops the unpack helper
+func (u *NSIdMapperUnpacker) Close() error {
+	u.helperMutex.Lock()
+	defer u.helperMutex.Unlock()
+
+	if u.helper == nil {
+		return nil
+	}
+
+	helper := u.helper
+	u.helper = nil
+	_ = helper.conn.Close()
+	if err := u.commandRunner.Wait(helper.cmd); err != nil {
+		return errorspkg.Wrapf(err, "stopping unpack helper: %s", strings.TrimSpace(helper.output.String()))
+	}
+
+	return nil
+}
+
+func (u *NSIdMapperUnpacker) startHelper(logger lager.Logger, spec base_image_puller.UnpackSpec) (*unpackHelper, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
 	if err != nil {
-		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "creating tar control pipe")
+		return nil, errorspkg.Wrap(err, "creating unpack control socket")
+	}
+	ctrlSocketParent := os.NewFile(uintptr(fds[0]), "/ctrl/socket/parent")
+	ctrlSocketChild := os.NewFile(uintptr(fds[1]), "/ctrl/socket/child")
+	defer ctrlSocketChild.Close()
+
+	ctrlConn, err := net.FileConn(ctrlSocketParent)
+	_ = ctrlSocketParent.Close()
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "opening unpack control socket")
 	}
 
 	unpackStrategyJSON, err := json.Marshal(&u.unpackStrategy)
 	if err != nil {
 		logger.Error("unmarshal-unpack-strategy-failed", err)
-		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "unmarshal unpack strategy")
+		_ = ctrlConn.Close()
+		return nil, errorspkg.Wrap(err, "unmarshal unpack strategy")
 	}
 
-	unpackCmd := reexec.Command("unpack", spec.TargetPath, spec.BaseDirectory, string(unpackStrategyJSON))
-	unpackCmd.Stdin = spec.Stream
+	unpackCmd := reexec.Command("unpack", string(unpackStrategyJSON))
 	if len(spec.UIDMappings) > 0 || len(spec.GIDMappings) > 0 {
 		unpackCmd.SysProcAttr = &syscall.SysProcAttr{
 			Cloneflags: syscall.CLONE_NEWUSER,
@@ -120,40 +269,109 @@ func (u *NSIdMapperUnpacker) Unpack(logger lager.Logger, spec base_image_puller.
 	outBuffer := bytes.NewBuffer([]byte{})
 	unpackCmd.Stdout = outBuffer
 	unpackCmd.Stderr = lagregator.NewRelogger(logger)
-	unpackCmd.ExtraFiles = []*os.File{ctrlPipeR}
+	unpackCmd.ExtraFiles = []*os.File{ctrlSocketChild}
 
 	logger.Debug("starting-unpack-command", lager.Data{
 		"path": unpackCmd.Path,
 		"args": unpackCmd.Args,
 	})
 	if err := u.commandRunner.Start(unpackCmd); err != nil {
-		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "starting unpack command")
+		_ = ctrlConn.Close()
+		return nil, errorspkg.Wrap(err, "starting unpack command")
 	}
 	logger.Debug("unpack-command-is-started")
 
+	// the helper exits once the control socket is closed
 	if err := u.setIDMappings(logger, spec, unpackCmd.Process.Pid); err != nil {
-		_ = ctrlPipeW.Close()
-		return base_image_puller.UnpackOutput{}, err
+		_ = ctrlConn.Close()
+		_ = u.commandRunner.Wait(unpackCmd)
+		return nil, err
 	}
 
-	if _, err := ctrlPipeW.Write([]byte{0}); err != nil {
-		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "writing to tar control pipe")
+	if _, err := ctrlConn.Write([]byte{0}); err != nil {
+		_ = ctrlConn.Close()
+		_ = u.commandRunner.Wait(unpackCmd)
+		return nil, errorspkg.Wrap(err, "writing to unpack control socket")
 	}
 	logger.Debug("unpack-command-is-signaled-to-continue")
 
-	logger.Debug("waiting-for-unpack-command")
-	if err := u.commandRunner.Wait(unpackCmd); err != nil {
-		return base_image_puller.UnpackOutput{}, errorspkg.Errorf(outBuffer.String())
+	return &unpackHelper{
+		cmd:    unpackCmd,
+		conn:   ctrlConn.(*net.UnixConn),
+		output: outBuffer,
+	}, nil
+}
+
+func (u *NSIdMapperUnpacker) sendUnpackRequest(logger lager.Logger, spec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+	requestJSON, err := json.Marshal(unpackRequest{
+		TargetPath:    spec.TargetPath,
+		BaseDirectory: spec.BaseDirectory,
+	})
+	if err != nil {
+		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "marshaling unpack request")
+	}
+
+	streamR, streamW, err := os.Pipe()
+	if err != nil {
+		return base_image_puller.UnpackOutput{}, errorspkg.Wrap(err, "creating layer stream pipe")
+	}
+
+	_, _, err = u.helper.conn.WriteMsgUnix(requestJSON, unix.UnixRights(int(streamR.Fd())), nil)
+	_ = streamR.Close()
+	if err != nil {
+		_ = streamW.Close()
+		return base_image_puller.UnpackOutput{}, u.helperFailure(logger, errorspkg.Wrap(err, "sending unpack request"))
+	}
+	logger.Debug("unpack-request-sent")
+
+	streamCopied := make(chan struct{})
+	go func() {
+		defer close(streamCopied)
+		defer streamW.Close()
+		if spec.Stream == nil {
+			return
+		}
+		// the helper might not read the padding at the end of the tar
+		if _, err := io.Copy(streamW, spec.Stream); err != nil && !isBrokenPipe(err) {
+			logger.Error("streaming-layer-to-unpack-command", err)
+		}
+	}()
+	defer func() { <-streamCopied }()
+
+	buffer := make([]byte, maxUnpackMessageSize)
+	n, _, _, _, err := u.helper.conn.ReadMsgUnix(buffer, nil)
+	if err != nil || n == 0 {
+		return base_image_puller.UnpackOutput{}, u.helperFailure(logger, errorspkg.New("unpack command exited"))
 	}
-	logger.Debug("unpack-command-done")
 
-	var unpackOutput base_image_puller.UnpackOutput
-	if err := json.NewDecoder(outBuffer).Decode(&unpackOutput); err != nil {
+	var response unpackResponse
+	if err := json.Unmarshal(buffer[:n], &response); err != nil {
 		logger.Error("invalid-output-from-unpack", err)
 		return base_image_puller.UnpackOutput{}, errorspkg.Wrapf(err, "invalid unpack output (%s)", err.Error())
 	}
 
-	return unpackOutput, nil
+	if response.Error != "" {
+		return base_image_puller.UnpackOutput{}, errorspkg.New(response.Error)
+	}
+
+	return response.Output, nil
+}
+
+// helperFailure reaps the helper after it stopped answering, and returns its
+// output as the error
+func (u *NSIdMapperUnpacker) helperFailure(logger lager.Logger, err error) error {
+	helper := u.helper
+	u.helper = nil
+	_ = helper.conn.Close()
+
+	if waitErr := u.commandRunner.Wait(helper.cmd); waitErr != nil {
+		logger.Error("unpack-command-failed", waitErr)
+	}
+
+	if output := strings.TrimSpace(helper.output.String()); output != "" {
+		return errorspkg.New(output)
+	}
+	return err
 }
 
 func (u *NSIdMapperUnpacker) setIDMappings(logger lager.Logger, spec base_image_puller.UnpackSpec, untarPid int) error {
@@ -173,3 +391,8 @@ func (u *NSIdMapperUnpacker) setIDMappings(logger lager.Logger, spec base_image_
 
 	return nil
 }
+
+func isBrokenPipe(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	return ok && pathErr.Err == syscall.EPIPE
+}
@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 
 	"code.cloudfoundry.org/commandrunner/fake_command_runner"
@@ -18,12 +21,90 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/st3v/glager"
+	"golang.org/x/sys/unix"
 )
 
+// fakeUnpackServer plays the unpack command on the other end of the control
+// socket
+type fakeUnpackServer struct {
+	mutex            sync.Mutex
+	signaled         bool
+	mappedUIDsCalls  int
+	requests         []map[string]string
+	streams          []string
+	response         string
+	exitOnRequest    bool
+	controlSocketEOF chan struct{}
+}
+
+func (s *fakeUnpackServer) serve(conn *net.UnixConn, fakeIDMapper *unpackerfakes.FakeIDMapper) {
+	defer GinkgoRecover()
+	defer close(s.controlSocketEOF)
+	defer conn.Close()
+
+	buffer := make([]byte, 64*1024)
+	oob := make([]byte, unix.CmsgSpace(4))
+
+	n, err := conn.Read(buffer)
+	if err != nil || n == 0 {
+		return
+	}
+	s.mutex.Lock()
+	s.signaled = true
+	s.mappedUIDsCalls = fakeIDMapper.MapUIDsCallCount()
+	s.mutex.Unlock()
+
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buffer, oob)
+		if err != nil || n == 0 {
+			return
+		}
+
+		request := map[string]string{}
+		Expect(json.Unmarshal(buffer[:n], &request)).To(Succeed())
+
+		messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+		Expect(err).NotTo(HaveOccurred())
+		fds, err := unix.ParseUnixRights(&messages[0])
+		Expect(err).NotTo(HaveOccurred())
+		stream := os.NewFile(uintptr(fds[0]), "stream")
+		contents, err := ioutil.ReadAll(stream)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stream.Close()).To(Succeed())
+
+		s.mutex.Lock()
+		s.requests = append(s.requests, request)
+		s.streams = append(s.streams, string(contents))
+		exitOnRequest := s.exitOnRequest
+		response := s.response
+		s.mutex.Unlock()
+
+		if exitOnRequest {
+			return
+		}
+
+		_, err = conn.Write([]byte(response))
+		Expect(err).NotTo(HaveOccurred())
+	}
+}
+
+func (s *fakeUnpackServer) Requests() []map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests
+}
+
+func (s *fakeUnpackServer) Streams() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streams
+}
+
 var _ = Describe("NSIdMapperUnpacker", func() {
 	var (
 		fakeIDMapper      *unpackerfakes.FakeIDMapper
 		fakeCommandRunner *fake_command_runner.FakeCommandRunner
+		unpackServer      *fakeUnpackServer
 		unpacker          *unpackerpkg.NSIdMapperUnpacker
 
 		logger         *TestLogger
@@ -32,7 +113,7 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 		unpackStrategy unpackerpkg.UnpackStrategy
 
 		commandError error
-		reexecOutput string
+		emitLogs     bool
 	)
 
 	BeforeEach(func() {
@@ -42,7 +123,15 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 		fakeCommandRunner = fake_command_runner.New()
 		unpackStrategy.Name = "defaultfs"
 		unpacker = unpackerpkg.NewNSIdMapperUnpacker(fakeCommandRunner, fakeIDMapper, unpackStrategy)
-		reexecOutput = ""
+
+		outputJSON, err := json.Marshal(map[string]interface{}{
+			"Output": base_image_puller.UnpackOutput{BytesWritten: 1024},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		unpackServer = &fakeUnpackServer{
+			response:         string(outputJSON),
+			controlSocketEOF: make(chan struct{}),
+		}
 
 		logger = NewLogger("test-store")
 
@@ -51,6 +140,7 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 		targetPath = filepath.Join(imagePath, "rootfs")
 
 		commandError = nil
+		emitLogs = false
 	})
 
 	JustBeforeEach(func() {
@@ -61,24 +151,33 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 				Pid: 12, // don't panic
 			}
 
-			if reexecOutput != "" {
-				_, err := cmd.Stdout.Write([]byte(reexecOutput))
-				Expect(err).NotTo(HaveOccurred())
+			if commandError != nil {
+				return commandError
 			}
 
-			Expect(json.NewEncoder(cmd.Stdout).Encode(base_image_puller.UnpackOutput{BytesWritten: 1024})).To(Succeed())
-			return commandError
+			if emitLogs {
+				logger := lager.NewLogger("fake-unpack")
+				logger.RegisterSink(lager.NewWriterSink(cmd.Stderr, lager.DEBUG))
+				logger.Debug("foo")
+				logger.Info("bar")
+			}
+
+			conn, err := net.FileConn(cmd.ExtraFiles[0])
+			Expect(err).NotTo(HaveOccurred())
+			go unpackServer.serve(conn.(*net.UnixConn), fakeIDMapper)
+
+			return nil
 		})
 	})
 
 	AfterEach(func() {
+		Expect(unpacker.Close()).To(Succeed())
 		Expect(os.RemoveAll(imagePath)).To(Succeed())
 	})
 
-	It("passes the rootfs path, base-directory and filesystem to the unpack command", func() {
+	It("starts the unpack command with the unpack strategy", func() {
 		_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
-			TargetPath:    targetPath,
-			BaseDirectory: "/base-folder/",
+			TargetPath: targetPath,
 		})
 		Expect(err).NotTo(HaveOccurred())
 
@@ -89,7 +188,19 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 		Expect(commands).To(HaveLen(1))
 		Expect(commands[0].Path).To(Equal("/proc/self/exe"))
 		Expect(commands[0].Args).To(Equal([]string{
-			"unpack", targetPath, "/base-folder/", string(unpackStrategyJson),
+			"unpack", string(unpackStrategyJson),
+		}))
+	})
+
+	It("sends the rootfs path and base-directory to the unpack command", func() {
+		_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
+			TargetPath:    targetPath,
+			BaseDirectory: "/base-folder/",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(unpackServer.Requests()).To(Equal([]map[string]string{
+			{"TargetPath": targetPath, "BaseDirectory": "/base-folder/"},
 		}))
 	})
 
@@ -102,25 +213,13 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 	})
 
 	It("passes the provided stream to the unpack command", func() {
-		streamR, streamW, err := os.Pipe()
-		Expect(err).NotTo(HaveOccurred())
-
-		_, err = unpacker.Unpack(logger, base_image_puller.UnpackSpec{
-			Stream:     streamR,
+		_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
+			Stream:     ioutil.NopCloser(strings.NewReader("hello-world")),
 			TargetPath: targetPath,
 		})
 		Expect(err).NotTo(HaveOccurred())
 
-		_, err = streamW.WriteString("hello-world")
-		Expect(err).NotTo(HaveOccurred())
-		Expect(streamW.Close()).To(Succeed())
-
-		commands := fakeCommandRunner.StartedCommands()
-		Expect(commands).To(HaveLen(1))
-
-		contents, err := ioutil.ReadAll(commands[0].Stdin)
-		Expect(err).NotTo(HaveOccurred())
-		Expect(string(contents)).To(Equal("hello-world"))
+		Expect(unpackServer.Streams()).To(Equal([]string{"hello-world"}))
 	})
 
 	It("starts the unpack command in a user namespace", func() {
@@ -137,35 +236,56 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 		Expect(commands[0].SysProcAttr.Cloneflags).To(Equal(uintptr(syscall.CLONE_NEWUSER)))
 	})
 
-	It("re-logs the log lines emitted by the unpack command", func() {
-		fakeCommandRunner.WhenWaitingFor(fake_command_runner.CommandSpec{
-			Path: "/proc/self/exe",
-		}, func(cmd *exec.Cmd) error {
-			logger := lager.NewLogger("fake-unpack")
-			logger.RegisterSink(lager.NewWriterSink(cmd.Stderr, lager.DEBUG))
-			logger.Debug("foo")
-			logger.Info("bar")
-			return nil
+	Context("when the unpack command logs", func() {
+		BeforeEach(func() {
+			emitLogs = true
 		})
 
-		_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
-			TargetPath: targetPath,
+		It("re-logs the log lines", func() {
+			_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
+				TargetPath: targetPath,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(logger).To(ContainSequence(
+				Debug(
+					Message("test-store.ns-id-mapper-unpacking.fake-unpack.foo"),
+				),
+				Info(
+					Message("test-store.ns-id-mapper-unpacking.fake-unpack.bar"),
+				),
+			))
 		})
-		Expect(err).NotTo(HaveOccurred())
+	})
 
-		Expect(logger).To(ContainSequence(
-			Debug(
-				Message("test-store.ns-id-mapper-unpacking.fake-unpack.foo"),
-			),
-			Info(
-				Message("test-store.ns-id-mapper-unpacking.fake-unpack.bar"),
-			),
-		))
+	Context("when more layers are unpacked", func() {
+		It("reuses the unpack command", func() {
+			spec := base_image_puller.UnpackSpec{
+				TargetPath: targetPath,
+				UIDMappings: []groot.IDMappingSpec{
+					{HostID: 1000, NamespaceID: 2000, Size: 10},
+				},
+			}
+			_, err := unpacker.Unpack(logger, spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			spec.TargetPath = filepath.Join(imagePath, "another-rootfs")
+			_, err = unpacker.Unpack(logger, spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeCommandRunner.StartedCommands()).To(HaveLen(1))
+			Expect(fakeIDMapper.MapUIDsCallCount()).To(Equal(1))
+			Expect(unpackServer.Requests()).To(HaveLen(2))
+			Expect(unpackServer.Requests()[1]["TargetPath"]).To(Equal(spec.TargetPath))
+		})
 	})
 
 	Context("when the unpack prints invalid output", func() {
+		BeforeEach(func() {
+			unpackServer.response = "not a valid thing {{}))"
+		})
+
 		It("returns an error", func() {
-			reexecOutput = "not a valid thing {{}))"
 			_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
 				TargetPath: targetPath,
 			})
@@ -186,20 +306,20 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 		})
 	})
 
-	It("signals the unpack command to continue using the contol pipe", func(done Done) {
+	It("signals the unpack command to continue after mapping the ids", func() {
 		_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
 			TargetPath: targetPath,
+			UIDMappings: []groot.IDMappingSpec{
+				{HostID: 1000, NamespaceID: 2000, Size: 10},
+			},
 		})
 		Expect(err).NotTo(HaveOccurred())
 
-		commands := fakeCommandRunner.StartedCommands()
-		Expect(commands).To(HaveLen(1))
-		buffer := make([]byte, 1)
-		_, err = commands[0].ExtraFiles[0].Read(buffer)
-		Expect(err).NotTo(HaveOccurred())
-
-		close(done)
-	}, 1.0)
+		unpackServer.mutex.Lock()
+		defer unpackServer.mutex.Unlock()
+		Expect(unpackServer.signaled).To(BeTrue())
+		Expect(unpackServer.mappedUIDsCalls).To(Equal(1))
+	})
 
 	Describe("UIDMappings", func() {
 		It("applies the provided uid mappings", func() {
@@ -224,7 +344,7 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 				fakeIDMapper.MapUIDsReturns(errors.New("Boom!"))
 			})
 
-			It("closes the control pipe", func() {
+			It("closes the control socket and waits for the unpack command", func() {
 				_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
 					TargetPath: targetPath,
 					UIDMappings: []groot.IDMappingSpec{
@@ -233,11 +353,9 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 				})
 				Expect(err).To(HaveOccurred())
 
-				commands := fakeCommandRunner.StartedCommands()
-				Expect(commands).To(HaveLen(1))
-				buffer := make([]byte, 1)
-				_, err = commands[0].ExtraFiles[0].Read(buffer)
-				Expect(err).To(HaveOccurred())
+				Eventually(unpackServer.controlSocketEOF).Should(BeClosed())
+				Expect(unpackServer.signaled).To(BeFalse())
+				Expect(fakeCommandRunner.WaitedCommands()).To(HaveLen(1))
 			})
 		})
 	})
@@ -265,7 +383,7 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 				fakeIDMapper.MapGIDsReturns(errors.New("Boom!"))
 			})
 
-			It("closes the control pipe", func() {
+			It("closes the control socket", func() {
 				_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
 					TargetPath: targetPath,
 					GIDMappings: []groot.IDMappingSpec{
@@ -274,11 +392,8 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 				})
 				Expect(err).To(HaveOccurred())
 
-				commands := fakeCommandRunner.StartedCommands()
-				Expect(commands).To(HaveLen(1))
-				buffer := make([]byte, 1)
-				_, err = commands[0].ExtraFiles[0].Read(buffer)
-				Expect(err).To(HaveOccurred())
+				Eventually(unpackServer.controlSocketEOF).Should(BeClosed())
+				Expect(unpackServer.signaled).To(BeFalse())
 			})
 		})
 	})
@@ -296,8 +411,40 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 		})
 	})
 
-	Context("when the unpack command fails", func() {
+	Context("when unpacking the layer fails", func() {
 		BeforeEach(func() {
+			errorJSON, err := json.Marshal(map[string]string{"Error": "hello-world"})
+			Expect(err).NotTo(HaveOccurred())
+			unpackServer.response = string(errorJSON)
+		})
+
+		It("returns the error", func() {
+			_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
+				TargetPath: targetPath,
+			})
+			Expect(err).To(MatchError("hello-world"))
+		})
+
+		It("keeps the unpack command for the following layers", func() {
+			_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
+				TargetPath: targetPath,
+			})
+			Expect(err).To(HaveOccurred())
+
+			_, err = unpacker.Unpack(logger, base_image_puller.UnpackSpec{
+				TargetPath: targetPath,
+			})
+			Expect(err).To(HaveOccurred())
+
+			Expect(fakeCommandRunner.StartedCommands()).To(HaveLen(1))
+			Expect(unpackServer.Requests()).To(HaveLen(2))
+		})
+	})
+
+	Context("when the unpack command exits", func() {
+		BeforeEach(func() {
+			unpackServer.exitOnRequest = true
+
 			fakeCommandRunner.WhenWaitingFor(fake_command_runner.CommandSpec{
 				Path: "/proc/self/exe",
 			}, func(cmd *exec.Cmd) error {
@@ -307,18 +454,32 @@ var _ = Describe("NSIdMapperUnpacker", func() {
 			})
 		})
 
-		It("returns an error", func() {
+		It("returns the command output", func() {
 			_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
 				TargetPath: targetPath,
 			})
-			Expect(err).To(HaveOccurred())
+			Expect(err).To(MatchError(ContainSubstring("hello-world")))
+			Expect(fakeCommandRunner.WaitedCommands()).To(HaveLen(1))
 		})
+	})
 
-		It("returns the command output", func() {
+	Describe("Close", func() {
+		It("stops the unpack command", func() {
 			_, err := unpacker.Unpack(logger, base_image_puller.UnpackSpec{
 				TargetPath: targetPath,
 			})
-			Expect(err).To(MatchError(ContainSubstring("hello-world")))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(unpacker.Close()).To(Succeed())
+			Eventually(unpackServer.controlSocketEOF).Should(BeClosed())
+			Expect(fakeCommandRunner.WaitedCommands()).To(HaveLen(1))
+		})
+
+		Context("when nothing was unpacked", func() {
+			It("does nothing", func() {
+				Expect(unpacker.Close()).To(Succeed())
+				Expect(fakeCommandRunner.WaitedCommands()).To(BeEmpty())
+			})
 		})
 	})
 })
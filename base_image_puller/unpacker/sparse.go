@@ -0,0 +1,85 @@
+package unpacker // import "code.cloudfoundry.org/grootfs/base_image_puller/unpacker"
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	paxGNUSparsePrefix = "GNU.sparse."
+	sparseBlockSize    = 4096
+)
+
+var zeroBlock = make([]byte, sparseBlockSize)
+
+// isSparse tells whether the entry is a GNU (old or PAX format) sparse file
+func isSparse(tarHeader *tar.Header) bool {
+	if tarHeader.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+
+	for key := range tarHeader.PAXRecords {
+		if strings.HasPrefix(key, paxGNUSparsePrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeSparse copies the contents of a sparse entry to the file, seeking over
+// the blocks of zeros so that they are left as holes. The tar reader does not
+// expose the sparse map, and reads the holes as zeros. It returns the number of
+// bytes that were actually written, which does not include the holes.
+func writeSparse(file *os.File, reader io.Reader) (int64, error) {
+	writer := &sparseWriter{file: file}
+	buffer := make([]byte, 32*sparseBlockSize)
+	if _, err := io.CopyBuffer(writer, reader, buffer); err != nil {
+		return 0, err
+	}
+
+	// a trailing hole is not written, the file still needs its full size
+	if err := file.Truncate(writer.offset); err != nil {
+		return 0, errors.Wrap(err, "truncating sparse file")
+	}
+
+	return writer.bytesWritten, nil
+}
+
+type sparseWriter struct {
+	file         *os.File
+	offset       int64
+	bytesWritten int64
+}
+
+func (w *sparseWriter) Write(data []byte) (int, error) {
+	for start := 0; start < len(data); {
+		end := start + sparseBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		block := data[start:end]
+
+		if bytes.Equal(block, zeroBlock[:len(block)]) {
+			if _, err := w.file.Seek(int64(len(block)), io.SeekCurrent); err != nil {
+				return start, err
+			}
+		} else {
+			n, err := w.file.Write(block)
+			w.bytesWritten += int64(n)
+			if err != nil {
+				return start + n, err
+			}
+		}
+
+		w.offset += int64(len(block))
+		start = end
+	}
+
+	return len(data), nil
+}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/tscolari/lagregator"
+	"golang.org/x/sys/unix"
 
 	"github.com/containers/storage/pkg/reexec"
 	"github.com/urfave/cli"
@@ -190,6 +191,31 @@ func (u *TarUnpacker) Unpack(logger lager.Logger, spec base_image_puller.UnpackS
 	return unpackOutput, nil
 }
 
+// unpackInThread unpacks in an OS thread with its own root directory, so that
+// the process is not chrooted and can keep unpacking layers. The thread is not
+// unlocked, and terminates with the goroutine.
+func (u *TarUnpacker) unpackInThread(logger lager.Logger, spec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
+	type unpackResult struct {
+		output base_image_puller.UnpackOutput
+		err    error
+	}
+
+	results := make(chan unpackResult, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_FS); err != nil {
+			results <- unpackResult{err: errors.Wrap(err, "unsharing the filesystem attributes")}
+			return
+		}
+
+		output, err := u.unpack(logger, spec)
+		results <- unpackResult{output: output, err: err}
+	}()
+
+	result := <-results
+	return result.output, result.err
+}
+
 func (u *TarUnpacker) unpack(logger lager.Logger, spec base_image_puller.UnpackSpec) (base_image_puller.UnpackOutput, error) {
 	logger = logger.Session("unpacking-with-tar", lager.Data{"spec": spec})
 	logger.Info("starting")
@@ -265,10 +291,22 @@ func (u *TarUnpacker) handleEntry(entryPath string, tarReader *tar.Reader, tarHe
 			return 0, err
 		}
 
-	case tar.TypeReg, tar.TypeRegA:
+	case tar.TypeFifo:
+		if err = u.createFifo(entryPath, tarHeader, spec); err != nil {
+			return 0, err
+		}
+
+	case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
 		if entrySize, err = u.createRegularFile(entryPath, tarHeader, tarReader, spec); err != nil {
 			return 0, err
 		}
+
+	default:
+		return 0, nil
+	}
+
+	if err = u.setXattrs(entryPath, tarHeader, spec); err != nil {
+		return 0, err
 	}
 
 	return entrySize, nil
@@ -339,6 +377,37 @@ func (u *TarUnpacker) createLink(path string, tarHeader *tar.Header) error {
 	return os.Link(tarHeader.Linkname, path)
 }
 
+func (u *TarUnpacker) createFifo(path string, tarHeader *tar.Header, spec base_image_puller.UnpackSpec) error {
+	if _, err := os.Lstat(path); err == nil {
+		if err := os.Remove(path); err != nil {
+			return errors.Wrapf(err, "removing file `%s`", path)
+		}
+	}
+
+	if err := unix.Mkfifo(path, uint32(tarHeader.FileInfo().Mode().Perm())); err != nil {
+		return errors.Wrapf(err, "creating fifo `%s`", path)
+	}
+
+	if os.Getuid() == 0 {
+		uid := u.translateID(tarHeader.Uid, spec.UIDMappings)
+		gid := u.translateID(tarHeader.Gid, spec.GIDMappings)
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return errors.Wrapf(err, "chowning fifo %d:%d `%s`", uid, gid, path)
+		}
+	}
+
+	// we need to explicitly apply perms because mkfifo is subject to umask
+	if err := os.Chmod(path, tarHeader.FileInfo().Mode()); err != nil {
+		return errors.Wrapf(err, "chmoding fifo `%s`", path)
+	}
+
+	if err := changeModTime(path, tarHeader.ModTime); err != nil {
+		return errors.Wrapf(err, "setting the modtime for fifo `%s`", path)
+	}
+
+	return nil
+}
+
 func (u *TarUnpacker) createRegularFile(path string, tarHeader *tar.Header, tarReader *tar.Reader, spec base_image_puller.UnpackSpec) (int64, error) {
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, tarHeader.FileInfo().Mode())
 	if err != nil {
@@ -352,7 +421,12 @@ func (u *TarUnpacker) createRegularFile(path string, tarHeader *tar.Header, tarR
 		return 0, newErr
 	}
 
-	fileSize, err := io.Copy(file, tarReader)
+	var fileSize int64
+	if isSparse(tarHeader) {
+		fileSize, err = writeSparse(file, tarReader)
+	} else {
+		fileSize, err = io.Copy(file, tarReader)
+	}
 	if err != nil {
 		_ = file.Close()
 		return 0, errors.Wrapf(err, "writing to file `%s`", path)
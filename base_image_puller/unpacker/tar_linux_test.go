@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/grootfs/base_image_puller"
@@ -69,6 +70,84 @@ var _ = Describe("Tar unpacker - Linux tests", func() {
 		})
 	})
 
+	Describe("FIFOs", func() {
+		BeforeEach(func() {
+			fifoPath := path.Join(baseImagePath, "a_fifo")
+			Expect(exec.Command("mkfifo", "-m", "0640", fifoPath).Run()).To(Succeed())
+			Expect(os.Lchown(fifoPath, 1000, 1000)).To(Succeed())
+		})
+
+		It("creates them with their mode and ownership", func() {
+			_, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+				Stream:     stream,
+				TargetPath: targetPath,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			stat, err := os.Lstat(path.Join(targetPath, "a_fifo"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stat.Mode() & os.ModeNamedPipe).To(Equal(os.ModeNamedPipe))
+			Expect(stat.Mode().Perm()).To(Equal(os.FileMode(0640)))
+			Expect(stat.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(1000)))
+			Expect(stat.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(1000)))
+		})
+	})
+
+	Describe("sparse files", func() {
+		var tarFormat string
+
+		BeforeEach(func() {
+			tarFormat = "gnu"
+
+			file, err := os.Create(path.Join(baseImagePath, "sparse_file"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.WriteAt([]byte("hello"), 4*1024*1024)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Truncate(8 * 1024 * 1024)).To(Succeed())
+			Expect(file.Close()).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			stream = gbytes.NewBuffer()
+			sess, err := gexec.Start(exec.Command("tar", "-c", "--sparse", "--format", tarFormat, "-C", baseImagePath, "."), stream, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(sess).Should(gexec.Exit(0))
+		})
+
+		itRecreatesTheHoles := func() {
+			It("recreates the holes", func() {
+				unpackOutput, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+					Stream:     stream,
+					TargetPath: targetPath,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				filePath := path.Join(targetPath, "sparse_file")
+				stat, err := os.Stat(filePath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stat.Size()).To(Equal(int64(8 * 1024 * 1024)))
+				Expect(stat.Sys().(*syscall.Stat_t).Blocks * 512).To(BeNumerically("<", 1024*1024))
+
+				contents, err := ioutil.ReadFile(filePath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents[4*1024*1024 : 4*1024*1024+5])).To(Equal("hello"))
+
+				Expect(unpackOutput.BytesWritten).To(BeNumerically(">=", 5))
+				Expect(unpackOutput.BytesWritten).To(BeNumerically("<", 1024*1024))
+			})
+		}
+
+		itRecreatesTheHoles()
+
+		Context("when the tar uses the PAX sparse format", func() {
+			BeforeEach(func() {
+				tarFormat = "posix"
+			})
+
+			itRecreatesTheHoles()
+		})
+	})
+
 	Describe("modification time", func() {
 		var symlinkModTime time.Time
 
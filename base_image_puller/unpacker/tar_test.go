@@ -1,6 +1,8 @@
 package unpacker_test
 
 import (
+	"archive/tar"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -22,6 +24,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	"golang.org/x/sys/unix"
 )
 
 func init() {
@@ -567,6 +570,116 @@ var _ = Describe("Tar unpacker", func() {
 		})
 	})
 
+	Context("when the entries have xattrs", func() {
+		capabilityV2 := func() string {
+			data := make([]byte, 20)
+			binary.LittleEndian.PutUint32(data, 0x02000001)
+			binary.LittleEndian.PutUint32(data[4:], 1<<13)
+			return string(data)
+		}
+
+		capabilityV3 := func(rootID uint32) string {
+			data := make([]byte, 24)
+			binary.LittleEndian.PutUint32(data, 0x03000001)
+			binary.LittleEndian.PutUint32(data[4:], 1<<13)
+			binary.LittleEndian.PutUint32(data[20:], rootID)
+			return string(data)
+		}
+
+		var entries []*tar.Header
+
+		BeforeEach(func() {
+			entries = []*tar.Header{
+				{
+					Name:     "./a_dir/",
+					Typeflag: tar.TypeDir,
+					Mode:     0755,
+					PAXRecords: map[string]string{
+						"SCHILY.xattr.user.grootfs":              "dir",
+						"SCHILY.xattr.trusted.overlay.opaque":    "y",
+						"SCHILY.xattr.trusted.grootfs-untrusted": "kept",
+					},
+				},
+				{
+					Name:       "./a_dir/ping",
+					Typeflag:   tar.TypeReg,
+					Mode:       0755,
+					Uid:        1,
+					PAXRecords: map[string]string{"SCHILY.xattr.security.capability": capabilityV2()},
+				},
+				{
+					Name:       "./a_dir/ping-v3",
+					Typeflag:   tar.TypeReg,
+					Mode:       0755,
+					PAXRecords: map[string]string{"SCHILY.xattr.security.capability": capabilityV3(1)},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			buffer := gbytes.NewBuffer()
+			tarWriter := tar.NewWriter(buffer)
+			for _, entry := range entries {
+				entry.Format = tar.FormatPAX
+				Expect(tarWriter.WriteHeader(entry)).To(Succeed())
+			}
+			Expect(tarWriter.Close()).To(Succeed())
+			stream = buffer
+		})
+
+		getXattr := func(path, name string) string {
+			data := make([]byte, 256)
+			size, err := unix.Lgetxattr(path, name, data)
+			Expect(err).NotTo(HaveOccurred())
+			return string(data[:size])
+		}
+
+		It("restores them, after chowning the files", func() {
+			_, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+				Stream:     stream,
+				TargetPath: targetPath,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			dirPath := filepath.Join(targetPath, "a_dir")
+			Expect(getXattr(dirPath, "user.grootfs")).To(Equal("dir"))
+			Expect(getXattr(dirPath, "trusted.grootfs-untrusted")).To(Equal("kept"))
+			Expect(getXattr(filepath.Join(dirPath, "ping"), "security.capability")).To(Equal(capabilityV2()))
+			Expect(getXattr(filepath.Join(dirPath, "ping-v3"), "security.capability")).To(Equal(capabilityV3(1)))
+		})
+
+		It("does not restore the overlay xattrs", func() {
+			_, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+				Stream:     stream,
+				TargetPath: targetPath,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = unix.Lgetxattr(filepath.Join(targetPath, "a_dir"), "trusted.overlay.opaque", make([]byte, 10))
+			Expect(err).To(Equal(unix.ENODATA))
+		})
+
+		Context("when id mappings are provided", func() {
+			It("translates the root id of the capabilities", func() {
+				mappings := []groot.IDMappingSpec{
+					groot.IDMappingSpec{HostID: 1000, NamespaceID: 0, Size: 1},
+					groot.IDMappingSpec{HostID: 11, NamespaceID: 1, Size: 900},
+				}
+				_, err := tarUnpacker.Unpack(logger, base_image_puller.UnpackSpec{
+					Stream:      stream,
+					TargetPath:  targetPath,
+					UIDMappings: mappings,
+					GIDMappings: mappings,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				dirPath := filepath.Join(targetPath, "a_dir")
+				Expect(getXattr(filepath.Join(dirPath, "ping"), "security.capability")).To(Equal(capabilityV3(1000)))
+				Expect(getXattr(filepath.Join(dirPath, "ping-v3"), "security.capability")).To(Equal(capabilityV3(11)))
+			})
+		})
+	})
+
 	Context("when it has whiteout files", func() {
 		BeforeEach(func() {
 			// Add some pre-existing files in the rootfs
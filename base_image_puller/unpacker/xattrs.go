@@ -0,0 +1,119 @@
+package unpacker // import "code.cloudfoundry.org/grootfs/base_image_puller/unpacker"
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"strings"
+
+	"code.cloudfoundry.org/grootfs/base_image_puller"
+	"code.cloudfoundry.org/grootfs/groot"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	xattrPAXPrefix     = "SCHILY.xattr."
+	overlayXattrPrefix = "trusted.overlay."
+	capabilityXattr    = "security.capability"
+
+	// see vfs_cap_data in linux/capability.h
+	vfsCapRevisionMask = 0xFF000000
+	vfsCapRevision2    = 0x02000000
+	vfsCapRevision3    = 0x03000000
+	vfsCapV2Size       = 20
+	vfsCapV3Size       = 24
+)
+
+// setXattrs restores the xattrs of the entry. It must run after the entry is
+// chowned, as chown drops the capabilities of a file.
+//
+// `trusted.overlay.*` xattrs are skipped, as the overlay mount would act on
+// them. Xattrs that the filesystem or the user cannot hold are skipped as well,
+// so that unpacking as a non root user keeps working, except for file
+// capabilities: the binaries holding them would not work without them.
+func (u *TarUnpacker) setXattrs(path string, tarHeader *tar.Header, spec base_image_puller.UnpackSpec) error {
+	for name, value := range entryXattrs(tarHeader) {
+		if strings.HasPrefix(name, overlayXattrPrefix) {
+			continue
+		}
+
+		data := []byte(value)
+		if name == capabilityXattr {
+			var err error
+			if data, err = u.translateCapability(data, spec.UIDMappings); err != nil {
+				return errors.Wrapf(err, "translating the capabilities of `%s`", path)
+			}
+		}
+
+		if err := unix.Lsetxattr(path, name, data, 0); err != nil {
+			if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+				continue
+			}
+
+			if err == unix.EPERM {
+				if name == capabilityXattr {
+					return errors.Wrapf(err, "setting the file capabilities of `%s`", path)
+				}
+				continue
+			}
+
+			return errors.Wrapf(err, "setting xattr `%s` on `%s`", name, path)
+		}
+	}
+
+	return nil
+}
+
+// translateCapability maps the root id of v3 capabilities to the host, and
+// scopes v2 capabilities to the root of the mappings. When the unpack happens
+// inside a user namespace there are no mappings in the spec, and the kernel
+// does the same translation.
+func (u *TarUnpacker) translateCapability(data []byte, mappings []groot.IDMappingSpec) ([]byte, error) {
+	if len(mappings) == 0 || len(data) < 4 {
+		return data, nil
+	}
+
+	magic := binary.LittleEndian.Uint32(data)
+	switch magic & vfsCapRevisionMask {
+	case vfsCapRevision2:
+		if len(data) != vfsCapV2Size {
+			return nil, errors.Errorf("invalid v2 capability size %d", len(data))
+		}
+
+		translated := make([]byte, vfsCapV3Size)
+		copy(translated, data)
+		binary.LittleEndian.PutUint32(translated, magic&^vfsCapRevisionMask|vfsCapRevision3)
+		binary.LittleEndian.PutUint32(translated[vfsCapV2Size:], uint32(u.translateRootID(mappings)))
+		return translated, nil
+
+	case vfsCapRevision3:
+		if len(data) != vfsCapV3Size {
+			return nil, errors.Errorf("invalid v3 capability size %d", len(data))
+		}
+
+		translated := make([]byte, vfsCapV3Size)
+		copy(translated, data)
+		rootID := binary.LittleEndian.Uint32(data[vfsCapV2Size:])
+		binary.LittleEndian.PutUint32(translated[vfsCapV2Size:], uint32(u.translateID(int(rootID), mappings)))
+		return translated, nil
+	}
+
+	return data, nil
+}
+
+// entryXattrs merges the xattrs found in the deprecated Xattrs field with the
+// ones in the `SCHILY.xattr.*` PAX records
+func entryXattrs(tarHeader *tar.Header) map[string]string {
+	xattrs := map[string]string{}
+	for name, value := range tarHeader.Xattrs {
+		xattrs[name] = value
+	}
+
+	for key, value := range tarHeader.PAXRecords {
+		if strings.HasPrefix(key, xattrPAXPrefix) {
+			xattrs[strings.TrimPrefix(key, xattrPAXPrefix)] = value
+		}
+	}
+
+	return xattrs
+}
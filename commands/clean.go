@@ -84,7 +84,18 @@ var CleanCommand = cli.Command{
 		gc := garbage_collector.NewGC(nsFsDriver, imageCloner, dependencyManager)
 		sm := storepkg.NewStoreMeasurer(storePath, fsDriver, gc)
 
-		cleaner := groot.IamCleaner(locksmith, sm, gc, metricsEmitter)
+		cleaner := groot.IamCleaner(locksmith, sm, gc, metricsEmitter).
+			WithPartialBlobs(createPartialBlobs(cfg))
+		if blobCache := createBlobCache(cfg); blobCache != nil {
+			cleaner = cleaner.WithBlobCache(blobCache)
+			defer func() {
+				blobCacheSize, err := blobCache.Size(logger)
+				if err != nil {
+					logger.Error("getting-blob-cache-size", err)
+				}
+				metricsEmitter.TryEmitUsage(logger, "BlobCacheSize", blobCacheSize, "bytes")
+			}()
+		}
 
 		defer func() {
 			unusedVolumesSize, err := sm.UnusedVolumesSize(logger)
@@ -2,24 +2,36 @@ package config
 
 import (
 	"io/ioutil"
+	"strings"
+	"time"
 
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	errorspkg "github.com/pkg/errors"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	StorePath      string `yaml:"store"`
-	FSDriver       string `yaml:"driver"`
-	TardisBin      string `yaml:"tardis_bin"`
-	NewuidmapBin   string `yaml:"newuidmap_bin"`
-	NewgidmapBin   string `yaml:"newgidmap_bin"`
-	MetronEndpoint string `yaml:"metron_endpoint"`
-	LogLevel       string `yaml:"log_level"`
-	LogFile        string `yaml:"log_file"`
-	Create         Create `yaml:"create"`
-	Clean          Clean  `yaml:"clean"`
-	Init           Init   `yaml:"-"`
+	StorePath          string     `yaml:"store"`
+	FSDriver           string     `yaml:"driver"`
+	TardisBin          string     `yaml:"tardis_bin"`
+	NewuidmapBin       string     `yaml:"newuidmap_bin"`
+	NewgidmapBin       string     `yaml:"newgidmap_bin"`
+	MetronEndpoint     string     `yaml:"metron_endpoint"`
+	LogLevel           string     `yaml:"log_level"`
+	LogFile            string     `yaml:"log_file"`
+	BlobCacheSizeBytes int64      `yaml:"blob_cache_size_bytes"`
+	Registries         []Registry `yaml:"registries"`
+	Create             Create     `yaml:"create"`
+	Clean              Clean      `yaml:"clean"`
+	Init               Init       `yaml:"-"`
+}
+
+// Registry lists the mirrors that are tried, in order, before the upstream
+// registry at Location
+type Registry struct {
+	Location string   `yaml:"location"`
+	Mirrors  []string `yaml:"mirrors"`
 }
 
 type Create struct {
@@ -30,6 +42,26 @@ type Create struct {
 	DiskLimitSizeBytes                int64    `yaml:"disk_limit_size_bytes"`
 	InsecureRegistries                []string `yaml:"insecure_registries"`
 	RemoteLayerClientCertificatesPath string   `yaml:"remote_layer_client_certificates_path"`
+	CertsDir                          string   `yaml:"certs_dir"`
+	MaxParallelDownloads              int      `yaml:"max_parallel_downloads"`
+	StreamBlobs                       bool     `yaml:"stream_blobs"`
+	PipelinedPull                     bool     `yaml:"pipelined_pull"`
+	RegistryAuthFile                  string   `yaml:"registry_auth_file"`
+	Platform                          string   `yaml:"platform"`
+	SignaturePolicyFile               string   `yaml:"signature_policy_file"`
+	ContentChainIDs                   bool     `yaml:"content_chain_ids"`
+	Retry                             Retry    `yaml:"retry"`
+	MaxDownloadBytesPerSecond         int64    `yaml:"max_download_bytes_per_second"`
+	StoreMaxDownloadBytesPerSecond    int64    `yaml:"store_max_download_bytes_per_second"`
+}
+
+// Retry is the retry policy of registry operations. Unset values keep the
+// defaults, so that zero values such as `jitter: 0` can override them.
+type Retry struct {
+	MaxAttempts *int           `yaml:"max_attempts"`
+	BaseDelay   *time.Duration `yaml:"base_delay"`
+	MaxDelay    *time.Duration `yaml:"max_delay"`
+	Jitter      *float64       `yaml:"jitter"`
 }
 
 type Clean struct {
@@ -73,6 +105,43 @@ func (b *Builder) Build() (Config, error) {
 		return *b.config, errorspkg.New("invalid argument: clean threshold cannot be negative")
 	}
 
+	if b.config.Create.MaxParallelDownloads < 0 {
+		return *b.config, errorspkg.New("invalid argument: max parallel downloads cannot be negative")
+	}
+
+	if b.config.Create.MaxDownloadBytesPerSecond < 0 || b.config.Create.StoreMaxDownloadBytesPerSecond < 0 {
+		return *b.config, errorspkg.New("invalid argument: max download bytes per second cannot be negative")
+	}
+
+	retry := b.config.Create.Retry
+	if retry.MaxAttempts != nil && *retry.MaxAttempts < 0 {
+		return *b.config, errorspkg.New("invalid argument: retry max attempts cannot be negative")
+	}
+
+	if (retry.BaseDelay != nil && *retry.BaseDelay < 0) || (retry.MaxDelay != nil && *retry.MaxDelay < 0) {
+		return *b.config, errorspkg.New("invalid argument: retry delays cannot be negative")
+	}
+
+	if retry.Jitter != nil && (*retry.Jitter < 0 || *retry.Jitter > 1) {
+		return *b.config, errorspkg.New("invalid argument: retry jitter must be between 0 and 1")
+	}
+
+	if b.config.BlobCacheSizeBytes < 0 {
+		return *b.config, errorspkg.New("invalid argument: blob cache size cannot be negative")
+	}
+
+	if b.config.Create.Platform != "" {
+		if _, err := ParsePlatform(b.config.Create.Platform); err != nil {
+			return *b.config, err
+		}
+	}
+
+	for _, registry := range b.config.Registries {
+		if registry.Location == "" {
+			return *b.config, errorspkg.New("invalid argument: registry location cannot be empty")
+		}
+	}
+
 	return *b.config, nil
 }
 
@@ -138,6 +207,13 @@ func (b *Builder) WithDiskLimitSizeBytes(limit int64, isSet bool) *Builder {
 	return b
 }
 
+func (b *Builder) WithMaxDownloadBytesPerSecond(limit int64, isSet bool) *Builder {
+	if isSet {
+		b.config.Create.MaxDownloadBytesPerSecond = limit
+	}
+	return b
+}
+
 func (b *Builder) WithExcludeImageFromQuota(exclude, isSet bool) *Builder {
 	if isSet {
 		b.config.Create.ExcludeImageFromQuota = exclude
@@ -145,6 +221,13 @@ func (b *Builder) WithExcludeImageFromQuota(exclude, isSet bool) *Builder {
 	return b
 }
 
+func (b *Builder) WithPlatform(platform string, isSet bool) *Builder {
+	if isSet {
+		b.config.Create.Platform = platform
+	}
+	return b
+}
+
 func (b *Builder) WithSkipLayerValidation(skip, isSet bool) *Builder {
 	if isSet {
 		b.config.Create.SkipLayerValidation = skip
@@ -216,3 +299,24 @@ func load(configPath string) (Config, error) {
 
 	return config, nil
 }
+
+// ParsePlatform parses a platform in the `os/arch[/variant]` format
+func ParsePlatform(platform string) (specsv1.Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return specsv1.Platform{}, errorspkg.Errorf("invalid argument: platform `%s` must be os/arch[/variant]", platform)
+	}
+
+	for _, part := range parts {
+		if part == "" {
+			return specsv1.Platform{}, errorspkg.Errorf("invalid argument: platform `%s` must be os/arch[/variant]", platform)
+		}
+	}
+
+	parsedPlatform := specsv1.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		parsedPlatform.Variant = parts[2]
+	}
+
+	return parsedPlatform, nil
+}
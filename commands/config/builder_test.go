@@ -4,8 +4,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	"code.cloudfoundry.org/grootfs/commands/config"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	yaml "gopkg.in/yaml.v2"
 
 	. "github.com/onsi/ginkgo"
@@ -47,6 +49,9 @@ var _ = Describe("Builder", func() {
 			MetronEndpoint: "config_endpoint:1111",
 			LogLevel:       "info",
 			LogFile:        "/path/to/a/file",
+			Registries: []config.Registry{
+				{Location: "docker.io", Mirrors: []string{"mirror.example.org"}},
+			},
 		}
 	})
 
@@ -74,6 +79,7 @@ var _ = Describe("Builder", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(config.Create.InsecureRegistries).To(Equal([]string{"http://example.org"}))
 			Expect(config.StorePath).To(Equal("/hello"))
+			Expect(config.Registries).To(Equal(cfg.Registries))
 		})
 
 		Context("when disk limit property is invalid", func() {
@@ -98,6 +104,117 @@ var _ = Describe("Builder", func() {
 			})
 		})
 
+		Context("when max parallel downloads property is invalid", func() {
+			BeforeEach(func() {
+				cfg.Create.MaxParallelDownloads = -1
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: max parallel downloads cannot be negative"))
+			})
+		})
+
+		Context("when the retry policy is set", func() {
+			BeforeEach(func() {
+				maxAttempts := 5
+				baseDelay := time.Second
+				maxDelay := time.Minute
+				jitter := 0.5
+				cfg.Create.Retry = config.Retry{
+					MaxAttempts: &maxAttempts,
+					BaseDelay:   &baseDelay,
+					MaxDelay:    &maxDelay,
+					Jitter:      &jitter,
+				}
+			})
+
+			It("returns the retry policy", func() {
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Create.Retry).To(Equal(cfg.Create.Retry))
+			})
+		})
+
+		Context("when the retry policy is set to zero values", func() {
+			BeforeEach(func() {
+				baseDelay := time.Duration(0)
+				jitter := 0.0
+				cfg.Create.Retry = config.Retry{
+					BaseDelay: &baseDelay,
+					Jitter:    &jitter,
+				}
+			})
+
+			It("keeps them, so that they override the defaults", func() {
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Create.Retry.MaxAttempts).To(BeNil())
+				Expect(config.Create.Retry.BaseDelay).NotTo(BeNil())
+				Expect(*config.Create.Retry.BaseDelay).To(BeZero())
+				Expect(config.Create.Retry.Jitter).NotTo(BeNil())
+				Expect(*config.Create.Retry.Jitter).To(BeZero())
+			})
+		})
+
+		Context("when retry max attempts is negative", func() {
+			BeforeEach(func() {
+				maxAttempts := -1
+				cfg.Create.Retry.MaxAttempts = &maxAttempts
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: retry max attempts cannot be negative"))
+			})
+		})
+
+		Context("when a retry delay is negative", func() {
+			BeforeEach(func() {
+				maxDelay := -time.Second
+				cfg.Create.Retry.MaxDelay = &maxDelay
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: retry delays cannot be negative"))
+			})
+		})
+
+		Context("when retry jitter is out of range", func() {
+			BeforeEach(func() {
+				jitter := 1.5
+				cfg.Create.Retry.Jitter = &jitter
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: retry jitter must be between 0 and 1"))
+			})
+		})
+
+		Context("when blob cache size property is invalid", func() {
+			BeforeEach(func() {
+				cfg.BlobCacheSizeBytes = -1
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: blob cache size cannot be negative"))
+			})
+		})
+
+		Context("when a registry has no location", func() {
+			BeforeEach(func() {
+				cfg.Registries = []config.Registry{{Mirrors: []string{"mirror.example.org"}}}
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: registry location cannot be empty"))
+			})
+		})
+
 		Context("when config is invalid", func() {
 			JustBeforeEach(func() {
 				configFilePath = path.Join(configDir, "invalid_config.yaml")
@@ -340,6 +457,47 @@ var _ = Describe("Builder", func() {
 		})
 	})
 
+	Describe("WithMaxDownloadBytesPerSecond", func() {
+		BeforeEach(func() {
+			cfg.Create.MaxDownloadBytesPerSecond = 1024
+		})
+
+		It("overrides the config's MaxDownloadBytesPerSecond entry when flag is set", func() {
+			builder = builder.WithMaxDownloadBytesPerSecond(2048, true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Create.MaxDownloadBytesPerSecond).To(Equal(int64(2048)))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithMaxDownloadBytesPerSecond(2048, false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Create.MaxDownloadBytesPerSecond).To(Equal(int64(1024)))
+			})
+		})
+
+		Context("when negative", func() {
+			It("returns an error", func() {
+				builder = builder.WithMaxDownloadBytesPerSecond(-1, true)
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: max download bytes per second cannot be negative"))
+			})
+		})
+
+		Context("when the store-wide limit is negative", func() {
+			BeforeEach(func() {
+				cfg.Create.StoreMaxDownloadBytesPerSecond = -1
+			})
+
+			It("returns an error", func() {
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: max download bytes per second cannot be negative"))
+			})
+		})
+	})
+
 	Describe("WithExcludeImageFromQuota", func() {
 		It("overrides the config's ExcludeImageFromQuota when the flag is set", func() {
 			builder = builder.WithExcludeImageFromQuota(false, true)
@@ -358,6 +516,55 @@ var _ = Describe("Builder", func() {
 		})
 	})
 
+	Describe("WithPlatform", func() {
+		BeforeEach(func() {
+			cfg.Create.Platform = "linux/amd64"
+		})
+
+		It("overrides the config's Platform when the flag is set", func() {
+			builder = builder.WithPlatform("linux/arm64/v8", true)
+			config, err := builder.Build()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Create.Platform).To(Equal("linux/arm64/v8"))
+		})
+
+		Context("when flag is not set", func() {
+			It("uses the config entry", func() {
+				builder = builder.WithPlatform("linux/arm64", false)
+				config, err := builder.Build()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Create.Platform).To(Equal("linux/amd64"))
+			})
+		})
+
+		Context("when the platform is invalid", func() {
+			It("returns an error", func() {
+				builder = builder.WithPlatform("arm64", true)
+				_, err := builder.Build()
+				Expect(err).To(MatchError("invalid argument: platform `arm64` must be os/arch[/variant]"))
+			})
+		})
+	})
+
+	Describe("ParsePlatform", func() {
+		It("parses the os and the architecture", func() {
+			platform, err := config.ParsePlatform("linux/arm64")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform).To(Equal(specsv1.Platform{OS: "linux", Architecture: "arm64"}))
+		})
+
+		It("parses the variant", func() {
+			platform, err := config.ParsePlatform("linux/arm/v7")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(platform).To(Equal(specsv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+		})
+
+		It("rejects empty components", func() {
+			_, err := config.ParsePlatform("linux//v7")
+			Expect(err).To(MatchError(ContainSubstring("must be os/arch[/variant]")))
+		})
+	})
+
 	Describe("WithSkipLayerValidation", func() {
 		It("overrides the config's SkipLayerValidation when the flag is set", func() {
 			builder = builder.WithSkipLayerValidation(false, true)
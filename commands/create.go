@@ -12,30 +12,45 @@ import (
 
 	"code.cloudfoundry.org/commandrunner/linux_command_runner"
 	"code.cloudfoundry.org/grootfs/base_image_puller"
-	unpackerpkg "code.cloudfoundry.org/grootfs/base_image_puller/unpacker"
 	"code.cloudfoundry.org/grootfs/commands/config"
+	"code.cloudfoundry.org/grootfs/commands/registryauth"
+	"code.cloudfoundry.org/grootfs/fetcher/dir_fetcher"
+	"code.cloudfoundry.org/grootfs/fetcher/http_fetcher"
 	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher"
 	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
 	"code.cloudfoundry.org/grootfs/fetcher/tar_fetcher"
+	"code.cloudfoundry.org/grootfs/fetcher/throttle"
 	"code.cloudfoundry.org/grootfs/groot"
 	"code.cloudfoundry.org/grootfs/metrics"
 	storepkg "code.cloudfoundry.org/grootfs/store"
+	"code.cloudfoundry.org/grootfs/store/blob_cache"
 	"code.cloudfoundry.org/grootfs/store/dependency_manager"
+	"code.cloudfoundry.org/grootfs/store/digest_cache"
+	"code.cloudfoundry.org/grootfs/store/etag_cache"
 	"code.cloudfoundry.org/grootfs/store/filesystems/namespaced"
-	"code.cloudfoundry.org/grootfs/store/filesystems/overlayxfs"
 	"code.cloudfoundry.org/grootfs/store/garbage_collector"
 	"code.cloudfoundry.org/grootfs/store/image_cloner"
 	locksmithpkg "code.cloudfoundry.org/grootfs/store/locksmith"
 	"code.cloudfoundry.org/grootfs/store/manager"
 	"code.cloudfoundry.org/lager"
 
+	"github.com/containers/image/signature"
 	"github.com/containers/image/types"
 	"github.com/docker/distribution/registry/api/errcode"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	errorspkg "github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
 
+// ImageRejectedExitCode is the exit code of `create` when the signature
+// policy does not allow the base image
+const ImageRejectedExitCode = 3
+
+// PlatformAnnotation holds the platform of the base image in the spec printed
+// by `create`, as os/arch[/variant]
+const PlatformAnnotation = "org.cloudfoundry.grootfs.platform"
+
 var CreateCommand = cli.Command{
 	Name:        "create",
 	Usage:       "create [options] <image> <id>",
@@ -46,6 +61,10 @@ var CreateCommand = cli.Command{
 			Name:  "disk-limit-size-bytes",
 			Usage: "Inclusive disk limit (i.e: includes all layers in the filesystem)",
 		},
+		cli.Int64Flag{
+			Name:  "max-download-bytes-per-second",
+			Usage: "Limit the download rate of image layers",
+		},
 		cli.StringSliceFlag{
 			Name:  "insecure-registry",
 			Usage: "Whitelist a private registry",
@@ -56,7 +75,7 @@ var CreateCommand = cli.Command{
 		},
 		cli.BoolFlag{
 			Name:  "skip-layer-validation",
-			Usage: "Do not validate checksums and sizes of image layers. (Can only be used with oci:/// protocol images.)",
+			Usage: "Do not validate checksums and sizes of image layers. (Can only be used with oci:/// and oci-archive:/// protocol images.)",
 		},
 		cli.BoolFlag{
 			Name:  "with-clean",
@@ -78,6 +97,18 @@ var CreateCommand = cli.Command{
 			Name:  "without-mount",
 			Usage: "Do not mount the root filesystem.",
 		},
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "Platform to pick from multi-platform images, as os/arch[/variant]",
+		},
+		cli.IntFlag{
+			Name:  "progress-fd",
+			Usage: "Write progress events as JSON lines to this file descriptor",
+		},
+		cli.StringFlag{
+			Name:  "progress-file",
+			Usage: "Write progress events as JSON lines to this file",
+		},
 		cli.StringFlag{
 			Name:  "username",
 			Usage: "Username to authenticate in image registry",
@@ -101,13 +132,16 @@ var CreateCommand = cli.Command{
 		configBuilder.WithInsecureRegistries(ctx.StringSlice("insecure-registry")).
 			WithDiskLimitSizeBytes(ctx.Int64("disk-limit-size-bytes"),
 				ctx.IsSet("disk-limit-size-bytes")).
+			WithMaxDownloadBytesPerSecond(ctx.Int64("max-download-bytes-per-second"),
+				ctx.IsSet("max-download-bytes-per-second")).
 			WithExcludeImageFromQuota(ctx.Bool("exclude-image-from-quota"),
 				ctx.IsSet("exclude-image-from-quota")).
 			WithSkipLayerValidation(ctx.Bool("skip-layer-validation"),
 				ctx.IsSet("skip-layer-validation")).
 			WithCleanThresholdBytes(ctx.Int64("threshold-bytes"), ctx.IsSet("threshold-bytes")).
 			WithClean(ctx.IsSet("with-clean"), ctx.IsSet("without-clean")).
-			WithMount(ctx.IsSet("with-mount"), ctx.IsSet("without-mount"))
+			WithMount(ctx.IsSet("with-mount"), ctx.IsSet("without-mount")).
+			WithPlatform(ctx.String("platform"), ctx.IsSet("platform"))
 
 		cfg, err := configBuilder.Build()
 		logger.Debug("create-config", lager.Data{"currentConfig": cfg})
@@ -134,6 +168,15 @@ var CreateCommand = cli.Command{
 			return cli.NewExitError(err.Error(), 1)
 		}
 
+		progressReporter, progressOutput, err := openProgressReporter(ctx.Int("progress-fd"), ctx.String("progress-file"))
+		if err != nil {
+			logger.Error("opening-progress-output-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if progressOutput != nil {
+			defer progressOutput.Close()
+		}
+
 		metricsEmitter := metrics.NewEmitter(logger, cfg.MetronEndpoint)
 
 		initLocksDir := filepath.Join("/", "var", "run")
@@ -142,7 +185,7 @@ var CreateCommand = cli.Command{
 		exclusiveLocksmith := locksmithpkg.NewExclusiveFileSystem(storeLocksDir).WithMetrics(metricsEmitter)
 		initStoreLocksmith := locksmithpkg.NewExclusiveFileSystem(initLocksDir)
 
-		imageCloner := image_cloner.NewImageCloner(fsDriver, storePath)
+		imageCloner := image_cloner.NewImageCloner(fsDriver, storePath).WithProgressReporter(progressReporter)
 		storeNamespacer := groot.NewStoreNamespacer(storePath)
 
 		manager := manager.New(storePath, storeNamespacer, fsDriver, fsDriver, fsDriver, initStoreLocksmith)
@@ -158,22 +201,11 @@ var CreateCommand = cli.Command{
 		}
 
 		runner := linux_command_runner.New()
-		var unpacker base_image_puller.Unpacker
-		unpackerStrategy := unpackerpkg.UnpackStrategy{
-			Name:               cfg.FSDriver,
-			WhiteoutDevicePath: filepath.Join(storePath, overlayxfs.WhiteoutDevice),
-		}
-
-		var idMapper unpackerpkg.IDMapper
-		if os.Getuid() == 0 {
-			unpacker, err = unpackerpkg.NewTarUnpacker(unpackerStrategy)
-			if err != nil {
-				return cli.NewExitError(err.Error(), 1)
-			}
-		} else {
-			idMapper = unpackerpkg.NewIDMapper(cfg.NewuidmapBin, cfg.NewgidmapBin, runner)
-			unpacker = unpackerpkg.NewNSIdMapperUnpacker(runner, idMapper, unpackerStrategy)
+		unpacker, idMapper, err := createUnpacker(cfg, runner)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
 		}
+		defer closeUnpacker(logger, unpacker)
 
 		dependencyManager := dependency_manager.NewDependencyManager(
 			filepath.Join(storePath, storepkg.MetaDirName, "dependencies"),
@@ -181,9 +213,13 @@ var CreateCommand = cli.Command{
 
 		nsFsDriver := namespaced.New(fsDriver, idMappings, idMapper, runner)
 
-		systemContext := createSystemContext(baseImageURL, cfg.Create, ctx.String("username"), ctx.String("password"))
-
-		fetcher := createFetcher(baseImageURL, systemContext, cfg.Create)
+		fetcher, err := createImageFetcher(logger, baseImageURL, cfg, ctx.String("username"), ctx.String("password"), progressReporter, nil)
+		if err != nil {
+			if _, ok := err.(*source.ImageRejectedError); ok {
+				return cli.NewExitError(err.Error(), ImageRejectedExitCode)
+			}
+			return cli.NewExitError(err.Error(), 1)
+		}
 		defer func() {
 			err := fetcher.Close()
 			if err != nil {
@@ -197,11 +233,17 @@ var CreateCommand = cli.Command{
 			nsFsDriver,
 			metricsEmitter,
 			exclusiveLocksmith,
-		)
+		).WithMaxParallelDownloads(cfg.Create.MaxParallelDownloads).
+			WithPipelinedUnpack(cfg.Create.PipelinedPull).
+			WithProgressReporter(progressReporter)
 
 		gc := garbage_collector.NewGC(nsFsDriver, imageCloner, dependencyManager)
 		sm := storepkg.NewStoreMeasurer(storePath, fsDriver, gc)
-		cleaner := groot.IamCleaner(exclusiveLocksmith, sm, gc, metricsEmitter)
+		cleaner := groot.IamCleaner(exclusiveLocksmith, sm, gc, metricsEmitter).
+			WithPartialBlobs(createPartialBlobs(cfg))
+		if blobCache := createBlobCache(cfg); blobCache != nil {
+			cleaner = cleaner.WithBlobCache(blobCache)
+		}
 
 		creator := groot.IamCreator(
 			imageCloner, baseImagePuller, sharedLocksmith,
@@ -222,7 +264,10 @@ var CreateCommand = cli.Command{
 		image, err := creator.Create(logger, createSpec)
 		if err != nil {
 			logger.Error("creating", err)
-			humanizedError := tryHumanize(err, createSpec)
+			if _, ok := errorspkg.Cause(err).(*source.ImageRejectedError); ok {
+				return cli.NewExitError(errorspkg.Cause(err).Error(), ImageRejectedExitCode)
+			}
+			humanizedError := tryHumanize(err, createSpec, cfg.Create)
 			return cli.NewExitError(humanizedError, 1)
 		}
 
@@ -236,6 +281,12 @@ var CreateCommand = cli.Command{
 			Mounts: []specs.Mount{},
 		}
 
+		if image.Platform != nil {
+			containerSpec.Annotations = map[string]string{
+				PlatformAnnotation: formatPlatform(*image.Platform),
+			}
+		}
+
 		for _, mount := range image.Mounts {
 			containerSpec.Mounts = append(containerSpec.Mounts, specs.Mount{
 				Destination: mount.Destination,
@@ -284,44 +335,244 @@ func emitMetrics(logger lager.Logger, metricsEmitter *metrics.Emitter, sm *store
 	metricsEmitter.TryEmitUsage(logger, "CommittedQuotaInBytes", commitedQuota, "bytes")
 }
 
-func createFetcher(baseImageUrl *url.URL, systemContext types.SystemContext, createCfg config.Create) base_image_puller.Fetcher {
+// createImageFetcher returns the fetcher of the base image, set up with the
+// registry settings, caches and limits of the config
+func createImageFetcher(logger lager.Logger, baseImageURL *url.URL, cfg config.Config, username, password string, progressReporter groot.ProgressReporter, downloadCounter *source.DownloadCounter) (base_image_puller.Fetcher, error) {
+	systemContext, err := createSystemContext(baseImageURL, cfg.Create, username, password)
+	if err != nil {
+		logger.Error("creating-system-context-failed", err)
+		return nil, err
+	}
+
+	mirrors, err := registryMirrors(baseImageURL, cfg.Registries, cfg.Create)
+	if err != nil {
+		logger.Error("configuring-registry-mirrors-failed", err)
+		return nil, err
+	}
+
+	signaturePolicy, err := loadSignaturePolicy(cfg.Create)
+	if err != nil {
+		logger.Error("loading-signature-policy-failed", err)
+		return nil, err
+	}
+	if signaturePolicy != nil && !hasSignatures(baseImageURL) {
+		return nil, &source.ImageRejectedError{Reason: "only docker and oci images can be verified against the policy"}
+	}
+
+	blobCache := createBlobCache(cfg)
+	tarDigestCache := createTarDigestCache(cfg)
+	etagCache := createETagCache(cfg)
+	bandwidthLimiters := createBandwidthLimiters(cfg)
+	fetcher, err := createFetcher(baseImageURL, systemContext, cfg.Create, mirrors, signaturePolicy, blobCache, tarDigestCache, etagCache, bandwidthLimiters, progressReporter, downloadCounter)
+	if err != nil {
+		logger.Error("creating-fetcher-failed", err)
+		return nil, err
+	}
+
+	return fetcher, nil
+}
+
+func createFetcher(baseImageUrl *url.URL, systemContext types.SystemContext, createCfg config.Create, mirrors []source.Mirror, signaturePolicy *signature.Policy, blobCache *blob_cache.BlobCache, tarDigestCache *digest_cache.DigestCache, etagCache *etag_cache.ETagCache, bandwidthLimiters []throttle.Limiter, progressReporter groot.ProgressReporter, downloadCounter *source.DownloadCounter) (base_image_puller.Fetcher, error) {
+	if baseImageUrl.Scheme == "http" || baseImageUrl.Scheme == "https" {
+		// tarball servers are trusted the same way as registries
+		tlsConfig, err := source.TLSConfig(registryCertPath(baseImageUrl.Host, createCfg), isTrustedRegistry(baseImageUrl.Host, createCfg.InsecureRegistries))
+		if err != nil {
+			return nil, errorspkg.Wrapf(err, "configuring TLS for `%s`", baseImageUrl.Host)
+		}
+
+		httpFetcher := http_fetcher.NewHTTPFetcher(baseImageUrl).
+			WithClient(http_fetcher.NewHTTPClient(tlsConfig)).
+			WithETagCache(etagCache)
+		if downloadCounter != nil {
+			httpFetcher.WithDownloadCounter(downloadCounter)
+		}
+		return httpFetcher, nil
+	}
+
+	if isDirBaseImage(baseImageUrl) {
+		return dir_fetcher.NewDirFetcher(baseImageUrl), nil
+	}
+
 	if baseImageUrl.Scheme == "" {
-		return tar_fetcher.NewTarFetcher(baseImageUrl)
+		tarFetcher := tar_fetcher.NewTarFetcher(baseImageUrl)
+		if tarDigestCache != nil {
+			tarFetcher.WithContentChainIDs(tarDigestCache)
+		}
+		return tarFetcher, nil
 	}
 
-	skipOCILayerValidation := createCfg.SkipLayerValidation && baseImageUrl.Scheme == "oci"
+	skipOCILayerValidation := createCfg.SkipLayerValidation && source.IsOCI(baseImageUrl.Scheme)
 	layerSource := source.NewLayerSource(systemContext, skipOCILayerValidation, shouldSkipImageQuotaValidation(createCfg), createCfg.DiskLimitSizeBytes, baseImageUrl)
-	return layer_fetcher.NewLayerFetcher(&layerSource)
+	if blobCache != nil {
+		layerSource.WithBlobCache(blobCache)
+	}
+	layerSource.WithMirrors(mirrors)
+	layerSource.WithRetryPolicy(retryPolicy(createCfg.Retry))
+	layerSource.WithBandwidthLimiters(bandwidthLimiters...)
+	layerSource.WithProgressReporter(progressReporter)
+	layerSource.WithDownloadCounter(downloadCounter)
+	if signaturePolicy != nil {
+		layerSource.WithSignaturePolicy(signaturePolicy)
+	}
+	if createCfg.Platform != "" {
+		// the platform has been validated by the config builder
+		platform, _ := config.ParsePlatform(createCfg.Platform)
+		layerSource.WithPlatform(platform)
+	}
+	return layer_fetcher.NewLayerFetcher(&layerSource).WithBlobStreaming(createCfg.StreamBlobs), nil
+}
+
+func loadSignaturePolicy(createCfg config.Create) (*signature.Policy, error) {
+	if createCfg.SignaturePolicyFile == "" {
+		return nil, nil
+	}
+
+	policy, err := signature.NewPolicyFromFile(createCfg.SignaturePolicyFile)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "loading signature policy")
+	}
+
+	return policy, nil
+}
+
+// hasSignatures returns whether the signature policy can be evaluated for
+// the base image. Tar, directory and http images are neither signed nor
+// identified by a registry reference.
+func hasSignatures(baseImageURL *url.URL) bool {
+	switch baseImageURL.Scheme {
+	case "", "http", "https":
+		return false
+	}
+	return !isDirBaseImage(baseImageURL)
 }
 
 func shouldSkipImageQuotaValidation(createCfg config.Create) bool {
 	return createCfg.ExcludeImageFromQuota || createCfg.DiskLimitSizeBytes == 0
 }
 
-func createSystemContext(baseImageURL *url.URL, createConfig config.Create, username, password string) types.SystemContext {
+// retryPolicy fills the values missing from the config with the defaults
+func retryPolicy(retryCfg config.Retry) source.RetryPolicy {
+	policy := source.DefaultRetryPolicy
+	if retryCfg.MaxAttempts != nil {
+		policy.MaxAttempts = *retryCfg.MaxAttempts
+	}
+	if retryCfg.BaseDelay != nil {
+		policy.BaseDelay = *retryCfg.BaseDelay
+	}
+	if retryCfg.MaxDelay != nil {
+		policy.MaxDelay = *retryCfg.MaxDelay
+	}
+	if retryCfg.Jitter != nil {
+		policy.Jitter = *retryCfg.Jitter
+	}
+
+	return policy
+}
+
+// isDirBaseImage tells whether the base image is a local directory tree,
+// either through the dir scheme or a plain path to a directory
+func isDirBaseImage(baseImageURL *url.URL) bool {
+	if baseImageURL.Scheme == "dir" {
+		return true
+	}
+	if baseImageURL.Scheme != "" {
+		return false
+	}
+
+	stat, err := os.Stat(baseImageURL.Path)
+	return err == nil && stat.IsDir()
+}
+
+func createSystemContext(baseImageURL *url.URL, createConfig config.Create, username, password string) (types.SystemContext, error) {
 	scheme := baseImageURL.Scheme
 	switch scheme {
 	case "docker":
-		return types.SystemContext{
-			DockerInsecureSkipTLSVerify: skipTLSValidation(baseImageURL, createConfig.InsecureRegistries),
-			DockerAuthConfig: &types.DockerAuthConfig{
-				Username: username,
-				Password: password,
-			},
+		authConfig, err := registryAuthConfig(baseImageURL.Host, createConfig, username, password)
+		if err != nil {
+			return types.SystemContext{}, err
 		}
-	case "oci":
-		return types.SystemContext{
+
+		return withPlatformChoice(types.SystemContext{
+			DockerInsecureSkipTLSVerify: skipTLSValidation(baseImageURL, createConfig.InsecureRegistries),
+			DockerAuthConfig:            authConfig,
+			DockerCertPath:              registryCertPath(baseImageURL.Host, createConfig),
+		}, createConfig), nil
+	case "oci", "oci-archive":
+		return withPlatformChoice(types.SystemContext{
 			OCICertPath: createConfig.RemoteLayerClientCertificatesPath,
-		}
+		}, createConfig), nil
 	default:
-		return types.SystemContext{}
+		return types.SystemContext{}, nil
 	}
 
 }
 
+func withPlatformChoice(systemContext types.SystemContext, createConfig config.Create) types.SystemContext {
+	if createConfig.Platform == "" {
+		return systemContext
+	}
+
+	platform, _ := config.ParsePlatform(createConfig.Platform)
+	systemContext.OSChoice = platform.OS
+	systemContext.ArchitectureChoice = platform.Architecture
+	return systemContext
+}
+
+func formatPlatform(platform specsv1.Platform) string {
+	formattedPlatform := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		formattedPlatform += "/" + platform.Variant
+	}
+	return formattedPlatform
+}
+
+// registryAuthConfig returns the credentials given as flags or, when there are
+// none, the ones found in the registry auth file
+func registryAuthConfig(registry string, createConfig config.Create, username, password string) (*types.DockerAuthConfig, error) {
+	if username == "" && password == "" {
+		var err error
+		username, password, err = registryauth.Credentials(registryAuthFilePath(createConfig), registry)
+		if err != nil {
+			return nil, errorspkg.Wrapf(err, "reading credentials for registry `%s`", registry)
+		}
+	}
+
+	return &types.DockerAuthConfig{
+		Username: username,
+		Password: password,
+	}, nil
+}
+
+func registryAuthFilePath(createConfig config.Create) string {
+	if createConfig.RegistryAuthFile != "" {
+		return createConfig.RegistryAuthFile
+	}
+	return os.Getenv(registryauth.AuthFileEnvVar)
+}
+
+// registryCertPath returns the directory of the registry in the certs dir,
+// holding its ca.crt and optionally a client.cert and client.key. An empty path
+// keeps the system CAs.
+func registryCertPath(registry string, createConfig config.Create) string {
+	if createConfig.CertsDir == "" || registry == "" {
+		return ""
+	}
+
+	certPath := filepath.Join(createConfig.CertsDir, registry)
+	if info, err := os.Stat(certPath); err != nil || !info.IsDir() {
+		return ""
+	}
+
+	return certPath
+}
+
 func skipTLSValidation(baseImageURL *url.URL, trustedRegistries []string) bool {
+	return isTrustedRegistry(baseImageURL.Host, trustedRegistries)
+}
+
+func isTrustedRegistry(host string, trustedRegistries []string) bool {
 	for _, trustedRegistry := range trustedRegistries {
-		if baseImageURL.Host == trustedRegistry {
+		if host == trustedRegistry {
 			return true
 		}
 	}
@@ -329,6 +580,45 @@ func skipTLSValidation(baseImageURL *url.URL, trustedRegistries []string) bool {
 	return false
 }
 
+// registryMirrors returns the mirrors configured for the registry of the base
+// image. Mirrors are insecure when they are listed as insecure registries, and
+// use the credentials found for them in the registry auth file.
+func registryMirrors(baseImageURL *url.URL, registries []config.Registry, createConfig config.Create) ([]source.Mirror, error) {
+	if baseImageURL.Scheme != "docker" {
+		return nil, nil
+	}
+
+	mirrors := []source.Mirror{}
+	for _, registry := range registries {
+		if !sameRegistry(registry.Location, baseImageURL.Host) {
+			continue
+		}
+
+		for _, mirror := range registry.Mirrors {
+			authConfig, err := registryAuthConfig(mirror, createConfig, "", "")
+			if err != nil {
+				return nil, err
+			}
+
+			mirrors = append(mirrors, source.Mirror{
+				Host:       mirror,
+				Insecure:   isTrustedRegistry(mirror, createConfig.InsecureRegistries),
+				AuthConfig: authConfig,
+				CertPath:   registryCertPath(mirror, createConfig),
+			})
+		}
+	}
+
+	return mirrors, nil
+}
+
+func sameRegistry(location, host string) bool {
+	if source.IsDockerHub(location) {
+		return source.IsDockerHub(host)
+	}
+	return location == host
+}
+
 func containsDockerError(errorsList errcode.Errors, errCode errcode.ErrorCode) bool {
 	for _, err := range errorsList {
 		if e, ok := err.(errcode.Error); ok && e.ErrorCode() == errCode {
@@ -362,11 +652,11 @@ func tryParsingErrorMessage(err error) error {
 	return err
 }
 
-func tryHumanize(err error, spec groot.CreateSpec) string {
+func tryHumanize(err error, spec groot.CreateSpec, createConfig config.Create) string {
 	switch e := errorspkg.Cause(err).(type) {
 	case *url.Error:
 		if _, ok := e.Err.(x509.UnknownAuthorityError); ok {
-			return "This registry is insecure. To pull images from this registry, please use the --insecure-registry option."
+			return unknownAuthorityMessage(spec.BaseImageURL.Host, createConfig)
 		}
 
 	case errcode.Errors:
@@ -376,10 +666,26 @@ func tryHumanize(err error, spec groot.CreateSpec) string {
 	return tryParsingErrorMessage(err).Error()
 }
 
+func unknownAuthorityMessage(registry string, createConfig config.Create) string {
+	if createConfig.CertsDir == "" {
+		return fmt.Sprintf("This registry is signed by an unknown authority. To trust it, please set create.certs_dir in the config file and add its CA certificate as <certs_dir>/%s/ca.crt.", registry)
+	}
+
+	return fmt.Sprintf("This registry is signed by an unknown authority. To trust it, please add its CA certificate as %s.", filepath.Join(createConfig.CertsDir, registry, "ca.crt"))
+}
+
 func validateOptions(ctx *cli.Context, cfg config.Config) error {
 	if ctx.IsSet("with-clean") && ctx.IsSet("without-clean") {
 		return errorspkg.New("with-clean and without-clean cannot be used together")
 	}
 
+	if ctx.IsSet("progress-fd") && ctx.IsSet("progress-file") {
+		return errorspkg.New("progress-fd and progress-file cannot be used together")
+	}
+
+	if ctx.IsSet("progress-fd") && ctx.Int("progress-fd") == int(os.Stdout.Fd()) {
+		return errorspkg.New("progress-fd cannot be stdout, it is reserved for the runtime spec")
+	}
+
 	return nil
 }
@@ -2,15 +2,26 @@ package commands
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	"code.cloudfoundry.org/commandrunner"
 	"code.cloudfoundry.org/commandrunner/linux_command_runner"
 	"code.cloudfoundry.org/grootfs/base_image_puller"
 	unpackerpkg "code.cloudfoundry.org/grootfs/base_image_puller/unpacker"
 	"code.cloudfoundry.org/grootfs/commands/config"
+	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
+	"code.cloudfoundry.org/grootfs/fetcher/throttle"
 	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/progress"
+	storepkg "code.cloudfoundry.org/grootfs/store"
+	"code.cloudfoundry.org/grootfs/store/blob_cache"
+	"code.cloudfoundry.org/grootfs/store/digest_cache"
+	"code.cloudfoundry.org/grootfs/store/etag_cache"
 	"code.cloudfoundry.org/grootfs/store/filesystems/namespaced"
 	"code.cloudfoundry.org/grootfs/store/filesystems/overlayxfs"
 	"code.cloudfoundry.org/grootfs/store/image_cloner"
@@ -47,6 +58,103 @@ func createFileSystemDriver(cfg config.Config) (fileSystemDriver, error) {
 	}
 }
 
+// createBlobCache returns nil when the blob cache is disabled
+func createBlobCache(cfg config.Config) *blob_cache.BlobCache {
+	if cfg.BlobCacheSizeBytes == 0 {
+		return nil
+	}
+
+	return blob_cache.NewBlobCache(filepath.Join(cfg.StorePath, storepkg.BlobsDirName), cfg.BlobCacheSizeBytes)
+}
+
+func createPartialBlobs(cfg config.Config) *source.PartialBlobs {
+	return source.NewPartialBlobs(filepath.Join(cfg.StorePath, storepkg.TempDirName))
+}
+
+// createTarDigestCache returns nil when tarball chain IDs are not derived
+// from their content
+func createTarDigestCache(cfg config.Config) *digest_cache.DigestCache {
+	if !cfg.Create.ContentChainIDs {
+		return nil
+	}
+
+	return digest_cache.NewDigestCache(filepath.Join(cfg.StorePath, storepkg.MetaDirName, "tarball-digests"))
+}
+
+func createETagCache(cfg config.Config) *etag_cache.ETagCache {
+	return etag_cache.NewETagCache(filepath.Join(cfg.StorePath, storepkg.MetaDirName, "http-etags"))
+}
+
+// createBandwidthLimiters returns the limit of this create followed by the
+// limit shared by all the creates on the store, when they are set
+func createBandwidthLimiters(cfg config.Config) []throttle.Limiter {
+	limiters := []throttle.Limiter{}
+	if cfg.Create.MaxDownloadBytesPerSecond > 0 {
+		limiters = append(limiters, throttle.NewTokenBucket(cfg.Create.MaxDownloadBytesPerSecond))
+	}
+
+	if cfg.Create.StoreMaxDownloadBytesPerSecond > 0 {
+		statePath := filepath.Join(cfg.StorePath, storepkg.LocksDirName, "download-bandwidth.json")
+		limiters = append(limiters, throttle.NewSharedTokenBucket(statePath, cfg.Create.StoreMaxDownloadBytesPerSecond))
+	}
+
+	return limiters
+}
+
+// openProgressReporter returns a nil reporter when neither the file descriptor
+// nor the file are given, as progress events are opt-in. Stdout is reserved
+// for the runtime spec.
+func openProgressReporter(fd int, path string) (groot.ProgressReporter, io.Closer, error) {
+	var output *os.File
+	switch {
+	case fd > 0:
+		output = os.NewFile(uintptr(fd), fmt.Sprintf("progress-fd-%d", fd))
+	case path != "":
+		var err error
+		output, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, nil, errorspkg.Wrap(err, "opening progress file")
+		}
+	default:
+		return nil, nil, nil
+	}
+
+	return progress.NewWriter(output), output, nil
+}
+
+// createUnpacker returns the tar unpacker when running as root, and otherwise
+// the unpacker that maps the ids of the entries with newuidmap and newgidmap
+func createUnpacker(cfg config.Config, runner commandrunner.CommandRunner) (base_image_puller.Unpacker, unpackerpkg.IDMapper, error) {
+	unpackerStrategy := unpackerpkg.UnpackStrategy{
+		Name:               cfg.FSDriver,
+		WhiteoutDevicePath: filepath.Join(cfg.StorePath, overlayxfs.WhiteoutDevice),
+	}
+
+	if os.Getuid() == 0 {
+		unpacker, err := unpackerpkg.NewTarUnpacker(unpackerStrategy)
+		if err != nil {
+			return nil, nil, err
+		}
+		return unpacker, nil, nil
+	}
+
+	idMapper := unpackerpkg.NewIDMapper(cfg.NewuidmapBin, cfg.NewgidmapBin, runner)
+	return unpackerpkg.NewNSIdMapperUnpacker(runner, idMapper, unpackerStrategy), idMapper, nil
+}
+
+// closeUnpacker stops the helper process of unpackers that keep one around
+// between layers
+func closeUnpacker(logger lager.Logger, unpacker base_image_puller.Unpacker) {
+	closer, ok := unpacker.(io.Closer)
+	if !ok {
+		return
+	}
+
+	if err := closer.Close(); err != nil {
+		logger.Error("closing-unpacker", err)
+	}
+}
+
 func createImageDriver(cfg config.Config, fsDriver fileSystemDriver) (image_cloner.ImageDriver, error) {
 	if !nsImageDriverRequired(cfg) {
 		return fsDriver, nil
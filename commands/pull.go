@@ -0,0 +1,211 @@
+package commands // import "code.cloudfoundry.org/grootfs/commands"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+
+	"code.cloudfoundry.org/commandrunner/linux_command_runner"
+	"code.cloudfoundry.org/grootfs/base_image_puller"
+	"code.cloudfoundry.org/grootfs/commands/config"
+	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/metrics"
+	"code.cloudfoundry.org/grootfs/progress"
+	storepkg "code.cloudfoundry.org/grootfs/store"
+	"code.cloudfoundry.org/grootfs/store/dependency_manager"
+	"code.cloudfoundry.org/grootfs/store/filesystems/namespaced"
+	locksmithpkg "code.cloudfoundry.org/grootfs/store/locksmith"
+	"code.cloudfoundry.org/grootfs/store/manager"
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var PullCommand = cli.Command{
+	Name:        "pull",
+	Usage:       "pull [options] <image>",
+	Description: "Pulls the layers of the provided image into the store, without creating a root filesystem.",
+
+	Flags: []cli.Flag{
+		cli.Int64Flag{
+			Name:  "max-download-bytes-per-second",
+			Usage: "Limit the download rate of image layers",
+		},
+		cli.StringSliceFlag{
+			Name:  "insecure-registry",
+			Usage: "Whitelist a private registry",
+		},
+		cli.BoolFlag{
+			Name:  "skip-layer-validation",
+			Usage: "Do not validate checksums and sizes of image layers. (Can only be used with oci:/// and oci-archive:/// protocol images.)",
+		},
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "Platform to pick from multi-platform images, as os/arch[/variant]",
+		},
+		cli.StringFlag{
+			Name:  "username",
+			Usage: "Username to authenticate in image registry",
+		},
+		cli.StringFlag{
+			Name:  "password",
+			Usage: "Password to authenticate in image registry",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("pull")
+
+		if ctx.NArg() != 1 {
+			logger.Error("parsing-command", errorspkg.New("invalid arguments"), lager.Data{"args": ctx.Args()})
+			return cli.NewExitError(fmt.Sprintf("invalid arguments - usage: %s", ctx.Command.Usage), 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		configBuilder.WithInsecureRegistries(ctx.StringSlice("insecure-registry")).
+			WithMaxDownloadBytesPerSecond(ctx.Int64("max-download-bytes-per-second"),
+				ctx.IsSet("max-download-bytes-per-second")).
+			WithSkipLayerValidation(ctx.Bool("skip-layer-validation"),
+				ctx.IsSet("skip-layer-validation")).
+			WithPlatform(ctx.String("platform"), ctx.IsSet("platform"))
+
+		cfg, err := configBuilder.Build()
+		logger.Debug("pull-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		baseImageURL, err := url.Parse(ctx.Args().First())
+		if err != nil {
+			logger.Error("base-image-url-parsing-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		puller, idMappings, err := newImagePuller(logger, cfg, ctx.String("username"), ctx.String("password"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer puller.Close(logger)
+
+		summary, err := puller.Pull(logger, groot.PullSpec{
+			BaseImageURL: baseImageURL,
+			UIDMappings:  idMappings.UIDMappings,
+			GIDMappings:  idMappings.GIDMappings,
+		})
+		if err != nil {
+			logger.Error("pulling", err)
+			if _, ok := errorspkg.Cause(err).(*source.ImageRejectedError); ok {
+				return cli.NewExitError(errorspkg.Cause(err).Error(), ImageRejectedExitCode)
+			}
+			return cli.NewExitError(tryHumanize(err, groot.CreateSpec{BaseImageURL: baseImageURL}, cfg.Create), 1)
+		}
+
+		jsonBytes, err := json.Marshal(summary)
+		if err != nil {
+			logger.Error("formatting output", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(string(jsonBytes))
+
+		return nil
+	},
+}
+
+// imagePuller pulls images into the store, with a fetcher for each of them
+type imagePuller struct {
+	cfg                config.Config
+	username           string
+	password           string
+	unpacker           base_image_puller.Unpacker
+	volumeDriver       *namespaced.Driver
+	metricsEmitter     *metrics.Emitter
+	sharedLocksmith    *locksmithpkg.FileSystem
+	exclusiveLocksmith *locksmithpkg.FileSystem
+	dependencyManager  *dependency_manager.DependencyManager
+}
+
+func newImagePuller(logger lager.Logger, cfg config.Config, username, password string) (*imagePuller, groot.IDMappings, error) {
+	storePath := cfg.StorePath
+	fsDriver, err := createFileSystemDriver(cfg)
+	if err != nil {
+		return nil, groot.IDMappings{}, err
+	}
+
+	metricsEmitter := metrics.NewEmitter(logger, cfg.MetronEndpoint)
+
+	initLocksDir := filepath.Join("/", "var", "run")
+	storeLocksDir := filepath.Join(storePath, storepkg.LocksDirName)
+	initStoreLocksmith := locksmithpkg.NewExclusiveFileSystem(initLocksDir)
+
+	storeNamespacer := groot.NewStoreNamespacer(storePath)
+	manager := manager.New(storePath, storeNamespacer, fsDriver, fsDriver, fsDriver, initStoreLocksmith)
+	if !manager.IsStoreInitialized(logger) {
+		logger.Error("store-verification-failed", errors.New("store is not initialized"))
+		return nil, groot.IDMappings{}, errors.New("Store path is not initialized. Please run init-store.")
+	}
+
+	idMappings, err := storeNamespacer.Read()
+	if err != nil {
+		logger.Error("reading-namespace-file", err)
+		return nil, groot.IDMappings{}, err
+	}
+
+	runner := linux_command_runner.New()
+	unpacker, idMapper, err := createUnpacker(cfg, runner)
+	if err != nil {
+		return nil, groot.IDMappings{}, err
+	}
+
+	return &imagePuller{
+		cfg:                cfg,
+		username:           username,
+		password:           password,
+		unpacker:           unpacker,
+		volumeDriver:       namespaced.New(fsDriver, idMappings, idMapper, runner),
+		metricsEmitter:     metricsEmitter,
+		sharedLocksmith:    locksmithpkg.NewSharedFileSystem(storeLocksDir).WithMetrics(metricsEmitter),
+		exclusiveLocksmith: locksmithpkg.NewExclusiveFileSystem(storeLocksDir).WithMetrics(metricsEmitter),
+		dependencyManager: dependency_manager.NewDependencyManager(
+			filepath.Join(storePath, storepkg.MetaDirName, "dependencies"),
+		),
+	}, idMappings, nil
+}
+
+func (p *imagePuller) Pull(logger lager.Logger, spec groot.PullSpec) (groot.PullSummary, error) {
+	downloadCounter := source.NewDownloadCounter()
+	layerRecorder := progress.NewLayerRecorder()
+	fetcher, err := createImageFetcher(logger, spec.BaseImageURL, p.cfg, p.username, p.password, nil, downloadCounter)
+	if err != nil {
+		return groot.PullSummary{}, err
+	}
+	defer func() {
+		if err := fetcher.Close(); err != nil {
+			logger.Error("closing-fetcher", err)
+		}
+	}()
+
+	baseImagePuller := base_image_puller.NewBaseImagePuller(
+		fetcher,
+		p.unpacker,
+		p.volumeDriver,
+		p.metricsEmitter,
+		p.exclusiveLocksmith,
+	).WithMaxParallelDownloads(p.cfg.Create.MaxParallelDownloads).
+		WithPipelinedUnpack(p.cfg.Create.PipelinedPull).
+		WithProgressReporter(layerRecorder)
+
+	puller := groot.IamPuller(baseImagePuller, p.volumeDriver, p.sharedLocksmith, p.dependencyManager, p.metricsEmitter).
+		WithDownloadCounter(downloadCounter).
+		WithUnpackedLayers(layerRecorder)
+	return puller.Pull(logger, spec)
+}
+
+// Close stops the unpacker shared by the pulls
+func (p *imagePuller) Close(logger lager.Logger) {
+	closeUnpacker(logger, p.unpacker)
+}
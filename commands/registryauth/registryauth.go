@@ -0,0 +1,145 @@
+package registryauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
+	errorspkg "github.com/pkg/errors"
+)
+
+// AuthFileEnvVar names the environment variable that points to the auths file
+// when it is not set in the config
+const AuthFileEnvVar = "REGISTRY_AUTH_FILE"
+
+// dockerHubServerURL is the key docker uses for Docker Hub in the auths file
+const dockerHubServerURL = "https://index.docker.io/v1/"
+
+type authFile struct {
+	Auths       map[string]authEntry `json:"auths"`
+	CredHelpers map[string]string    `json:"credHelpers"`
+	CredsStore  string               `json:"credsStore"`
+}
+
+type authEntry struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type helperCredentials struct {
+	Username string `json:"Username"`
+	Secret   string `json:"Secret"`
+}
+
+// Credentials looks up the credentials for the registry in an auths file in
+// the docker `config.json` format. Credential helpers configured in
+// `credHelpers` or `credsStore` take precedence over the `auths` entries.
+// Empty credentials are returned when the file or the registry is not found.
+func Credentials(authFilePath, registry string) (string, string, error) {
+	if authFilePath == "" {
+		return "", "", nil
+	}
+
+	contents, err := ioutil.ReadFile(authFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", nil
+		}
+		return "", "", errorspkg.Wrap(err, "reading auth file")
+	}
+
+	var file authFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return "", "", errorspkg.Wrap(err, "parsing auth file")
+	}
+
+	for key, helper := range file.CredHelpers {
+		if sameRegistry(key, registry) {
+			return helperGet(helper, key)
+		}
+	}
+
+	if file.CredsStore != "" {
+		username, password, err := helperGet(file.CredsStore, serverURL(registry))
+		if err != nil || username != "" || password != "" {
+			return username, password, err
+		}
+	}
+
+	for key, entry := range file.Auths {
+		if sameRegistry(key, registry) {
+			return entry.credentials()
+		}
+	}
+
+	return "", "", nil
+}
+
+func (e authEntry) credentials() (string, string, error) {
+	if e.Auth == "" {
+		return e.Username, e.Password, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+	if err != nil {
+		return "", "", errorspkg.Wrap(err, "decoding registry auth")
+	}
+
+	usernamePassword := strings.SplitN(string(decoded), ":", 2)
+	if len(usernamePassword) != 2 {
+		return "", "", errorspkg.New("invalid registry auth: expected `username:password`")
+	}
+
+	return usernamePassword[0], usernamePassword[1], nil
+}
+
+// helperGet runs `docker-credential-<helper> get`, following the docker
+// credential helpers protocol
+func helperGet(helper, serverURL string) (string, string, error) {
+	stdout := bytes.NewBuffer([]byte{})
+	stderr := bytes.NewBuffer([]byte{})
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(output, "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", errorspkg.Wrapf(err, "running credential helper `%s`: %s", helper, output)
+	}
+
+	var credentials helperCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		return "", "", errorspkg.Wrapf(err, "parsing credential helper `%s` output", helper)
+	}
+
+	return credentials.Username, credentials.Secret, nil
+}
+
+// sameRegistry compares an auths file key, which might be a URL such as
+// `https://index.docker.io/v1/`, with a registry host
+func sameRegistry(key, registry string) bool {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+
+	if source.IsDockerHub(host) {
+		return source.IsDockerHub(registry)
+	}
+	return host == registry
+}
+
+func serverURL(registry string) string {
+	if source.IsDockerHub(registry) {
+		return dockerHubServerURL
+	}
+	return registry
+}
@@ -0,0 +1,13 @@
+package registryauth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRegistryauth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Registryauth Suite")
+}
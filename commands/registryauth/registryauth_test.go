@@ -0,0 +1,153 @@
+package registryauth_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/grootfs/commands/registryauth"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Credentials", func() {
+	var (
+		authDir      string
+		authFilePath string
+		oldPath      string
+	)
+
+	writeAuthFile := func(contents string) {
+		Expect(ioutil.WriteFile(authFilePath, []byte(contents), 0600)).To(Succeed())
+	}
+
+	// writeHelper creates a fake `docker-credential-<name>` executable
+	writeHelper := func(name, script string) {
+		helperPath := filepath.Join(authDir, "docker-credential-"+name)
+		Expect(ioutil.WriteFile(helperPath, []byte("#!/bin/sh\n"+script), 0755)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		authDir, err = ioutil.TempDir("", "registryauth")
+		Expect(err).NotTo(HaveOccurred())
+		authFilePath = filepath.Join(authDir, "config.json")
+
+		oldPath = os.Getenv("PATH")
+		Expect(os.Setenv("PATH", authDir+":"+oldPath)).To(Succeed())
+
+		writeHelper("fake", `[ "$1" = "get" ] || exit 1
+echo '{"ServerURL": "'$(cat)'", "Username": "helper-user", "Secret": "helper-secret"}'
+`)
+		writeHelper("empty", `echo "credentials not found in native keychain"; exit 1`)
+		writeHelper("broken", `echo "something went wrong" >&2; exit 1`)
+	})
+
+	AfterEach(func() {
+		Expect(os.Setenv("PATH", oldPath)).To(Succeed())
+		Expect(os.RemoveAll(authDir)).To(Succeed())
+	})
+
+	It("reads the credentials from the auths", func() {
+		auth := base64.StdEncoding.EncodeToString([]byte("user:pass:word"))
+		writeAuthFile(fmt.Sprintf(`{"auths": {"registry.example.com": {"auth": "%s"}}}`, auth))
+
+		username, password, err := registryauth.Credentials(authFilePath, "registry.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(username).To(Equal("user"))
+		Expect(password).To(Equal("pass:word"))
+	})
+
+	It("accepts plain usernames and passwords", func() {
+		writeAuthFile(`{"auths": {"registry.example.com": {"username": "user", "password": "pass"}}}`)
+
+		username, password, err := registryauth.Credentials(authFilePath, "registry.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(username).To(Equal("user"))
+		Expect(password).To(Equal("pass"))
+	})
+
+	It("matches docker hub images with the docker hub auths key", func() {
+		writeAuthFile(`{"auths": {"https://index.docker.io/v1/": {"username": "user", "password": "pass"}}}`)
+
+		username, _, err := registryauth.Credentials(authFilePath, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(username).To(Equal("user"))
+	})
+
+	It("uses the registry credential helper", func() {
+		writeAuthFile(`{
+			"auths": {"registry.example.com": {"username": "user", "password": "pass"}},
+			"credHelpers": {"registry.example.com": "fake"}
+		}`)
+
+		username, password, err := registryauth.Credentials(authFilePath, "registry.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(username).To(Equal("helper-user"))
+		Expect(password).To(Equal("helper-secret"))
+	})
+
+	It("uses the credentials store", func() {
+		writeAuthFile(`{"credsStore": "fake"}`)
+
+		username, password, err := registryauth.Credentials(authFilePath, "registry.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(username).To(Equal("helper-user"))
+		Expect(password).To(Equal("helper-secret"))
+	})
+
+	Context("when the credentials store does not know the registry", func() {
+		It("falls back to the auths", func() {
+			writeAuthFile(`{
+				"auths": {"registry.example.com": {"username": "user", "password": "pass"}},
+				"credsStore": "empty"
+			}`)
+
+			username, password, err := registryauth.Credentials(authFilePath, "registry.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(Equal("user"))
+			Expect(password).To(Equal("pass"))
+		})
+	})
+
+	Context("when the credential helper fails", func() {
+		It("returns an error", func() {
+			writeAuthFile(`{"credHelpers": {"registry.example.com": "broken"}}`)
+
+			_, _, err := registryauth.Credentials(authFilePath, "registry.example.com")
+			Expect(err).To(MatchError(ContainSubstring("something went wrong")))
+		})
+	})
+
+	Context("when the registry is not in the file", func() {
+		It("returns empty credentials", func() {
+			writeAuthFile(`{"auths": {"registry.example.com": {"username": "user", "password": "pass"}}}`)
+
+			username, password, err := registryauth.Credentials(authFilePath, "other.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(BeEmpty())
+			Expect(password).To(BeEmpty())
+		})
+	})
+
+	Context("when the file does not exist", func() {
+		It("returns empty credentials", func() {
+			username, password, err := registryauth.Credentials(authFilePath, "registry.example.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(username).To(BeEmpty())
+			Expect(password).To(BeEmpty())
+		})
+	})
+
+	Context("when the file is invalid", func() {
+		It("returns an error", func() {
+			writeAuthFile("not-json")
+
+			_, _, err := registryauth.Credentials(authFilePath, "registry.example.com")
+			Expect(err).To(MatchError(ContainSubstring("parsing auth file")))
+		})
+	})
+})
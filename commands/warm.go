@@ -0,0 +1,142 @@
+package commands // import "code.cloudfoundry.org/grootfs/commands"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"code.cloudfoundry.org/grootfs/commands/config"
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var WarmCommand = cli.Command{
+	Name:        "warm",
+	Usage:       "warm --from <file>",
+	Description: "Pulls and pins the layers of the images listed in a YAML file, and releases the pins of the images that are no longer listed.",
+
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "from",
+			Usage: "Path to a YAML file with the list of image URLs to keep warm",
+		},
+		cli.Int64Flag{
+			Name:  "max-download-bytes-per-second",
+			Usage: "Limit the download rate of image layers",
+		},
+		cli.StringSliceFlag{
+			Name:  "insecure-registry",
+			Usage: "Whitelist a private registry",
+		},
+		cli.BoolFlag{
+			Name:  "skip-layer-validation",
+			Usage: "Do not validate checksums and sizes of image layers. (Can only be used with oci:/// and oci-archive:/// protocol images.)",
+		},
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "Platform to pick from multi-platform images, as os/arch[/variant]",
+		},
+		cli.StringFlag{
+			Name:  "username",
+			Usage: "Username to authenticate in image registry",
+		},
+		cli.StringFlag{
+			Name:  "password",
+			Usage: "Password to authenticate in image registry",
+		},
+	},
+
+	Action: func(ctx *cli.Context) error {
+		logger := ctx.App.Metadata["logger"].(lager.Logger)
+		logger = logger.Session("warm")
+
+		if ctx.NArg() != 0 || ctx.String("from") == "" {
+			logger.Error("parsing-command", errorspkg.New("invalid arguments"), lager.Data{"args": ctx.Args()})
+			return cli.NewExitError(fmt.Sprintf("invalid arguments - usage: %s", ctx.Command.Usage), 1)
+		}
+
+		configBuilder := ctx.App.Metadata["configBuilder"].(*config.Builder)
+		configBuilder.WithInsecureRegistries(ctx.StringSlice("insecure-registry")).
+			WithMaxDownloadBytesPerSecond(ctx.Int64("max-download-bytes-per-second"),
+				ctx.IsSet("max-download-bytes-per-second")).
+			WithSkipLayerValidation(ctx.Bool("skip-layer-validation"),
+				ctx.IsSet("skip-layer-validation")).
+			WithPlatform(ctx.String("platform"), ctx.IsSet("platform"))
+
+		cfg, err := configBuilder.Build()
+		logger.Debug("warm-config", lager.Data{"currentConfig": cfg})
+		if err != nil {
+			logger.Error("config-builder-failed", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		baseImageURLs, err := readWarmList(ctx.String("from"))
+		if err != nil {
+			logger.Error("reading-warm-list", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		puller, idMappings, err := newImagePuller(logger, cfg, ctx.String("username"), ctx.String("password"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		defer puller.Close(logger)
+
+		warmer := groot.IamWarmer(puller, puller.dependencyManager, puller.exclusiveLocksmith)
+		summary, err := warmer.Warm(logger, groot.WarmSpec{
+			BaseImageURLs: baseImageURLs,
+			UIDMappings:   idMappings.UIDMappings,
+			GIDMappings:   idMappings.GIDMappings,
+		})
+		if err != nil {
+			logger.Error("warming", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		jsonBytes, err := json.Marshal(summary)
+		if err != nil {
+			logger.Error("formatting output", err)
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(string(jsonBytes))
+
+		failed := 0
+		for _, image := range summary.Images {
+			if image.Status == groot.ImageFailed {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return cli.NewExitError(fmt.Sprintf("%d/%d images failed to warm", failed, len(summary.Images)), 1)
+		}
+
+		return nil
+	},
+}
+
+func readWarmList(path string) ([]*url.URL, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading the image list")
+	}
+
+	var rawURLs []string
+	if err := yaml.Unmarshal(contents, &rawURLs); err != nil {
+		return nil, errorspkg.Wrap(err, "parsing the image list")
+	}
+
+	baseImageURLs := []*url.URL{}
+	for _, rawURL := range rawURLs {
+		baseImageURL, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, errorspkg.Wrapf(err, "parsing image url %s", rawURL)
+		}
+		baseImageURLs = append(baseImageURLs, baseImageURL)
+	}
+
+	return baseImageURLs, nil
+}
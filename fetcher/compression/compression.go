@@ -0,0 +1,90 @@
+package compression // import "code.cloudfoundry.org/grootfs/fetcher/compression"
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	errorspkg "github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+type Compression string
+
+const (
+	None Compression = "none"
+	Gzip Compression = "gzip"
+	Zstd Compression = "zstd"
+	Xz   Compression = "xz"
+)
+
+var magicBytes = map[Compression][]byte{
+	Gzip: {0x1f, 0x8b},
+	Zstd: {0x28, 0xb5, 0x2f, 0xfd},
+	Xz:   {0xfd, '7', 'z', 'X', 'Z', 0x00},
+}
+
+// FromMediaType returns the compression of a layer media type, or an empty
+// compression when the media type is empty or does not tell
+func FromMediaType(mediaType string) Compression {
+	switch {
+	case strings.Contains(mediaType, "gzip"):
+		return Gzip
+	case strings.Contains(mediaType, "zstd"):
+		return Zstd
+	case strings.HasSuffix(mediaType, ".tar"):
+		return None
+	default:
+		return ""
+	}
+}
+
+// Detect detects the compression from the first bytes of the stream. The
+// returned reader yields the whole stream, including the bytes that were
+// inspected.
+func Detect(stream io.Reader) (Compression, io.Reader) {
+	bufferedStream := bufio.NewReader(stream)
+	// a short stream is not compressed, Peek returns whatever is available
+	header, _ := bufferedStream.Peek(len(magicBytes[Xz]))
+
+	for _, compression := range []Compression{Gzip, Zstd, Xz} {
+		if bytes.HasPrefix(header, magicBytes[compression]) {
+			return compression, bufferedStream
+		}
+	}
+
+	return None, bufferedStream
+}
+
+// Decompress returns the uncompressed contents of the stream
+func Decompress(stream io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case Gzip:
+		gzipReader, err := gzip.NewReader(stream)
+		if err != nil {
+			return nil, errorspkg.Wrap(err, "reading gzip stream")
+		}
+		return gzipReader, nil
+
+	case Zstd:
+		zstdReader, err := zstd.NewReader(stream)
+		if err != nil {
+			return nil, errorspkg.Wrap(err, "reading zstd stream")
+		}
+		return zstdReader.IOReadCloser(), nil
+
+	case Xz:
+		xzReader, err := xz.NewReader(stream)
+		if err != nil {
+			return nil, errorspkg.Wrap(err, "reading xz stream")
+		}
+		return ioutil.NopCloser(xzReader), nil
+
+	default:
+		return ioutil.NopCloser(stream), nil
+	}
+}
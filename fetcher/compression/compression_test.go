@@ -0,0 +1,101 @@
+package compression_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	"code.cloudfoundry.org/grootfs/fetcher/compression"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression", func() {
+	var contents []byte
+
+	compress := func(c compression.Compression) []byte {
+		buffer := bytes.NewBuffer([]byte{})
+		switch c {
+		case compression.Gzip:
+			writer := gzip.NewWriter(buffer)
+			_, err := writer.Write(contents)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(writer.Close()).To(Succeed())
+		case compression.Zstd:
+			writer, err := zstd.NewWriter(buffer)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = writer.Write(contents)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(writer.Close()).To(Succeed())
+		case compression.Xz:
+			writer, err := xz.NewWriter(buffer)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = writer.Write(contents)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(writer.Close()).To(Succeed())
+		default:
+			buffer.Write(contents)
+		}
+		return buffer.Bytes()
+	}
+
+	BeforeEach(func() {
+		contents = []byte("hello-world")
+	})
+
+	Describe("FromMediaType", func() {
+		It("recognises the compressed layer media types", func() {
+			Expect(compression.FromMediaType("application/vnd.oci.image.layer.v1.tar+gzip")).To(Equal(compression.Gzip))
+			Expect(compression.FromMediaType("application/vnd.docker.image.rootfs.diff.tar.gzip")).To(Equal(compression.Gzip))
+			Expect(compression.FromMediaType("application/vnd.oci.image.layer.v1.tar+zstd")).To(Equal(compression.Zstd))
+		})
+
+		It("recognises uncompressed layer media types", func() {
+			Expect(compression.FromMediaType("application/vnd.oci.image.layer.v1.tar")).To(Equal(compression.None))
+		})
+
+		It("does not guess from unknown media types", func() {
+			Expect(compression.FromMediaType("")).To(BeEmpty())
+			Expect(compression.FromMediaType("application/octet-stream")).To(BeEmpty())
+		})
+	})
+
+	Describe("Detect and Decompress", func() {
+		for _, c := range []compression.Compression{compression.Gzip, compression.Zstd, compression.Xz, compression.None} {
+			c := c
+
+			It("detects and decompresses "+string(c)+" streams", func() {
+				detected, stream := compression.Detect(bytes.NewReader(compress(c)))
+				Expect(detected).To(Equal(c))
+
+				reader, err := compression.Decompress(stream, detected)
+				Expect(err).NotTo(HaveOccurred())
+				defer reader.Close()
+
+				Expect(ioutil.ReadAll(reader)).To(Equal(contents))
+			})
+		}
+
+		Context("when the stream is shorter than the magic bytes", func() {
+			BeforeEach(func() {
+				contents = []byte("h")
+			})
+
+			It("is not compressed", func() {
+				detected, stream := compression.Detect(bytes.NewReader(contents))
+				Expect(detected).To(Equal(compression.None))
+				Expect(ioutil.ReadAll(stream)).To(Equal(contents))
+			})
+		})
+
+		Context("when the stream is corrupted", func() {
+			It("returns an error", func() {
+				_, err := compression.Decompress(bytes.NewReader([]byte("not-gzip")), compression.Gzip)
+				Expect(err).To(MatchError(ContainSubstring("reading gzip stream")))
+			})
+		})
+	})
+})
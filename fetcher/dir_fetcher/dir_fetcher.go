@@ -0,0 +1,293 @@
+package dir_fetcher // import "code.cloudfoundry.org/grootfs/fetcher/dir_fetcher"
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// DirFetcher provides a local directory tree as a single layer base image
+type DirFetcher struct {
+	baseImagePath string
+}
+
+func NewDirFetcher(baseImageURL *url.URL) *DirFetcher {
+	return &DirFetcher{baseImagePath: baseImageURL.Path}
+}
+
+func (f *DirFetcher) StreamBlob(logger lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
+	logger = logger.Session("stream-blob", lager.Data{
+		"baseImagePath": f.baseImagePath,
+		"source":        layerInfo.BlobID,
+	})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	if err := f.validateBaseImage(); err != nil {
+		return nil, 0, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(f.writeTar(logger, writer))
+	}()
+
+	return reader, 0, nil
+}
+
+func (f *DirFetcher) BaseImageInfo(logger lager.Logger) (groot.BaseImageInfo, error) {
+	logger = logger.Session("layers-digest", lager.Data{"baseImagePath": f.baseImagePath})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	if err := f.validateBaseImage(); err != nil {
+		return groot.BaseImageInfo{}, err
+	}
+
+	chainID, err := f.generateChainID()
+	if err != nil {
+		return groot.BaseImageInfo{}, errorspkg.Wrap(err, "generating chain id")
+	}
+
+	return groot.BaseImageInfo{
+		LayerInfos: []groot.LayerInfo{
+			groot.LayerInfo{
+				BlobID:        f.baseImagePath,
+				ParentChainID: "",
+				ChainID:       chainID,
+			},
+		},
+	}, nil
+}
+
+func (f *DirFetcher) Close() error {
+	return nil
+}
+
+func (f *DirFetcher) validateBaseImage() error {
+	stat, err := os.Stat(f.baseImagePath)
+	if err != nil {
+		return errorspkg.Wrapf(err, "local image not found in `%s`", f.baseImagePath)
+	}
+
+	if !stat.IsDir() {
+		return errorspkg.Errorf("invalid base image: `%s` is not a directory", f.baseImagePath)
+	}
+
+	return nil
+}
+
+// generateChainID hashes the metadata of every entry in the tree, so that
+// the chain ID only changes when something in the tree does. File contents
+// are not hashed: hashing the whole tree on every create would cost as much
+// as unpacking it.
+func (f *DirFetcher) generateChainID() (string, error) {
+	hash := sha256.New()
+	err := filepath.Walk(f.baseImagePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		header, err := f.tarHeader(path, info, nil)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "%s %o %d:%d %d %d %s\n",
+			header.Name, header.Mode, header.Uid, header.Gid, header.Size,
+			info.ModTime().UnixNano(), header.Linkname,
+		)
+		for _, key := range sortedKeys(header.PAXRecords) {
+			fmt.Fprintf(hash, "%s=%x\n", key, header.PAXRecords[key])
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (f *DirFetcher) writeTar(logger lager.Logger, writer io.Writer) error {
+	tarWriter := tar.NewWriter(writer)
+	hardlinks := map[fileID]string{}
+
+	err := filepath.Walk(f.baseImagePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		header, err := f.tarHeader(path, info, hardlinks)
+		if err != nil {
+			return err
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return errorspkg.Wrapf(err, "writing tar header for `%s`", path)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return errorspkg.Wrapf(err, "opening `%s`", path)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(tarWriter, file); err != nil {
+			return errorspkg.Wrapf(err, "copying `%s`", path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Error("streaming-directory-failed", err)
+		return errorspkg.Wrap(err, "streaming local image")
+	}
+
+	return tarWriter.Close()
+}
+
+// fileID tells files apart across the filesystems mounted in the tree, where
+// inode numbers are only unique per device
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// tarHeader builds the header of the entry, keeping ownership and xattrs.
+// When hardlinks is not nil, files already seen through another link are
+// turned into hardlink entries.
+func (f *DirFetcher) tarHeader(path string, info os.FileInfo, hardlinks map[fileID]string) (*tar.Header, error) {
+	var linkTarget string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if linkTarget, err = os.Readlink(path); err != nil {
+			return nil, errorspkg.Wrapf(err, "reading symlink `%s`", path)
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, linkTarget)
+	if err != nil {
+		return nil, errorspkg.Wrapf(err, "creating tar header for `%s`", path)
+	}
+
+	relPath, err := filepath.Rel(f.baseImagePath, path)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = "./" + filepath.ToSlash(relPath)
+	if relPath == "." {
+		header.Name = "./"
+	}
+	if info.IsDir() && !strings.HasSuffix(header.Name, "/") {
+		header.Name += "/"
+	}
+	header.Uname = ""
+	header.Gname = ""
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		header.Uid = int(stat.Uid)
+		header.Gid = int(stat.Gid)
+
+		if hardlinks != nil && header.Typeflag == tar.TypeReg && stat.Nlink > 1 {
+			id := fileID{dev: uint64(stat.Dev), ino: stat.Ino}
+			if firstPath, seen := hardlinks[id]; seen {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = firstPath
+				header.Size = 0
+			} else {
+				hardlinks[id] = header.Name
+			}
+		}
+	}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(xattrs) > 0 {
+		header.Format = tar.FormatPAX
+		header.PAXRecords = map[string]string{}
+		for name, value := range xattrs {
+			header.PAXRecords[xattrPAXPrefix+name] = value
+		}
+	}
+
+	return header, nil
+}
+
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+			return nil, nil
+		}
+		return nil, errorspkg.Wrapf(err, "listing xattrs of `%s`", path)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	names := make([]byte, size)
+	if size, err = unix.Llistxattr(path, names); err != nil {
+		return nil, errorspkg.Wrapf(err, "listing xattrs of `%s`", path)
+	}
+
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		value, err := lgetxattr(path, string(name))
+		if err != nil {
+			return nil, err
+		}
+		xattrs[string(name)] = string(value)
+	}
+
+	return xattrs, nil
+}
+
+func lgetxattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return nil, errorspkg.Wrapf(err, "reading xattr `%s` of `%s`", name, path)
+	}
+
+	value := make([]byte, size)
+	if size, err = unix.Lgetxattr(path, name, value); err != nil {
+		return nil, errorspkg.Wrapf(err, "reading xattr `%s` of `%s`", name, path)
+	}
+
+	return value[:size], nil
+}
+
+func sortedKeys(records map[string]string) []string {
+	keys := []string{}
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
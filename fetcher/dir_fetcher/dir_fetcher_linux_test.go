@@ -0,0 +1,201 @@
+package dir_fetcher_test
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	fetcherpkg "code.cloudfoundry.org/grootfs/fetcher/dir_fetcher"
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Dir Fetcher", func() {
+	var (
+		fetcher *fetcherpkg.DirFetcher
+
+		baseImagePath string
+		logger        lager.Logger
+		baseImageURL  *url.URL
+	)
+
+	BeforeEach(func() {
+		var err error
+		baseImagePath, err = ioutil.TempDir("", "dir-image")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Mkdir(filepath.Join(baseImagePath, "etc"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(baseImagePath, "etc", "a_file"), []byte("hello-world"), 0600)).To(Succeed())
+		Expect(os.Link(filepath.Join(baseImagePath, "etc", "a_file"), filepath.Join(baseImagePath, "hardlink"))).To(Succeed())
+		Expect(os.Symlink("etc/a_file", filepath.Join(baseImagePath, "symlink"))).To(Succeed())
+
+		logger = lagertest.NewTestLogger("dir-fetcher")
+		baseImageURL, err = url.Parse(baseImagePath)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		fetcher = fetcherpkg.NewDirFetcher(baseImageURL)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(baseImagePath)).To(Succeed())
+	})
+
+	Describe("StreamBlob", func() {
+		var entries map[string]tarEntry
+
+		JustBeforeEach(func() {
+			stream, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+			Expect(err).NotTo(HaveOccurred())
+			defer stream.Close()
+
+			entries = streamTar(tar.NewReader(stream))
+		})
+
+		It("returns the contents of the directory as a tar stream", func() {
+			Expect(entries).To(HaveKey("./"))
+			Expect(entries).To(HaveKey("./etc/"))
+			Expect(entries["./etc/"].header.Typeflag).To(BeEquivalentTo(tar.TypeDir))
+
+			file := entries["./etc/a_file"]
+			Expect(file.header.Mode).To(Equal(int64(0600)))
+			Expect(string(file.contents)).To(Equal("hello-world"))
+		})
+
+		It("preserves the ownership", func() {
+			file := entries["./etc/a_file"]
+			Expect(file.header.Uid).To(Equal(os.Getuid()))
+			Expect(file.header.Gid).To(Equal(os.Getgid()))
+		})
+
+		It("preserves symlinks", func() {
+			symlink := entries["./symlink"]
+			Expect(symlink.header.Typeflag).To(BeEquivalentTo(tar.TypeSymlink))
+			Expect(symlink.header.Linkname).To(Equal("etc/a_file"))
+		})
+
+		It("preserves hardlinks", func() {
+			// the walk is lexical, so ./etc/a_file is seen first
+			hardlink := entries["./hardlink"]
+			Expect(hardlink.header.Typeflag).To(BeEquivalentTo(tar.TypeLink))
+			Expect(hardlink.header.Linkname).To(Equal("./etc/a_file"))
+			Expect(hardlink.contents).To(BeEmpty())
+		})
+
+		Context("when files have xattrs", func() {
+			BeforeEach(func() {
+				err := unix.Lsetxattr(filepath.Join(baseImagePath, "etc", "a_file"), "user.grootfs-test", []byte("hello"), 0)
+				if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+					Skip("the filesystem does not support user xattrs")
+				}
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("preserves them", func() {
+				file := entries["./etc/a_file"]
+				Expect(file.header.PAXRecords).To(HaveKeyWithValue("SCHILY.xattr.user.grootfs-test", "hello"))
+			})
+		})
+	})
+
+	Describe("BaseImageInfo", func() {
+		var baseImageInfo groot.BaseImageInfo
+
+		JustBeforeEach(func() {
+			var err error
+			baseImageInfo, err = fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns a single layer", func() {
+			layers := baseImageInfo.LayerInfos
+			Expect(layers).To(HaveLen(1))
+			Expect(layers[0].BlobID).To(Equal(baseImagePath))
+			Expect(layers[0].ChainID).NotTo(BeEmpty())
+			Expect(layers[0].ParentChainID).To(BeEmpty())
+		})
+
+		It("generates the same chain ID while the directory does not change", func() {
+			newBaseImageInfo, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newBaseImageInfo.LayerInfos[0].ChainID).To(Equal(baseImageInfo.LayerInfos[0].ChainID))
+		})
+
+		It("generates another chain ID when a file changes", func() {
+			later := time.Now().Add(time.Hour)
+			Expect(os.Chtimes(filepath.Join(baseImagePath, "etc", "a_file"), later, later)).To(Succeed())
+
+			newBaseImageInfo, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newBaseImageInfo.LayerInfos[0].ChainID).NotTo(Equal(baseImageInfo.LayerInfos[0].ChainID))
+		})
+
+		Context("when the url has the dir scheme", func() {
+			BeforeEach(func() {
+				var err error
+				baseImageURL, err = url.Parse("dir://" + baseImagePath)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("uses the path of the url", func() {
+				Expect(baseImageInfo.LayerInfos[0].BlobID).To(Equal(baseImagePath))
+			})
+		})
+	})
+
+	Context("when the base image is not a directory", func() {
+		BeforeEach(func() {
+			var err error
+			baseImageURL, err = url.Parse(filepath.Join(baseImagePath, "etc", "a_file"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns an error", func() {
+			_, err := fetcher.BaseImageInfo(logger)
+			Expect(err).To(MatchError(ContainSubstring("is not a directory")))
+		})
+	})
+
+	Context("when the base image does not exist", func() {
+		BeforeEach(func() {
+			var err error
+			baseImageURL, err = url.Parse("/not-here")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns an error", func() {
+			_, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+			Expect(err).To(MatchError(ContainSubstring("local image not found in `/not-here`")))
+		})
+	})
+})
+
+type tarEntry struct {
+	header   *tar.Header
+	contents []byte
+}
+
+func streamTar(r *tar.Reader) map[string]tarEntry {
+	entries := map[string]tarEntry{}
+	for {
+		header, err := r.Next()
+		if err != nil {
+			Expect(err).To(Equal(io.EOF))
+			break
+		}
+
+		contents, err := ioutil.ReadAll(r)
+		Expect(err).NotTo(HaveOccurred())
+		entries[header.Name] = tarEntry{header: header, contents: contents}
+	}
+
+	return entries
+}
@@ -0,0 +1,347 @@
+package http_fetcher // import "code.cloudfoundry.org/grootfs/fetcher/http_fetcher"
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/fetcher/compression"
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+const digestFragmentPrefix = "sha256="
+
+const (
+	dialTimeout           = 30 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	responseHeaderTimeout = 30 * time.Second
+)
+
+var sha256Hex = regexp.MustCompile("^[a-f0-9]{64}$")
+
+//go:generate counterfeiter . ETagCache
+
+// ETagCache remembers the last ETag seen for a url
+type ETagCache interface {
+	Get(logger lager.Logger, url string) (string, bool)
+	Set(logger lager.Logger, url, etag string) error
+}
+
+//go:generate counterfeiter . DownloadCounter
+
+// DownloadCounter counts the bytes of the tarballs downloaded
+type DownloadCounter interface {
+	Add(n int)
+}
+
+// HTTPFetcher fetches a tarball served over http(s) as a single layer base
+// image. The chain ID comes from the digest given as a `#sha256=` fragment
+// or, without it, from the ETag of the tarball.
+type HTTPFetcher struct {
+	baseImageURL string
+	fragment     string
+	client       *http.Client
+	etagCache    ETagCache
+	counter      DownloadCounter
+
+	etag     string
+	response *http.Response
+}
+
+func NewHTTPFetcher(baseImageURL *url.URL) *HTTPFetcher {
+	tarballURL := *baseImageURL
+	tarballURL.Fragment = ""
+
+	return &HTTPFetcher{
+		baseImageURL: tarballURL.String(),
+		fragment:     baseImageURL.Fragment,
+		client:       NewHTTPClient(nil),
+	}
+}
+
+// NewHTTPClient returns a client that gives up on servers that do not answer.
+// There is no overall timeout, as tarballs can take long to download.
+func NewHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   dialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   tlsHandshakeTimeout,
+			ResponseHeaderTimeout: responseHeaderTimeout,
+		},
+	}
+}
+
+func (f *HTTPFetcher) WithClient(client *http.Client) *HTTPFetcher {
+	f.client = client
+	return f
+}
+
+// WithETagCache makes the fetcher send conditional requests, so that an
+// unchanged tarball is not downloaded again
+func (f *HTTPFetcher) WithETagCache(etagCache ETagCache) *HTTPFetcher {
+	f.etagCache = etagCache
+	return f
+}
+
+// WithDownloadCounter counts the bytes of the tarball as it is downloaded
+func (f *HTTPFetcher) WithDownloadCounter(counter DownloadCounter) *HTTPFetcher {
+	f.counter = counter
+	return f
+}
+
+func (f *HTTPFetcher) BaseImageInfo(logger lager.Logger) (groot.BaseImageInfo, error) {
+	logger = logger.Session("layers-digest", lager.Data{"baseImageURL": f.baseImageURL})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	chainID, err := f.chainID(logger)
+	if err != nil {
+		return groot.BaseImageInfo{}, err
+	}
+
+	return groot.BaseImageInfo{
+		LayerInfos: []groot.LayerInfo{
+			groot.LayerInfo{
+				BlobID:        f.baseImageURL,
+				ParentChainID: "",
+				ChainID:       chainID,
+			},
+		},
+	}, nil
+}
+
+func (f *HTTPFetcher) StreamBlob(logger lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
+	logger = logger.Session("stream-blob", lager.Data{
+		"baseImageURL": f.baseImageURL,
+		"source":       layerInfo.BlobID,
+	})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	response := f.response
+	f.response = nil
+	if response == nil {
+		var err error
+		response, err = f.get(logger, "")
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return nil, 0, unexpectedStatusError(f.baseImageURL, response)
+		}
+
+		if f.etag != "" && response.Header.Get("ETag") != f.etag {
+			response.Body.Close()
+			return nil, 0, errorspkg.Errorf("`%s` changed while it was being fetched", f.baseImageURL)
+		}
+	}
+
+	var body io.Reader = response.Body
+	if f.counter != nil {
+		body = &countingReader{reader: body, counter: f.counter}
+	}
+
+	var digestHash hash.Hash
+	if digest := f.digest(); digest != "" {
+		digestHash = sha256.New()
+		body = io.TeeReader(body, digestHash)
+	}
+
+	tarCompression, stream := compression.Detect(body)
+	logger.Debug("detected-compression", lager.Data{"compression": tarCompression})
+
+	tarStream, err := compression.Decompress(stream, tarCompression)
+	if err != nil {
+		response.Body.Close()
+		return nil, 0, errorspkg.Wrap(err, "decompressing remote image")
+	}
+
+	return &tarballStream{
+		ReadCloser: tarStream,
+		body:       response.Body,
+		rawReader:  body,
+		digestHash: digestHash,
+		digest:     f.digest(),
+	}, 0, nil
+}
+
+func (f *HTTPFetcher) Close() error {
+	if f.response != nil {
+		f.response.Body.Close()
+		f.response = nil
+	}
+
+	return nil
+}
+
+// chainID returns the digest given in the url fragment or, without it,
+// derives the chain ID from the ETag of the tarball. A conditional request
+// is sent when the ETag has been seen before. When the tarball has changed,
+// its body is kept for StreamBlob.
+func (f *HTTPFetcher) chainID(logger lager.Logger) (string, error) {
+	if f.fragment != "" {
+		digest := f.digest()
+		if digest == "" {
+			return "", errorspkg.Errorf("invalid url fragment `%s`: must be sha256=<hex digest>", f.fragment)
+		}
+
+		return digest, nil
+	}
+
+	cachedETag := ""
+	if f.etagCache != nil {
+		cachedETag, _ = f.etagCache.Get(logger, f.baseImageURL)
+	}
+
+	response, err := f.get(logger, cachedETag)
+	if err != nil {
+		return "", err
+	}
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		response.Body.Close()
+		logger.Debug("not-modified", lager.Data{"etag": cachedETag})
+		f.etag = cachedETag
+
+	case http.StatusOK:
+		f.etag = response.Header.Get("ETag")
+		if f.etag == "" {
+			response.Body.Close()
+			return "", errorspkg.Errorf("`%s` has no ETag, its digest must be given as a #sha256= fragment", f.baseImageURL)
+		}
+		f.response = response
+
+		if f.etagCache != nil && f.etag != cachedETag {
+			if err := f.etagCache.Set(logger, f.baseImageURL, f.etag); err != nil {
+				logger.Error("caching-etag-failed", err)
+			}
+		}
+
+	default:
+		response.Body.Close()
+		return "", unexpectedStatusError(f.baseImageURL, response)
+	}
+
+	shaSum := sha256.Sum256([]byte(fmt.Sprintf("%s-%s", f.baseImageURL, f.etag)))
+	return hex.EncodeToString(shaSum[:]), nil
+}
+
+func (f *HTTPFetcher) get(logger lager.Logger, ifNoneMatch string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, f.baseImageURL, nil)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "creating request")
+	}
+	if ifNoneMatch != "" {
+		request.Header.Set("If-None-Match", ifNoneMatch)
+	}
+
+	logger.Debug("requesting-tarball", lager.Data{"ifNoneMatch": ifNoneMatch})
+	response, err := f.client.Do(request)
+	if err != nil {
+		return nil, errorspkg.Wrapf(err, "fetching `%s`", f.baseImageURL)
+	}
+
+	return response, nil
+}
+
+// digest returns the digest given as url fragment, if it is valid
+func (f *HTTPFetcher) digest() string {
+	if !strings.HasPrefix(f.fragment, digestFragmentPrefix) {
+		return ""
+	}
+
+	digest := strings.TrimPrefix(f.fragment, digestFragmentPrefix)
+	if !sha256Hex.MatchString(digest) {
+		return ""
+	}
+
+	return digest
+}
+
+func unexpectedStatusError(baseImageURL string, response *http.Response) error {
+	return errorspkg.Errorf("fetching `%s`: unexpected status %s", baseImageURL, response.Status)
+}
+
+// countingReader adds the bytes read from the tarball to the counter
+type countingReader struct {
+	reader  io.Reader
+	counter DownloadCounter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.counter.Add(n)
+	}
+	return n, err
+}
+
+// tarballStream verifies the digest of the tarball, when there is one, once
+// the decompressed stream has been read. A mismatch is returned in place of
+// io.EOF.
+type tarballStream struct {
+	io.ReadCloser
+	body       io.Closer
+	rawReader  io.Reader
+	digestHash hash.Hash
+	digest     string
+	verified   bool
+	verifyErr  error
+}
+
+func (s *tarballStream) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if err != io.EOF || s.digestHash == nil {
+		return n, err
+	}
+
+	if !s.verified {
+		s.verified = true
+		s.verifyErr = s.verify()
+	}
+
+	if s.verifyErr != nil {
+		return n, s.verifyErr
+	}
+
+	return n, io.EOF
+}
+
+func (s *tarballStream) verify() error {
+	// the compressed stream might have trailing bytes
+	if _, err := io.Copy(ioutil.Discard, s.rawReader); err != nil {
+		return errorspkg.Wrap(err, "reading remote image")
+	}
+
+	actual := hex.EncodeToString(s.digestHash.Sum(nil))
+	if actual != s.digest {
+		return errorspkg.Errorf("digest mismatch: expected: %s, actual: %s", s.digest, actual)
+	}
+
+	return nil
+}
+
+func (s *tarballStream) Close() error {
+	s.ReadCloser.Close()
+	return s.body.Close()
+}
@@ -0,0 +1,13 @@
+package http_fetcher_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHTTPFetcher(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HTTP Fetcher Suite")
+}
@@ -0,0 +1,399 @@
+package http_fetcher_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	fetcherpkg "code.cloudfoundry.org/grootfs/fetcher/http_fetcher"
+	"code.cloudfoundry.org/grootfs/fetcher/http_fetcher/http_fetcherfakes"
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTP Fetcher", func() {
+	var (
+		fetcher *fetcherpkg.HTTPFetcher
+
+		server       *httptest.Server
+		tarball      []byte
+		etag         string
+		requests     []*http.Request
+		requestsLock sync.Mutex
+
+		logger       lager.Logger
+		baseImageURL *url.URL
+		etagCache    *http_fetcherfakes.FakeETagCache
+	)
+
+	requestCount := func() int {
+		requestsLock.Lock()
+		defer requestsLock.Unlock()
+		return len(requests)
+	}
+
+	BeforeEach(func() {
+		tarball = gzippedTarball("a_file", "hello-world")
+		etag = `"v1"`
+		requests = []*http.Request{}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestsLock.Lock()
+			requests = append(requests, r)
+			requestsLock.Unlock()
+
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+				if r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+
+			w.Write(tarball)
+		}))
+
+		logger = lagertest.NewTestLogger("http-fetcher")
+		etagCache = new(http_fetcherfakes.FakeETagCache)
+
+		var err error
+		baseImageURL, err = url.Parse(server.URL + "/rootfs.tar.gz")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		fetcher = fetcherpkg.NewHTTPFetcher(baseImageURL).WithETagCache(etagCache)
+	})
+
+	AfterEach(func() {
+		Expect(fetcher.Close()).To(Succeed())
+		server.Close()
+	})
+
+	Describe("BaseImageInfo", func() {
+		It("returns a single layer", func() {
+			baseImageInfo, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			layers := baseImageInfo.LayerInfos
+			Expect(layers).To(HaveLen(1))
+			Expect(layers[0].BlobID).To(Equal(baseImageURL.String()))
+			Expect(layers[0].ChainID).NotTo(BeEmpty())
+			Expect(layers[0].ParentChainID).To(BeEmpty())
+		})
+
+		It("caches the etag", func() {
+			_, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(etagCache.SetCallCount()).To(Equal(1))
+			_, cachedURL, cachedETag := etagCache.SetArgsForCall(0)
+			Expect(cachedURL).To(Equal(baseImageURL.String()))
+			Expect(cachedETag).To(Equal(`"v1"`))
+		})
+
+		It("derives another chain ID when the etag changes", func() {
+			baseImageInfo, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			etag = `"v2"`
+			newBaseImageInfo, err := fetcherpkg.NewHTTPFetcher(baseImageURL).BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(newBaseImageInfo.LayerInfos[0].ChainID).NotTo(Equal(baseImageInfo.LayerInfos[0].ChainID))
+		})
+
+		Context("when the etag has been seen before", func() {
+			BeforeEach(func() {
+				etagCache.GetReturns(`"v1"`, true)
+			})
+
+			It("sends a conditional request", func() {
+				_, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(requestCount()).To(Equal(1))
+				Expect(requests[0].Header.Get("If-None-Match")).To(Equal(`"v1"`))
+			})
+
+			It("keeps the chain ID of the unchanged tarball", func() {
+				baseImageInfo, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				uncachedInfo, err := fetcherpkg.NewHTTPFetcher(baseImageURL).BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(baseImageInfo.LayerInfos[0].ChainID).To(Equal(uncachedInfo.LayerInfos[0].ChainID))
+			})
+
+			It("does not cache the etag again", func() {
+				_, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(etagCache.SetCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when caching the etag fails", func() {
+			BeforeEach(func() {
+				etagCache.SetReturns(errors.New("disk full"))
+			})
+
+			It("still returns the chain ID", func() {
+				baseImageInfo, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(baseImageInfo.LayerInfos[0].ChainID).NotTo(BeEmpty())
+			})
+		})
+
+		Context("when the server does not send an etag", func() {
+			BeforeEach(func() {
+				etag = ""
+			})
+
+			It("returns an error", func() {
+				_, err := fetcher.BaseImageInfo(logger)
+				Expect(err).To(MatchError(ContainSubstring("has no ETag")))
+			})
+		})
+
+		Context("when the digest is given as a fragment", func() {
+			var digest string
+
+			BeforeEach(func() {
+				shaSum := sha256.Sum256(tarball)
+				digest = hex.EncodeToString(shaSum[:])
+
+				var err error
+				baseImageURL, err = url.Parse(server.URL + "/rootfs.tar.gz#sha256=" + digest)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("uses it as the chain ID without sending a request", func() {
+				baseImageInfo, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(baseImageInfo.LayerInfos[0].ChainID).To(Equal(digest))
+				Expect(baseImageInfo.LayerInfos[0].BlobID).To(Equal(server.URL + "/rootfs.tar.gz"))
+				Expect(requestCount()).To(BeZero())
+			})
+		})
+
+		Context("when the fragment is invalid", func() {
+			BeforeEach(func() {
+				var err error
+				baseImageURL, err = url.Parse(server.URL + "/rootfs.tar.gz#md5=1234")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error", func() {
+				_, err := fetcher.BaseImageInfo(logger)
+				Expect(err).To(MatchError(ContainSubstring("invalid url fragment `md5=1234`")))
+			})
+		})
+
+		Context("when the server fails", func() {
+			BeforeEach(func() {
+				server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNotFound)
+				})
+			})
+
+			It("returns an error", func() {
+				_, err := fetcher.BaseImageInfo(logger)
+				Expect(err).To(MatchError(ContainSubstring("unexpected status 404 Not Found")))
+			})
+		})
+	})
+
+	Describe("StreamBlob", func() {
+		It("returns the decompressed tarball", func() {
+			_, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			stream, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+			Expect(err).NotTo(HaveOccurred())
+			defer stream.Close()
+
+			Expect(tarFileContents(stream, "a_file")).To(Equal("hello-world"))
+		})
+
+		It("reuses the response of BaseImageInfo", func() {
+			_, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			stream, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stream.Close()).To(Succeed())
+
+			Expect(requestCount()).To(Equal(1))
+		})
+
+		Context("when a download counter is given", func() {
+			var downloadCounter *http_fetcherfakes.FakeDownloadCounter
+
+			JustBeforeEach(func() {
+				downloadCounter = new(http_fetcherfakes.FakeDownloadCounter)
+				fetcher.WithDownloadCounter(downloadCounter)
+			})
+
+			It("counts the bytes of the compressed tarball", func() {
+				_, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				stream, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+				Expect(err).NotTo(HaveOccurred())
+				defer stream.Close()
+				_, err = io.Copy(ioutil.Discard, stream)
+				Expect(err).NotTo(HaveOccurred())
+
+				counted := 0
+				for i := 0; i < downloadCounter.AddCallCount(); i++ {
+					counted += downloadCounter.AddArgsForCall(i)
+				}
+				Expect(counted).To(Equal(len(tarball)))
+			})
+		})
+
+		Context("when the tarball was not modified", func() {
+			BeforeEach(func() {
+				etagCache.GetReturns(`"v1"`, true)
+			})
+
+			It("downloads it when asked to", func() {
+				_, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				stream, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+				Expect(err).NotTo(HaveOccurred())
+				defer stream.Close()
+
+				Expect(tarFileContents(stream, "a_file")).To(Equal("hello-world"))
+				Expect(requests[1].Header.Get("If-None-Match")).To(BeEmpty())
+			})
+
+			Context("and it changes before it is downloaded", func() {
+				It("returns an error", func() {
+					_, err := fetcher.BaseImageInfo(logger)
+					Expect(err).NotTo(HaveOccurred())
+
+					etag = `"v2"`
+					_, _, err = fetcher.StreamBlob(logger, groot.LayerInfo{})
+					Expect(err).To(MatchError(ContainSubstring("changed while it was being fetched")))
+				})
+			})
+		})
+
+		Context("when the digest is given as a fragment", func() {
+			var digest string
+
+			JustBeforeEach(func() {
+				var err error
+				baseImageURL, err = url.Parse(server.URL + "/rootfs.tar.gz#sha256=" + digest)
+				Expect(err).NotTo(HaveOccurred())
+				fetcher = fetcherpkg.NewHTTPFetcher(baseImageURL)
+			})
+
+			Context("and it matches", func() {
+				BeforeEach(func() {
+					shaSum := sha256.Sum256(tarball)
+					digest = hex.EncodeToString(shaSum[:])
+				})
+
+				It("returns the tarball", func() {
+					stream, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+					Expect(err).NotTo(HaveOccurred())
+					defer stream.Close()
+
+					Expect(tarFileContents(stream, "a_file")).To(Equal("hello-world"))
+					_, err = io.Copy(ioutil.Discard, stream)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+
+			Context("and it does not match", func() {
+				BeforeEach(func() {
+					shaSum := sha256.Sum256([]byte("something else"))
+					digest = hex.EncodeToString(shaSum[:])
+				})
+
+				It("fails once the tarball has been read", func() {
+					stream, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+					Expect(err).NotTo(HaveOccurred())
+					defer stream.Close()
+
+					_, err = io.Copy(ioutil.Discard, stream)
+					Expect(err).To(MatchError(ContainSubstring("digest mismatch")))
+				})
+			})
+		})
+	})
+
+	Describe("NewHTTPClient", func() {
+		var tlsServer *httptest.Server
+
+		BeforeEach(func() {
+			tlsServer = httptest.NewTLSServer(server.Config.Handler)
+
+			var err error
+			baseImageURL, err = url.Parse(tlsServer.URL + "/rootfs.tar.gz")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			tlsServer.Close()
+		})
+
+		It("trusts the given CAs", func() {
+			rootCAs := x509.NewCertPool()
+			rootCAs.AddCert(tlsServer.Certificate())
+			fetcher.WithClient(fetcherpkg.NewHTTPClient(&tls.Config{RootCAs: rootCAs}))
+
+			_, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("does not trust the server by default", func() {
+			_, err := fetcher.BaseImageInfo(logger)
+			Expect(err).To(MatchError(ContainSubstring("certificate")))
+		})
+	})
+})
+
+func gzippedTarball(name, contents string) []byte {
+	buffer := bytes.NewBuffer(nil)
+	gzipWriter := gzip.NewWriter(buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	Expect(tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))})).To(Succeed())
+	_, err := tarWriter.Write([]byte(contents))
+	Expect(err).NotTo(HaveOccurred())
+	Expect(tarWriter.Close()).To(Succeed())
+	Expect(gzipWriter.Close()).To(Succeed())
+
+	return buffer.Bytes()
+}
+
+func tarFileContents(stream io.Reader, name string) string {
+	tarReader := tar.NewReader(stream)
+	for {
+		header, err := tarReader.Next()
+		Expect(err).NotTo(HaveOccurred())
+
+		if header.Name == name {
+			contents, err := ioutil.ReadAll(tarReader)
+			Expect(err).NotTo(HaveOccurred())
+			return string(contents)
+		}
+	}
+}
@@ -30,11 +30,32 @@ type Manifest interface {
 type Source interface {
 	Manifest(logger lager.Logger) (types.Image, error)
 	Blob(logger lager.Logger, layerInfo groot.LayerInfo) (string, int64, error)
+	StreamBlob(logger lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error)
+	// Platform returns the platform picked from the image index, if any
+	Platform() specsv1.Platform
+	// ManifestDigest returns the digest of the manifest as served by the
+	// registry, before any schema conversion
+	ManifestDigest() string
 	Close() error
 }
 
+// BlobCache keeps compressed blobs around after their volumes are created,
+// so that they don't need to be downloaded again
+type BlobCache interface {
+	Get(logger lager.Logger, digest string) (io.ReadCloser, int64, bool)
+	Writer(logger lager.Logger, digest string) (BlobCacheWriter, error)
+	// Evict removes a cached blob that turned out to be corrupted
+	Evict(logger lager.Logger, digest string) error
+}
+
+type BlobCacheWriter interface {
+	io.WriteCloser
+	Commit() error
+}
+
 type LayerFetcher struct {
-	source Source
+	source      Source
+	streamBlobs bool
 }
 
 func NewLayerFetcher(source Source) *LayerFetcher {
@@ -43,6 +64,13 @@ func NewLayerFetcher(source Source) *LayerFetcher {
 	}
 }
 
+// WithBlobStreaming makes the fetcher hand out the blob while it is being
+// downloaded, instead of staging it in a temporary file first.
+func (f *LayerFetcher) WithBlobStreaming(streamBlobs bool) *LayerFetcher {
+	f.streamBlobs = streamBlobs
+	return f
+}
+
 func (f *LayerFetcher) BaseImageInfo(logger lager.Logger) (groot.BaseImageInfo, error) {
 	logger = logger.Session("layers-digest")
 	logger.Info("starting")
@@ -61,9 +89,16 @@ func (f *LayerFetcher) BaseImageInfo(logger lager.Logger) (groot.BaseImageInfo,
 		return groot.BaseImageInfo{}, err
 	}
 
+	platform := f.source.Platform()
+	if platform.OS == "" && platform.Architecture == "" {
+		platform = specsv1.Platform{OS: config.OS, Architecture: config.Architecture}
+	}
+
 	return groot.BaseImageInfo{
-		LayerInfos: f.createLayerInfos(logger, manifest, config),
-		Config:     *config,
+		LayerInfos:     f.createLayerInfos(logger, manifest, config),
+		Config:         *config,
+		Platform:       platform,
+		ManifestDigest: f.source.ManifestDigest(),
 	}, nil
 }
 
@@ -72,6 +107,16 @@ func (f *LayerFetcher) StreamBlob(logger lager.Logger, layerInfo groot.LayerInfo
 	logger.Info("starting")
 	defer logger.Info("ending")
 
+	if f.streamBlobs {
+		stream, size, err := f.source.StreamBlob(logger, layerInfo)
+		if err != nil {
+			logger.Error("source-stream-blob-failed", err, lager.Data{"blobId": layerInfo.BlobID, "URL": layerInfo.URLs})
+			return nil, 0, err
+		}
+
+		return stream, size, nil
+	}
+
 	blobFilePath, size, err := f.source.Blob(logger, layerInfo)
 	if err != nil {
 		logger.Error("source-blob-failed", err, lager.Data{"blobId": layerInfo.BlobID, "URL": layerInfo.URLs})
@@ -113,6 +113,17 @@ var _ = Describe("LayerFetcher", func() {
 			}))
 		})
 
+		It("returns the digest of the source manifest", func() {
+			fakeManifest := new(layer_fetcherfakes.FakeManifest)
+			fakeManifest.OCIConfigReturns(&specsv1.Image{}, nil)
+			fakeSource.ManifestReturns(fakeManifest, nil)
+			fakeSource.ManifestDigestReturns("sha256:manifest")
+
+			baseImageInfo, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(baseImageInfo.ManifestDigest).To(Equal("sha256:manifest"))
+		})
+
 		Context("when retrieving the OCI Config fails", func() {
 			BeforeEach(func() {
 				fakeManifest := new(layer_fetcherfakes.FakeManifest)
@@ -147,6 +158,34 @@ var _ = Describe("LayerFetcher", func() {
 
 			Expect(baseImageInfo.Config).To(Equal(expectedConfig))
 		})
+
+		It("returns the platform of the image config", func() {
+			fakeManifest := new(layer_fetcherfakes.FakeManifest)
+			fakeManifest.OCIConfigReturns(&specsv1.Image{OS: "linux", Architecture: "arm64"}, nil)
+			fakeSource.ManifestReturns(fakeManifest, nil)
+
+			baseImageInfo, err := fetcher.BaseImageInfo(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(baseImageInfo.Platform).To(Equal(specsv1.Platform{OS: "linux", Architecture: "arm64"}))
+		})
+
+		Context("when the source picked a platform from the image index", func() {
+			BeforeEach(func() {
+				fakeSource.PlatformReturns(specsv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"})
+			})
+
+			It("returns the picked platform", func() {
+				fakeManifest := new(layer_fetcherfakes.FakeManifest)
+				fakeManifest.OCIConfigReturns(&specsv1.Image{OS: "linux", Architecture: "arm"}, nil)
+				fakeSource.ManifestReturns(fakeManifest, nil)
+
+				baseImageInfo, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(baseImageInfo.Platform).To(Equal(specsv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+			})
+		})
 	})
 
 	Describe("StreamBlob", func() {
@@ -208,6 +247,37 @@ var _ = Describe("LayerFetcher", func() {
 				Expect(err).To(MatchError(ContainSubstring("failed to stream blob")))
 			})
 		})
+
+		Context("when blob streaming is enabled", func() {
+			BeforeEach(func() {
+				fetcher = fetcher.WithBlobStreaming(true)
+				fakeSource.StreamBlobReturns(ioutil.NopCloser(bytes.NewBufferString("hello-world")), 1024, nil)
+			})
+
+			It("streams the blob straight from the source", func() {
+				stream, size, err := fetcher.StreamBlob(logger, layerInfo)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(int64(1024)))
+
+				Expect(fakeSource.BlobCallCount()).To(Equal(0))
+				Expect(fakeSource.StreamBlobCallCount()).To(Equal(1))
+				_, layerInfo := fakeSource.StreamBlobArgsForCall(0)
+				Expect(layerInfo.BlobID).To(Equal("sha256:layer-digest"))
+
+				contents, err := ioutil.ReadAll(stream)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal("hello-world"))
+			})
+
+			Context("when the source fails to stream the blob", func() {
+				It("returns an error", func() {
+					fakeSource.StreamBlobReturns(nil, 0, errors.New("failed to stream blob"))
+
+					_, _, err := fetcher.StreamBlob(logger, layerInfo)
+					Expect(err).To(MatchError(ContainSubstring("failed to stream blob")))
+				})
+			})
+		})
 	})
 	Describe("Close", func() {
 		It("closes the source", func() {
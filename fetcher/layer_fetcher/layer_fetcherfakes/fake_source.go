@@ -2,12 +2,14 @@
 package layer_fetcherfakes
 
 import (
+	"io"
 	"sync"
 
 	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher"
 	"code.cloudfoundry.org/grootfs/groot"
 	"code.cloudfoundry.org/lager"
 	"github.com/containers/image/types"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 type FakeSource struct {
@@ -40,6 +42,40 @@ type FakeSource struct {
 		result2 int64
 		result3 error
 	}
+	StreamBlobStub        func(logger lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error)
+	streamBlobMutex       sync.RWMutex
+	streamBlobArgsForCall []struct {
+		logger    lager.Logger
+		layerInfo groot.LayerInfo
+	}
+	streamBlobReturns struct {
+		result1 io.ReadCloser
+		result2 int64
+		result3 error
+	}
+	streamBlobReturnsOnCall map[int]struct {
+		result1 io.ReadCloser
+		result2 int64
+		result3 error
+	}
+	PlatformStub        func() specsv1.Platform
+	platformMutex       sync.RWMutex
+	platformArgsForCall []struct{}
+	platformReturns     struct {
+		result1 specsv1.Platform
+	}
+	platformReturnsOnCall map[int]struct {
+		result1 specsv1.Platform
+	}
+	ManifestDigestStub        func() string
+	manifestDigestMutex       sync.RWMutex
+	manifestDigestArgsForCall []struct{}
+	manifestDigestReturns     struct {
+		result1 string
+	}
+	manifestDigestReturnsOnCall map[int]struct {
+		result1 string
+	}
 	CloseStub        func() error
 	closeMutex       sync.RWMutex
 	closeArgsForCall []struct{}
@@ -159,6 +195,141 @@ func (fake *FakeSource) BlobReturnsOnCall(i int, result1 string, result2 int64,
 	}{result1, result2, result3}
 }
 
+func (fake *FakeSource) StreamBlob(logger lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
+	fake.streamBlobMutex.Lock()
+	ret, specificReturn := fake.streamBlobReturnsOnCall[len(fake.streamBlobArgsForCall)]
+	fake.streamBlobArgsForCall = append(fake.streamBlobArgsForCall, struct {
+		logger    lager.Logger
+		layerInfo groot.LayerInfo
+	}{logger, layerInfo})
+	fake.recordInvocation("StreamBlob", []interface{}{logger, layerInfo})
+	fake.streamBlobMutex.Unlock()
+	if fake.StreamBlobStub != nil {
+		return fake.StreamBlobStub(logger, layerInfo)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fake.streamBlobReturns.result1, fake.streamBlobReturns.result2, fake.streamBlobReturns.result3
+}
+
+func (fake *FakeSource) StreamBlobCallCount() int {
+	fake.streamBlobMutex.RLock()
+	defer fake.streamBlobMutex.RUnlock()
+	return len(fake.streamBlobArgsForCall)
+}
+
+func (fake *FakeSource) StreamBlobArgsForCall(i int) (lager.Logger, groot.LayerInfo) {
+	fake.streamBlobMutex.RLock()
+	defer fake.streamBlobMutex.RUnlock()
+	return fake.streamBlobArgsForCall[i].logger, fake.streamBlobArgsForCall[i].layerInfo
+}
+
+func (fake *FakeSource) StreamBlobReturns(result1 io.ReadCloser, result2 int64, result3 error) {
+	fake.StreamBlobStub = nil
+	fake.streamBlobReturns = struct {
+		result1 io.ReadCloser
+		result2 int64
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeSource) StreamBlobReturnsOnCall(i int, result1 io.ReadCloser, result2 int64, result3 error) {
+	fake.StreamBlobStub = nil
+	if fake.streamBlobReturnsOnCall == nil {
+		fake.streamBlobReturnsOnCall = make(map[int]struct {
+			result1 io.ReadCloser
+			result2 int64
+			result3 error
+		})
+	}
+	fake.streamBlobReturnsOnCall[i] = struct {
+		result1 io.ReadCloser
+		result2 int64
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeSource) Platform() specsv1.Platform {
+	fake.platformMutex.Lock()
+	ret, specificReturn := fake.platformReturnsOnCall[len(fake.platformArgsForCall)]
+	fake.platformArgsForCall = append(fake.platformArgsForCall, struct{}{})
+	fake.recordInvocation("Platform", []interface{}{})
+	fake.platformMutex.Unlock()
+	if fake.PlatformStub != nil {
+		return fake.PlatformStub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.platformReturns.result1
+}
+
+func (fake *FakeSource) PlatformCallCount() int {
+	fake.platformMutex.RLock()
+	defer fake.platformMutex.RUnlock()
+	return len(fake.platformArgsForCall)
+}
+
+func (fake *FakeSource) PlatformReturns(result1 specsv1.Platform) {
+	fake.PlatformStub = nil
+	fake.platformReturns = struct {
+		result1 specsv1.Platform
+	}{result1}
+}
+
+func (fake *FakeSource) PlatformReturnsOnCall(i int, result1 specsv1.Platform) {
+	fake.PlatformStub = nil
+	if fake.platformReturnsOnCall == nil {
+		fake.platformReturnsOnCall = make(map[int]struct {
+			result1 specsv1.Platform
+		})
+	}
+	fake.platformReturnsOnCall[i] = struct {
+		result1 specsv1.Platform
+	}{result1}
+}
+
+func (fake *FakeSource) ManifestDigest() string {
+	fake.manifestDigestMutex.Lock()
+	ret, specificReturn := fake.manifestDigestReturnsOnCall[len(fake.manifestDigestArgsForCall)]
+	fake.manifestDigestArgsForCall = append(fake.manifestDigestArgsForCall, struct{}{})
+	fake.recordInvocation("ManifestDigest", []interface{}{})
+	fake.manifestDigestMutex.Unlock()
+	if fake.ManifestDigestStub != nil {
+		return fake.ManifestDigestStub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.manifestDigestReturns.result1
+}
+
+func (fake *FakeSource) ManifestDigestCallCount() int {
+	fake.manifestDigestMutex.RLock()
+	defer fake.manifestDigestMutex.RUnlock()
+	return len(fake.manifestDigestArgsForCall)
+}
+
+func (fake *FakeSource) ManifestDigestReturns(result1 string) {
+	fake.ManifestDigestStub = nil
+	fake.manifestDigestReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSource) ManifestDigestReturnsOnCall(i int, result1 string) {
+	fake.ManifestDigestStub = nil
+	if fake.manifestDigestReturnsOnCall == nil {
+		fake.manifestDigestReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.manifestDigestReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
 func (fake *FakeSource) Close() error {
 	fake.closeMutex.Lock()
 	ret, specificReturn := fake.closeReturnsOnCall[len(fake.closeArgsForCall)]
@@ -206,6 +377,12 @@ func (fake *FakeSource) Invocations() map[string][][]interface{} {
 	defer fake.manifestMutex.RUnlock()
 	fake.blobMutex.RLock()
 	defer fake.blobMutex.RUnlock()
+	fake.streamBlobMutex.RLock()
+	defer fake.streamBlobMutex.RUnlock()
+	fake.platformMutex.RLock()
+	defer fake.platformMutex.RUnlock()
+	fake.manifestDigestMutex.RLock()
+	defer fake.manifestDigestMutex.RUnlock()
 	fake.closeMutex.RLock()
 	defer fake.closeMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
@@ -0,0 +1,100 @@
+package source
+
+import (
+	"io"
+
+	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher"
+	"code.cloudfoundry.org/lager"
+)
+
+// blobStream verifies a blob while it is being read. The checksums can only
+// be validated after the last byte has been read, so a verification failure
+// is returned in place of io.EOF.
+type blobStream struct {
+	reader           io.Reader
+	closers          []io.Closer
+	verify           func(uncompressedSize int64) error
+	uncompressedSize int64
+	verified         bool
+	verifyErr        error
+}
+
+func (b *blobStream) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.uncompressedSize += int64(n)
+
+	if err != io.EOF {
+		return n, err
+	}
+
+	if !b.verified {
+		b.verified = true
+		b.verifyErr = b.verify(b.uncompressedSize)
+	}
+
+	if b.verifyErr != nil {
+		return n, b.verifyErr
+	}
+
+	return n, io.EOF
+}
+
+func (b *blobStream) Close() error {
+	var closeErr error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if err := b.closers[i].Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+
+	return closeErr
+}
+
+// cachedBlobReader evicts the cached blob it reads from on the first read
+// error
+type cachedBlobReader struct {
+	io.ReadCloser
+	evict   func()
+	evicted bool
+}
+
+func (r *cachedBlobReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && !r.evicted {
+		r.evicted = true
+		r.evict()
+	}
+
+	return n, err
+}
+
+// bestEffortWriter copies a blob into the cache without failing the
+// download when the cache cannot be written to. A blob that could not be
+// written completely is never committed.
+type bestEffortWriter struct {
+	writer layer_fetcher.BlobCacheWriter
+	err    error
+}
+
+func (w *bestEffortWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.writer.Write(p)
+	}
+
+	return len(p), nil
+}
+
+func (w *bestEffortWriter) Commit(logger lager.Logger) {
+	if w.err != nil {
+		logger.Error("writing-blob-to-cache-failed", w.err)
+		return
+	}
+
+	if err := w.writer.Commit(); err != nil {
+		logger.Error("committing-blob-to-cache-failed", err)
+	}
+}
+
+func (w *bestEffortWriter) Close() error {
+	return w.writer.Close()
+}
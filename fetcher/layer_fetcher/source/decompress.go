@@ -0,0 +1,27 @@
+package source
+
+import (
+	"io"
+
+	"code.cloudfoundry.org/grootfs/fetcher/compression"
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+// decompressedReader returns the uncompressed contents of a layer blob. The
+// compression is picked from the media type and, when the media type does not
+// tell, detected from the first bytes of the blob.
+func decompressedReader(logger lager.Logger, blob io.Reader, mediaType string) (io.ReadCloser, error) {
+	blobCompression := compression.FromMediaType(mediaType)
+	if blobCompression == "" {
+		blobCompression, blob = compression.Detect(blob)
+	}
+	logger.Debug("uncompressing-blob", lager.Data{"mediaType": mediaType, "compression": blobCompression})
+
+	reader, err := compression.Decompress(blob, blobCompression)
+	if err != nil {
+		return nil, errorspkg.Wrapf(err, "expected blob to be of type %s", mediaType)
+	}
+
+	return reader, nil
+}
@@ -0,0 +1,43 @@
+package source
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// DownloadCounter counts the bytes of the blobs fetched from the image
+// source. Blobs found in the blob cache and the part of a download replayed
+// from a partial blob are not counted.
+type DownloadCounter struct {
+	bytes int64
+}
+
+func NewDownloadCounter() *DownloadCounter {
+	return &DownloadCounter{}
+}
+
+// BytesDownloaded returns the bytes counted so far
+func (c *DownloadCounter) BytesDownloaded() int64 {
+	return atomic.LoadInt64(&c.bytes)
+}
+
+// Add counts bytes downloaded outside of the image source, such as http
+// tarballs
+func (c *DownloadCounter) Add(n int) {
+	if c == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&c.bytes, int64(n))
+}
+
+// countingReadCloser adds the bytes read from a blob to the counter
+type countingReadCloser struct {
+	io.ReadCloser
+	counter *DownloadCounter
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(n)
+	return n, err
+}
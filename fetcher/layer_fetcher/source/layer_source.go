@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hash"
@@ -13,14 +14,20 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher"
+	"code.cloudfoundry.org/grootfs/fetcher/oci_archive"
+	"code.cloudfoundry.org/grootfs/fetcher/throttle"
 	"code.cloudfoundry.org/grootfs/groot"
 	"code.cloudfoundry.org/lager"
 	_ "github.com/containers/image/docker"
+	_ "github.com/containers/image/docker/archive"
 	"github.com/containers/image/image"
 	manifestpkg "github.com/containers/image/manifest"
 	_ "github.com/containers/image/oci/layout"
+	"github.com/containers/image/signature"
 	"github.com/containers/image/transports"
 	"github.com/containers/image/types"
 	digestpkg "github.com/opencontainers/go-digest"
@@ -31,14 +38,48 @@ import (
 
 const MAX_DOCKER_RETRIES = 3
 
+// Mirror is a registry that is tried before the upstream registry of a
+// docker image. Mirrors without AuthConfig are accessed anonymously, they
+// never get the upstream credentials.
+type Mirror struct {
+	Host       string
+	Insecure   bool
+	AuthConfig *types.DockerAuthConfig
+	// CertPath is the directory of the TLS certificates of the mirror
+	CertPath string
+}
+
+type imageLocation struct {
+	url           *url.URL
+	systemContext types.SystemContext
+}
+
 type LayerSource struct {
 	skipOCILayerValidation bool
 	systemContext          types.SystemContext
 	baseImageURL           *url.URL
-	// imageSource needs to be a singleton that is initialised on demand in createImageSource. DO NOT use the field directly, use getImageSource instead
-	imageSource              types.ImageSource
+	mirrors                []Mirror
+	platform               specsv1.Platform
+	selectedPlatform       specsv1.Platform
+	manifestDigest         string
+	signaturePolicy        *signature.Policy
+	// imageSources are singletons, one per location, that are initialised on demand in createImageSource. DO NOT use the field directly, use getImageSource instead
+	imageSources map[string]types.ImageSource
+	// registryBlobClients are shared by the blobs of a location, so that they
+	// reuse its connections and token. DO NOT use the field directly, use
+	// getRegistryBlobClient instead
+	registryBlobClients      map[string]*registryBlobClient
 	imageQuota               int64
 	skipImageQuotaValidation bool
+	// mutex guards imageSources, registryBlobClients and imageQuota, as blobs
+	// can be fetched concurrently
+	mutex       *sync.Mutex
+	blobCache   layer_fetcher.BlobCache
+	retryPolicy RetryPolicy
+	// bandwidthLimiters throttle the blobs downloaded by the source
+	bandwidthLimiters []throttle.Limiter
+	progressReporter  groot.ProgressReporter
+	downloadCounter   *DownloadCounter
 }
 
 func NewLayerSource(systemContext types.SystemContext, skipOCILayerValidation, skipImageQuotaValidation bool, diskLimit int64, baseImageURL *url.URL) LayerSource {
@@ -48,38 +89,123 @@ func NewLayerSource(systemContext types.SystemContext, skipOCILayerValidation, s
 		baseImageURL:             baseImageURL,
 		imageQuota:               diskLimit,
 		skipImageQuotaValidation: skipImageQuotaValidation,
+		imageSources:             map[string]types.ImageSource{},
+		registryBlobClients:      map[string]*registryBlobClient{},
+		mutex:                    &sync.Mutex{},
+		retryPolicy:              DefaultRetryPolicy,
 	}
 }
 
+// WithBandwidthLimiters makes the blobs download no faster than all the
+// limiters allow. Blobs found in the blob cache are not throttled.
+func (s *LayerSource) WithBandwidthLimiters(limiters ...throttle.Limiter) *LayerSource {
+	s.bandwidthLimiters = limiters
+	return s
+}
+
+// WithProgressReporter reports the bytes read from each blob
+func (s *LayerSource) WithProgressReporter(progressReporter groot.ProgressReporter) *LayerSource {
+	s.progressReporter = progressReporter
+	return s
+}
+
+// WithDownloadCounter counts the bytes of the blobs fetched from the image
+// source
+func (s *LayerSource) WithDownloadCounter(downloadCounter *DownloadCounter) *LayerSource {
+	s.downloadCounter = downloadCounter
+	return s
+}
+
+// WithRetryPolicy changes how registry operations that fail with retryable
+// errors are attempted again
+func (s *LayerSource) WithRetryPolicy(retryPolicy RetryPolicy) *LayerSource {
+	s.retryPolicy = retryPolicy
+	return s
+}
+
+// WithPlatform makes the source pick the image matching the platform from
+// manifest lists and OCI indexes, instead of leaving the choice to
+// containers/image. An empty variant matches any variant.
+func (s *LayerSource) WithPlatform(platform specsv1.Platform) *LayerSource {
+	s.platform = platform
+	return s
+}
+
+// Platform returns the platform of the image picked from the image index, if
+// the image has one and a platform was requested
+func (s *LayerSource) Platform() specsv1.Platform {
+	return s.selectedPlatform
+}
+
+// ManifestDigest returns the digest of the manifest fetched by Manifest, as
+// served by the registry. Schema 1 manifests are converted after it is taken.
+func (s *LayerSource) ManifestDigest() string {
+	return s.manifestDigest
+}
+
+// WithSignaturePolicy makes the source refuse images that the policy does not
+// allow, before any of their layers are downloaded
+func (s *LayerSource) WithSignaturePolicy(policy *signature.Policy) *LayerSource {
+	s.signaturePolicy = policy
+	return s
+}
+
+// WithMirrors makes the source try each mirror, in order, for manifests and
+// blobs before falling back to the upstream registry. Mirrors only apply to
+// docker images.
+func (s *LayerSource) WithMirrors(mirrors []Mirror) *LayerSource {
+	s.mirrors = mirrors
+	return s
+}
+
+// WithBlobCache makes the source look for blobs in the cache before
+// downloading them, and add the blobs it downloads to it.
+func (s *LayerSource) WithBlobCache(blobCache layer_fetcher.BlobCache) *LayerSource {
+	s.blobCache = blobCache
+	return s
+}
+
 func (s *LayerSource) Manifest(logger lager.Logger) (types.Image, error) {
 	logger = logger.Session("fetching-image-manifest", lager.Data{"baseImageURL": s.baseImageURL})
 	logger.Info("starting")
 	defer logger.Info("ending")
 
-	img, err := s.getImageWithRetries(logger)
+	img, imgSrc, err := s.getImageWithRetries(logger)
 	if err != nil {
 		logger.Error("fetching-image-reference-failed", err)
 		return nil, errorspkg.Wrap(err, "fetching image reference")
 	}
 
-	img, err = s.convertImage(logger, img)
+	manifestBytes, _, err := img.Manifest(context.TODO())
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "reading the image manifest")
+	}
+
+	manifestDigest, err := manifestpkg.Digest(manifestBytes)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "computing the manifest digest")
+	}
+	s.manifestDigest = manifestDigest.String()
+
+	img, err = s.convertImage(logger, img, imgSrc)
 	if err != nil {
 		logger.Error("converting-image-failed", err)
 		return nil, err
 	}
 
-	for i := 0; i < MAX_DOCKER_RETRIES; i++ {
-		logger.Debug("attempt-get-config", lager.Data{"attempt": i + 1})
-		_, e := img.ConfigBlob(context.TODO())
-		if e == nil {
+	for attempt := 1; ; attempt++ {
+		logger.Debug("attempt-get-config", lager.Data{"attempt": attempt})
+		_, err = img.ConfigBlob(context.TODO())
+		if err == nil {
 			return img, nil
 		}
 
-		logger.Error("fetching-image-config-failed", e, lager.Data{"attempt": i + 1})
-		err = e
+		logger.Error("fetching-image-config-failed", err, lager.Data{"attempt": attempt})
+		if !s.retryPolicy.ShouldRetry(attempt, err) {
+			return nil, errorspkg.Wrap(err, "fetching image configuration")
+		}
+		s.waitBeforeRetry(logger, attempt)
 	}
-
-	return nil, errorspkg.Wrap(err, "fetching image configuration")
 }
 
 func (s *LayerSource) Blob(logger lager.Logger, layerInfo groot.LayerInfo) (string, int64, error) {
@@ -87,90 +213,218 @@ func (s *LayerSource) Blob(logger lager.Logger, layerInfo groot.LayerInfo) (stri
 	logger = logger.Session("streaming-blob", lager.Data{
 		"baseImageURL":             s.baseImageURL,
 		"digest":                   layerInfo.BlobID,
-		"imageQuota":               s.imageQuota,
+		"imageQuota":               s.quotaLeft(),
 		"skipImageQuotaValidation": s.skipImageQuotaValidation,
 	})
 	logger.Info("starting")
 	defer logger.Info("ending")
 
-	imgSrc, err := s.getImageSource(logger)
+	stream, size, err := s.openBlobStream(logger, layerInfo, true)
 	if err != nil {
 		return "", 0, err
 	}
+	defer stream.Close()
 
+	blobTempFile, err := ioutil.TempFile("", fmt.Sprintf("blob-%s", layerInfo.BlobID))
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer func() {
+		blobTempFile.Close()
+
+		if err != nil {
+			os.Remove(blobTempFile.Name())
+		}
+	}()
+
+	if _, err = io.Copy(blobTempFile, stream); err != nil {
+		logger.Error("writing-blob-to-file", err)
+		if stream.verifyErr != nil {
+			return "", 0, err
+		}
+		return "", 0, errorspkg.Wrap(err, "writing blob to tempfile")
+	}
+
+	return blobTempFile.Name(), size, nil
+}
+
+// StreamBlob returns the uncompressed blob without staging it on disk. The
+// checksums and the quota are verified as the stream is read, and the
+// returned reader fails in place of io.EOF if any of them does not match.
+func (s *LayerSource) StreamBlob(logger lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
+	logrus.SetOutput(os.Stderr)
+	logger = logger.Session("streaming-blob-inline", lager.Data{
+		"baseImageURL":             s.baseImageURL,
+		"digest":                   layerInfo.BlobID,
+		"imageQuota":               s.quotaLeft(),
+		"skipImageQuotaValidation": s.skipImageQuotaValidation,
+	})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	stream, size, err := s.openBlobStream(logger, layerInfo, false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return stream, size, nil
+}
+
+// openBlobStream keeps a partial file of the registry blobs it downloads when
+// keepPartial is set, so that they can be resumed by a later create
+func (s *LayerSource) openBlobStream(logger lager.Logger, layerInfo groot.LayerInfo, keepPartial bool) (*blobStream, int64, error) {
 	blobInfo := types.BlobInfo{
 		Digest: digestpkg.Digest(layerInfo.BlobID),
 		URLs:   layerInfo.URLs,
 	}
 
-	blob, size, err := s.getBlobWithRetries(logger, imgSrc, blobInfo)
-	if err != nil {
-		return "", 0, err
+	blob, size, cached := s.getCachedBlob(logger, blobInfo)
+	if !cached {
+		var err error
+		blob, size, err = s.getBlobFromLocations(logger, blobInfo, keepPartial)
+		if err != nil {
+			return nil, 0, err
+		}
 	}
 	logger.Debug("got-blob-stream", lager.Data{"digest": layerInfo.BlobID, "size": size, "mediaType": layerInfo.MediaType})
 
-	if err = s.validateLayerSize(layerInfo, size); err != nil {
-		return "", 0, err
+	if err := s.validateLayerSize(layerInfo, size); err != nil {
+		blob.Close()
+		return nil, 0, err
 	}
 
-	blobTempFile, err := ioutil.TempFile("", fmt.Sprintf("blob-%s", layerInfo.BlobID))
-	if err != nil {
-		return "", 0, err
-	}
+	stream := &blobStream{closers: []io.Closer{blob}}
 
 	blobIDHash := sha256.New()
-	digestReader := ioutil.NopCloser(io.TeeReader(blob, blobIDHash))
-	if layerInfo.MediaType == "" || strings.Contains(layerInfo.MediaType, "gzip") {
-		logger.Debug("uncompressing-blob")
+	var blobWriter io.Writer = blobIDHash
+	var cacheWriter *bestEffortWriter
+	if !cached {
+		cacheWriter = s.getBlobCacheWriter(logger, blobInfo)
+	}
+	if cacheWriter != nil {
+		blobWriter = io.MultiWriter(blobIDHash, cacheWriter)
+		stream.closers = append(stream.closers, cacheWriter)
+	}
 
-		digestReader, err = gzip.NewReader(digestReader)
-		if err != nil {
-			return "", 0, errorspkg.Wrapf(err, "expected blob to be of type %s", layerInfo.MediaType)
+	var blobReader io.Reader = blob
+	if !cached && len(s.bandwidthLimiters) > 0 {
+		blobReader = throttle.NewReader(blob, s.bandwidthLimiters...)
+	}
+
+	if s.progressReporter != nil {
+		total := size
+		if total < 0 {
+			total = layerInfo.Size
 		}
-		defer digestReader.Close()
+		blobReader = newProgressReader(logger, blobReader, s.progressReporter, layerInfo.BlobID, total)
+	}
+
+	evictCachedBlob := func() {
+		if err := s.blobCache.Evict(logger, layerInfo.BlobID); err != nil {
+			logger.Error("evicting-cached-blob-failed", err)
+		}
+	}
+
+	digestReader, err := decompressedReader(logger, io.TeeReader(blobReader, blobWriter), layerInfo.MediaType)
+	if err != nil {
+		if cached {
+			evictCachedBlob()
+		}
+		stream.Close()
+		return nil, 0, err
+	}
+	stream.closers = append(stream.closers, digestReader)
 
+	// the cached blobs are only verified as they are streamed, so a blob that
+	// cannot be read back is evicted for the next create to fetch it again
+	if cached {
+		digestReader = &cachedBlobReader{ReadCloser: digestReader, evict: evictCachedBlob}
 	}
 
 	if s.shouldEnforceImageQuotaValidation() {
-		digestReader = layer_fetcher.NewQuotaedReader(digestReader, s.imageQuota, "uncompressed layer size exceeds quota")
+		digestReader = layer_fetcher.NewQuotaedReader(digestReader, s.quotaLeft(), "uncompressed layer size exceeds quota")
 	}
 
-	defer func() {
-		blob.Close()
-		blobTempFile.Close()
+	diffIDHash := sha256.New()
+	stream.reader = io.TeeReader(digestReader, diffIDHash)
+	stream.verify = func(uncompressedSize int64) error {
+		blobIDHex := strings.Split(layerInfo.BlobID, ":")[1]
+		if cached && hex.EncodeToString(blobIDHash.Sum(nil)) != blobIDHex {
+			evictCachedBlob()
+		}
 
-		if err != nil {
-			os.Remove(blobTempFile.Name())
+		if err := s.checkCheckSum(logger, blobIDHash, blobIDHex); err != nil {
+			return errorspkg.Wrap(err, "layerID digest mismatch")
 		}
-	}()
 
-	diffIDHash := sha256.New()
-	digestReader = ioutil.NopCloser(io.TeeReader(digestReader, diffIDHash))
+		if err := s.checkCheckSum(logger, diffIDHash, layerInfo.DiffID); err != nil {
+			return errorspkg.Wrap(err, "diffID digest mismatch")
+		}
 
-	uncompressedSize, err := io.Copy(blobTempFile, digestReader)
-	if err != nil {
-		logger.Error("writing-blob-to-file", err)
-		return "", 0, errorspkg.Wrap(err, "writing blob to tempfile")
+		if err := s.consumeQuota(uncompressedSize); err != nil {
+			return err
+		}
+
+		// the checksums are not validated when skipOCILayerValidation is set,
+		// but only verified blobs can make it into the cache
+		if cacheWriter != nil && hex.EncodeToString(blobIDHash.Sum(nil)) == blobIDHex {
+			cacheWriter.Commit(logger)
+		}
+
+		return nil
 	}
 
-	blobIDHex := strings.Split(layerInfo.BlobID, ":")[1]
-	if err = s.checkCheckSum(logger, blobIDHash, blobIDHex); err != nil {
-		return "", 0, errorspkg.Wrap(err, "layerID digest mismatch")
+	return stream, size, nil
+}
+
+func (s *LayerSource) getCachedBlob(logger lager.Logger, blobInfo types.BlobInfo) (io.ReadCloser, int64, bool) {
+	if s.blobCache == nil {
+		return nil, 0, false
 	}
 
-	if err = s.checkCheckSum(logger, diffIDHash, layerInfo.DiffID); err != nil {
-		return "", 0, errorspkg.Wrap(err, "diffID digest mismatch")
+	return s.blobCache.Get(logger, blobInfo.Digest.String())
+}
+
+func (s *LayerSource) getBlobCacheWriter(logger lager.Logger, blobInfo types.BlobInfo) *bestEffortWriter {
+	if s.blobCache == nil {
+		return nil
 	}
 
-	s.imageQuota -= uncompressedSize
+	writer, err := s.blobCache.Writer(logger, blobInfo.Digest.String())
+	if err != nil {
+		logger.Error("opening-blob-cache-writer-failed", err)
+		return nil
+	}
 
-	return blobTempFile.Name(), size, nil
+	return &bestEffortWriter{writer: writer}
 }
 
 func (s *LayerSource) shouldEnforceImageQuotaValidation() bool {
 	return !s.skipImageQuotaValidation
 }
 
+func (s *LayerSource) quotaLeft() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.imageQuota
+}
+
+// consumeQuota is needed on top of the QuotaedReader because other blobs can
+// be consuming the same quota concurrently.
+func (s *LayerSource) consumeQuota(uncompressedSize int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.imageQuota -= uncompressedSize
+	if s.shouldEnforceImageQuotaValidation() && s.imageQuota < 0 {
+		return errors.New("uncompressed layer size exceeds quota")
+	}
+
+	return nil
+}
+
 func (s *LayerSource) validateLayerSize(layerInfo groot.LayerInfo, size int64) error {
 	if s.skipOCILayerValidation || isV1Image(layerInfo) || layerInfo.Size == size {
 		return nil
@@ -184,30 +438,132 @@ func isV1Image(layerInfo groot.LayerInfo) bool {
 }
 
 func (s *LayerSource) Close() error {
-	if s.imageSource != nil {
-		return s.imageSource.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var closeErr error
+	for _, imgSrc := range s.imageSources {
+		if err := imgSrc.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
 	}
-	return nil
+	return closeErr
 }
 
-func (s *LayerSource) getBlobWithRetries(logger lager.Logger, imgSrc types.ImageSource, blobInfo types.BlobInfo) (io.ReadCloser, int64, error) {
+// getBlobFromLocations tries to fetch the blob from each mirror before
+// falling back to the upstream registry. The blob is still verified against
+// the digest in the manifest, wherever it comes from.
+func (s *LayerSource) getBlobFromLocations(logger lager.Logger, blobInfo types.BlobInfo, keepPartial bool) (io.ReadCloser, int64, error) {
 	var err error
-	for i := 0; i < MAX_DOCKER_RETRIES; i++ {
-		logger.Debug(fmt.Sprintf("attempt-get-blob-%d", i+1))
-		blob, size, e := imgSrc.GetBlob(context.TODO(), blobInfo)
-		if e == nil {
+	for _, location := range s.locations() {
+		var imgSrc types.ImageSource
+		imgSrc, err = s.getImageSource(logger, location)
+		if err == nil {
+			var blob io.ReadCloser
+			var size int64
+			blob, size, err = s.getResumableBlob(logger, location, imgSrc, blobInfo, keepPartial)
+			if err == nil {
+				return blob, size, nil
+			}
+		}
+
+		if location.url != s.baseImageURL {
+			logger.Error("fetching-blob-from-mirror-failed", err, lager.Data{"mirror": location.url.Host})
+		}
+	}
+
+	return nil, 0, err
+}
+
+func (s *LayerSource) getBlobWithRetries(logger lager.Logger, imgSrc types.ImageSource, blobInfo types.BlobInfo) (io.ReadCloser, int64, error) {
+	for attempt := 1; ; attempt++ {
+		logger.Debug(fmt.Sprintf("attempt-get-blob-%d", attempt))
+		blob, size, err := imgSrc.GetBlob(context.TODO(), blobInfo)
+		if err == nil {
 			logger.Debug("attempt-get-blob-success")
 			return blob, size, nil
 		}
-		err = e
+		err = blobStatusError(err)
+
 		logger.Error("attempt-get-blob-failed", err)
+		if !s.retryPolicy.ShouldRetry(attempt, err) {
+			return nil, 0, err
+		}
+		s.waitBeforeRetry(logger, attempt)
 	}
+}
 
-	return nil, 0, err
+func (s *LayerSource) waitBeforeRetry(logger lager.Logger, attempt int) {
+	delay := s.retryPolicy.Delay(attempt)
+	logger.Debug("waiting-before-retry", lager.Data{"attempt": attempt, "delay": delay.String()})
+	time.Sleep(delay)
+}
+
+// getResumableBlob downloads registry blobs so that the download can be
+// resumed where it stopped, with a Range request. The blob itself is fetched
+// by containers/image, unless a partial blob is being resumed. Local images
+// and foreign layers are fetched as usual. The blob is only written to a
+// partial file when keepPartial is set and no one else is downloading it
+// already.
+func (s *LayerSource) getResumableBlob(logger lager.Logger, location imageLocation, imgSrc types.ImageSource, blobInfo types.BlobInfo, keepPartial bool) (io.ReadCloser, int64, error) {
+	if location.url.Scheme != "docker" || len(blobInfo.URLs) > 0 {
+		blob, size, err := s.getBlobWithRetries(logger, imgSrc, blobInfo)
+		if err != nil || s.downloadCounter == nil {
+			return blob, size, err
+		}
+		return &countingReadCloser{ReadCloser: blob, counter: s.downloadCounter}, size, nil
+	}
+
+	client, err := s.getRegistryBlobClient(logger, location)
+	if err != nil {
+		return nil, 0, err
+	}
+	blob := &resumableBlob{
+		logger:  logger.Session("resumable-blob"),
+		digest:  blobInfo.Digest,
+		client:  client,
+		size:    -1,
+		retry:   s.retryPolicy,
+		counter: s.downloadCounter,
+	}
+
+	var partialSize int64
+	if keepPartial {
+		blob.file, partialSize, err = openPartialBlob(blobInfo.Digest)
+		if err != nil {
+			logger.Info("partial-blob-unavailable", lager.Data{"error": err.Error()})
+		}
+	}
+
+	if partialSize > 0 {
+		logger.Debug("resuming-partial-blob", lager.Data{"partialSize": partialSize})
+		blob.offset = partialSize
+		if err = blob.resume(); err == nil {
+			blob.replay = io.NewSectionReader(blob.file, 0, partialSize)
+			return blob, blob.size, nil
+		}
+		logger.Error("resuming-partial-blob-failed", err)
+
+		blob.offset = 0
+		if err := blob.file.Truncate(0); err != nil {
+			blob.Close()
+			return nil, 0, errorspkg.Wrap(err, "discarding partial blob")
+		}
+	}
+
+	body, size, err := s.getBlobWithRetries(logger, imgSrc, blobInfo)
+	if err != nil {
+		blob.Close()
+		return nil, 0, err
+	}
+	blob.body = body
+	blob.size = size
+
+	return blob, size, nil
 }
 
 func (s *LayerSource) checkCheckSum(logger lager.Logger, hash hash.Hash, digest string) error {
-	if s.skipOCILayerValidation && s.baseImageURL.Scheme == "oci" {
+	if s.skipOCILayerValidation && IsOCI(s.baseImageURL.Scheme) {
 		return nil
 	}
 
@@ -223,15 +579,24 @@ func (s *LayerSource) checkCheckSum(logger lager.Logger, hash hash.Hash, digest
 	return nil
 }
 
-func (s *LayerSource) reference(logger lager.Logger) (types.ImageReference, error) {
+func (s *LayerSource) reference(logger lager.Logger, imageURL *url.URL) (types.ImageReference, error) {
 	refString := "/"
-	if s.baseImageURL.Host != "" {
-		refString += "/" + s.baseImageURL.Host
+	if imageURL.Host != "" {
+		refString += "/" + imageURL.Host
+	}
+	refString += imageURL.Path
+
+	// archive references are a plain path, optionally followed by the name
+	// of the image in the archive
+	if imageURL.Scheme == "docker-archive" || imageURL.Scheme == oci_archive.Transport.Name() {
+		refString = imageURL.Path
 	}
-	refString += s.baseImageURL.Path
 
 	logger.Debug("parsing-reference", lager.Data{"refString": refString})
-	transport := transports.Get(s.baseImageURL.Scheme)
+	transport := transports.Get(imageURL.Scheme)
+	if imageURL.Scheme == oci_archive.Transport.Name() {
+		transport = oci_archive.Transport
+	}
 	ref, err := transport.ParseReference(refString)
 	if err != nil {
 		return nil, errorspkg.Wrap(err, "parsing url failed")
@@ -240,45 +605,233 @@ func (s *LayerSource) reference(logger lager.Logger) (types.ImageReference, erro
 	return ref, nil
 }
 
-func (s *LayerSource) getImageWithRetries(logger lager.Logger) (types.Image, error) {
-	var imgErr error
-	var img types.Image
-	for i := 0; i < MAX_DOCKER_RETRIES; i++ {
-		logger.Debug(fmt.Sprintf("attempt-get-image-%d", i+1))
+// IsOCI returns whether the scheme refers to an OCI image layout, either as a
+// directory or as an archive
+func IsOCI(scheme string) bool {
+	return scheme == "oci" || scheme == oci_archive.Transport.Name()
+}
+
+// locations returns where the image can be fetched from: its mirrors, in
+// order, followed by the upstream registry
+func (s *LayerSource) locations() []imageLocation {
+	locations := []imageLocation{}
+	if s.baseImageURL.Scheme == "docker" {
+		for _, mirror := range s.mirrors {
+			mirrorURL := *s.baseImageURL
+			mirrorURL.Host = mirror.Host
+			mirrorURL.Path = mirrorPath(s.baseImageURL)
+
+			systemContext := s.systemContext
+			systemContext.DockerInsecureSkipTLSVerify = mirror.Insecure
+			systemContext.DockerAuthConfig = mirror.AuthConfig
+			systemContext.DockerCertPath = mirror.CertPath
+
+			locations = append(locations, imageLocation{url: &mirrorURL, systemContext: systemContext})
+		}
+	}
 
-		imageSource, err := s.getImageSource(logger)
-		if err == nil {
-			img, err = image.FromUnparsedImage(context.TODO(), &s.systemContext, image.UnparsedInstance(imageSource, nil))
+	return append(locations, imageLocation{url: s.baseImageURL, systemContext: s.systemContext})
+}
+
+// mirrorPath returns the repository path of the image on a mirror. Official
+// Docker Hub images live in the implicit `library` namespace, which a mirror
+// cannot guess from the image path alone.
+func mirrorPath(baseImageURL *url.URL) string {
+	if !IsDockerHub(baseImageURL.Host) || strings.Contains(strings.TrimPrefix(baseImageURL.Path, "/"), "/") {
+		return baseImageURL.Path
+	}
+
+	return "/library" + baseImageURL.Path
+}
+
+// IsDockerHub returns whether the registry host refers to Docker Hub. Docker
+// images without a host are fetched from Docker Hub.
+func IsDockerHub(host string) bool {
+	switch host {
+	case "", dockerHubDomain, dockerHubRegistry, "index.docker.io":
+		return true
+	}
+	return false
+}
+
+func (s *LayerSource) getImageWithRetries(logger lager.Logger) (types.Image, types.ImageSource, error) {
+	for attempt := 1; ; attempt++ {
+		logger.Debug(fmt.Sprintf("attempt-get-image-%d", attempt))
+
+		var imgErr error
+		retryable := false
+		for _, location := range s.locations() {
+			img, imageSource, err := s.getImage(logger, location)
 			if err == nil {
-				logger.Debug("attempt-get-image-success")
-				return img, nil
+				logger.Debug("attempt-get-image-success", lager.Data{"registry": location.url.Host})
+				return img, imageSource, nil
+			}
+
+			// retrying will not change the verdict of the policy
+			if _, ok := err.(*ImageRejectedError); ok {
+				return nil, nil, err
 			}
+
+			if location.url != s.baseImageURL {
+				logger.Error("fetching-image-from-mirror-failed", err, lager.Data{"mirror": location.url.Host})
+			}
+			imgErr = err
+			retryable = retryable || IsRetryable(err)
+		}
+
+		if !retryable || attempt >= s.retryPolicy.MaxAttempts {
+			return nil, nil, errorspkg.Wrap(imgErr, "creating image")
 		}
-		imgErr = err
+		s.waitBeforeRetry(logger, attempt)
+	}
+}
+
+func (s *LayerSource) getImage(logger lager.Logger, location imageLocation) (types.Image, types.ImageSource, error) {
+	imageSource, err := s.getImageSource(logger, location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instanceDigest, err := s.selectInstance(logger, imageSource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.checkSignaturePolicy(logger, location, imageSource, instanceDigest); err != nil {
+		return nil, nil, err
 	}
 
-	return nil, errorspkg.Wrap(imgErr, "creating image")
+	unparsedImage := image.UnparsedInstance(imageSource, instanceDigest)
+
+	img, err := image.FromUnparsedImage(context.TODO(), &location.systemContext, unparsedImage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if instanceDigest == nil {
+		if err := s.checkImagePlatform(img); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return img, imageSource, nil
 }
 
-func (s *LayerSource) getImageSource(logger lager.Logger) (types.ImageSource, error) {
-	if s.imageSource == nil {
-		var err error
-		s.imageSource, err = s.createImageSource(logger)
+// checkImagePlatform fails when a platform was requested and the image, which
+// is not part of an image index, was built for another one
+func (s *LayerSource) checkImagePlatform(img types.Image) error {
+	if s.platform.OS == "" && s.platform.Architecture == "" {
+		return nil
+	}
+
+	config, err := img.OCIConfig(context.TODO())
+	if err != nil {
+		return errorspkg.Wrap(err, "fetching image configuration")
+	}
+
+	if config.OS != s.platform.OS || config.Architecture != s.platform.Architecture {
+		return errorspkg.Errorf("image platform `%s/%s` does not match the requested platform `%s`", config.OS, config.Architecture, platformString(s.platform))
+	}
+
+	return nil
+}
+
+// selectInstance returns the digest of the image matching the requested
+// platform when the manifest is a manifest list or an OCI index. It returns
+// nil when no platform was requested or when the manifest is not an index.
+func (s *LayerSource) selectInstance(logger lager.Logger, imageSource types.ImageSource) (*digestpkg.Digest, error) {
+	if s.platform.OS == "" && s.platform.Architecture == "" {
+		return nil, nil
+	}
+
+	rawManifest, mimeType, err := imageSource.GetManifest(context.TODO(), nil)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "fetching manifest")
+	}
+	if mimeType == "" {
+		mimeType = manifestpkg.GuessMIMEType(rawManifest)
+	}
+	if mimeType != manifestpkg.DockerV2ListMediaType && mimeType != specsv1.MediaTypeImageIndex {
+		return nil, nil
+	}
+
+	// docker manifest lists and OCI indexes share the same layout
+	var index specsv1.Index
+	if err := json.Unmarshal(rawManifest, &index); err != nil {
+		return nil, errorspkg.Wrap(err, "parsing image index")
+	}
+
+	for _, instance := range index.Manifests {
+		if instance.Platform == nil || !matchesPlatform(*instance.Platform, s.platform) {
+			continue
+		}
+
+		logger.Debug("selected-platform", lager.Data{"platform": *instance.Platform, "digest": instance.Digest})
+		s.selectedPlatform = *instance.Platform
+		return &instance.Digest, nil
+	}
+
+	return nil, errorspkg.Errorf("no image found for platform `%s` in the image index", platformString(s.platform))
+}
+
+func matchesPlatform(platform, wanted specsv1.Platform) bool {
+	return platform.OS == wanted.OS &&
+		platform.Architecture == wanted.Architecture &&
+		(wanted.Variant == "" || platform.Variant == wanted.Variant)
+}
+
+func platformString(platform specsv1.Platform) string {
+	platformString := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		platformString += "/" + platform.Variant
+	}
+	return platformString
+}
+
+func (s *LayerSource) getImageSource(logger lager.Logger, location imageLocation) (types.ImageSource, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := location.url.String()
+	if _, ok := s.imageSources[key]; !ok {
+		imageSource, err := s.createImageSource(logger, location)
 		if err != nil {
 			return nil, err
 		}
+		s.imageSources[key] = imageSource
 	}
 
-	return s.imageSource, nil
+	return s.imageSources[key], nil
 }
 
-func (s *LayerSource) createImageSource(logger lager.Logger) (types.ImageSource, error) {
-	ref, err := s.reference(logger)
+func (s *LayerSource) getRegistryBlobClient(logger lager.Logger, location imageLocation) (*registryBlobClient, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := location.url.String()
+	if _, ok := s.registryBlobClients[key]; !ok {
+		ref, err := s.reference(logger, location.url)
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := newRegistryBlobClient(ref.DockerReference(), location.systemContext)
+		if err != nil {
+			return nil, err
+		}
+		s.registryBlobClients[key] = client
+	}
+
+	return s.registryBlobClients[key], nil
+}
+
+func (s *LayerSource) createImageSource(logger lager.Logger, location imageLocation) (types.ImageSource, error) {
+	ref, err := s.reference(logger, location.url)
 	if err != nil {
 		return nil, err
 	}
 
-	imgSrc, err := ref.NewImageSource(context.TODO(), &s.systemContext)
+	imgSrc, err := ref.NewImageSource(context.TODO(), &location.systemContext)
 	if err != nil {
 		return nil, errorspkg.Wrap(err, "creating image source")
 	}
@@ -286,7 +839,7 @@ func (s *LayerSource) createImageSource(logger lager.Logger) (types.ImageSource,
 	return imgSrc, nil
 }
 
-func (s *LayerSource) convertImage(logger lager.Logger, originalImage types.Image) (types.Image, error) {
+func (s *LayerSource) convertImage(logger lager.Logger, originalImage types.Image, imgSrc types.ImageSource) (types.Image, error) {
 	_, mimetype, err := originalImage.Manifest(context.TODO())
 	if err != nil {
 		return nil, err
@@ -300,11 +853,6 @@ func (s *LayerSource) convertImage(logger lager.Logger, originalImage types.Imag
 	logger.Info("starting")
 	defer logger.Info("ending")
 
-	imgSrc, err := s.getImageSource(logger)
-	if err != nil {
-		return nil, err
-	}
-
 	diffIDs := []digestpkg.Digest{}
 	for _, layer := range originalImage.LayerInfos() {
 		diffID, err := s.v1DiffID(logger, layer, imgSrc)
@@ -0,0 +1,137 @@
+package source_test
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/containers/image/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Layer source: Docker archive", func() {
+	var (
+		layerSource source.LayerSource
+
+		logger       *lagertest.TestLogger
+		baseImageURL *url.URL
+
+		configBlob  string
+		layerInfos  []groot.LayerInfo
+		archivePath string
+	)
+
+	BeforeEach(func() {
+		configBlob = "sha256:8bd9ccd81425d90b291c3bb57301e6b12f0a7d135a80c767972f5ee173e78334"
+		layerInfos = []groot.LayerInfo{
+			{
+				BlobID: "sha256:446cbfc977a9dd9ab424607a1737ff83e20eb3c14bfe637a81fdd28ef79ed4f5",
+				DiffID: "446cbfc977a9dd9ab424607a1737ff83e20eb3c14bfe637a81fdd28ef79ed4f5",
+				Size:   10240,
+			},
+			{
+				BlobID: "sha256:2b48ec184aaa406e66fd2a80249b625c385b8f84063b6ac08ecee6b8bc0db933",
+				DiffID: "2b48ec184aaa406e66fd2a80249b625c385b8f84063b6ac08ecee6b8bc0db933",
+				Size:   10240,
+			},
+		}
+
+		logger = lagertest.NewTestLogger("test-layer-source")
+		workDir, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		archivePath = fmt.Sprintf("%s/../../../integration/assets/docker-archive/hello.tar", workDir)
+		baseImageURL, err = url.Parse(fmt.Sprintf("docker-archive://%s", archivePath))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		layerSource = source.NewLayerSource(types.SystemContext{}, false, true, 0, baseImageURL)
+	})
+
+	Describe("Manifest", func() {
+		It("fetches the manifest", func() {
+			manifest, err := layerSource.Manifest(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(manifest.ConfigInfo().Digest.String()).To(Equal(configBlob))
+			Expect(manifest.LayerInfos()).To(HaveLen(2))
+			Expect(manifest.LayerInfos()[0].Digest.String()).To(Equal(layerInfos[0].BlobID))
+			Expect(manifest.LayerInfos()[1].Digest.String()).To(Equal(layerInfos[1].BlobID))
+		})
+
+		It("contains the config", func() {
+			manifest, err := layerSource.Manifest(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := manifest.OCIConfig(context.TODO())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.RootFS.DiffIDs).To(HaveLen(2))
+			Expect(config.RootFS.DiffIDs[0].Hex()).To(Equal(layerInfos[0].DiffID))
+			Expect(config.RootFS.DiffIDs[1].Hex()).To(Equal(layerInfos[1].DiffID))
+		})
+
+		Context("when the url names the image in the archive", func() {
+			BeforeEach(func() {
+				var err error
+				baseImageURL, err = url.Parse(fmt.Sprintf("docker-archive://%s:grootfs/hello:latest", archivePath))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("fetches the manifest", func() {
+				manifest, err := layerSource.Manifest(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.ConfigInfo().Digest.String()).To(Equal(configBlob))
+			})
+		})
+
+		Context("when the archive does not exist", func() {
+			BeforeEach(func() {
+				var err error
+				baseImageURL, err = url.Parse("docker-archive:///not-here.tar")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an error", func() {
+				_, err := layerSource.Manifest(logger)
+				Expect(err).To(MatchError(MatchRegexp("^fetching image reference")))
+			})
+		})
+	})
+
+	Describe("Blob", func() {
+		It("returns the layer", func() {
+			blobPath, size, err := layerSource.Blob(logger, layerInfos[1])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(10240)))
+
+			blob, err := os.Open(blobPath)
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			tarReader := tar.NewReader(blob)
+			header, err := tarReader.Next()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(header.Name).To(Equal("etc/motd"))
+			_, err = tarReader.Next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		Context("when the layer does not match the config diffID", func() {
+			BeforeEach(func() {
+				layerInfos[1].DiffID = layerInfos[0].DiffID
+			})
+
+			It("returns an error", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[1])
+				Expect(err).To(MatchError(ContainSubstring("diffID digest mismatch")))
+			})
+		})
+	})
+})
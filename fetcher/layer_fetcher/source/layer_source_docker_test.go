@@ -5,23 +5,29 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"time"
 
 	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher"
 	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
 	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/groot/grootfakes"
 	"code.cloudfoundry.org/grootfs/integration"
 	"code.cloudfoundry.org/grootfs/testhelpers"
 	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/containers/image/signature"
 	"github.com/containers/image/types"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	digestpkg "github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 var _ = Describe("Layer source: Docker", func() {
@@ -113,6 +119,76 @@ var _ = Describe("Layer source: Docker", func() {
 				Expect(manifest.LayerInfos()[2].Digest.String()).To(Equal(testhelpers.SchemaV1EmptyBaseImage.Layers[2].BlobID))
 				Expect(manifest.LayerInfos()[2].Size).To(Equal(int64(-1)))
 			})
+
+			It("reports the digest of the schema 1 manifest, not of the converted one", func() {
+				manifest, err := layerSource.Manifest(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				convertedManifest, _, err := manifest.Manifest(context.TODO())
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(layerSource.ManifestDigest()).To(HavePrefix("sha256:"))
+				Expect(layerSource.ManifestDigest()).NotTo(Equal(digestpkg.FromBytes(convertedManifest).String()))
+			})
+		})
+
+		Context("when the image has several platforms", func() {
+			BeforeEach(func() {
+				var err error
+				baseImageURL, err = url.Parse("docker:///library/busybox:1.36")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			JustBeforeEach(func() {
+				layerSource.WithPlatform(specsv1.Platform{OS: "linux", Architecture: "arm64"})
+			})
+
+			It("fetches the manifest of the requested platform", func() {
+				manifest, err := layerSource.Manifest(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				config, err := manifest.OCIConfig(context.TODO())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(config.Architecture).To(Equal("arm64"))
+
+				Expect(layerSource.Platform().OS).To(Equal("linux"))
+				Expect(layerSource.Platform().Architecture).To(Equal("arm64"))
+			})
+
+			Context("when the image has no matching platform", func() {
+				JustBeforeEach(func() {
+					layerSource.WithPlatform(specsv1.Platform{OS: "plan9", Architecture: "amd64"})
+				})
+
+				It("returns an error", func() {
+					_, err := layerSource.Manifest(logger)
+					Expect(err).To(MatchError(ContainSubstring("no image found for platform `plan9/amd64` in the image index")))
+				})
+			})
+		})
+
+		Context("when the image has a single platform", func() {
+			Context("and another platform is requested", func() {
+				JustBeforeEach(func() {
+					layerSource.WithPlatform(specsv1.Platform{OS: "linux", Architecture: "arm64"})
+				})
+
+				It("returns an error", func() {
+					_, err := layerSource.Manifest(logger)
+					Expect(err).To(MatchError(ContainSubstring("image platform `linux/amd64` does not match the requested platform `linux/arm64`")))
+				})
+			})
+
+			Context("and its platform is requested", func() {
+				JustBeforeEach(func() {
+					layerSource.WithPlatform(specsv1.Platform{OS: "linux", Architecture: "amd64"})
+				})
+
+				It("fetches the manifest", func() {
+					_, err := layerSource.Manifest(logger)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
 		})
 
 		Context("when the image is private", func() {
@@ -292,6 +368,10 @@ var _ = Describe("Layer source: Docker", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		JustBeforeEach(func() {
+			layerSource.WithRetryPolicy(source.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+		})
+
 		AfterEach(func() {
 			fakeRegistry.Stop()
 		})
@@ -318,9 +398,141 @@ var _ = Describe("Layer source: Docker", func() {
 				ContainElement("test-layer-source.streaming-blob.attempt-get-blob-failed"))
 		})
 
+		Context("when the connection drops while downloading a blob", func() {
+			var tmpDir string
+
+			BeforeEach(func() {
+				var err error
+				tmpDir, err = ioutil.TempDir("", "partial-blobs")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Setenv("TMPDIR", tmpDir)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.Unsetenv("TMPDIR")).To(Succeed())
+				Expect(os.RemoveAll(tmpDir)).To(Succeed())
+			})
+
+			It("resumes the download from where it stopped", func() {
+				fakeRegistry.DropBlobConnections(layerInfos[0].BlobID, 40, 1)
+
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeRegistry.BlobRangeRequests(layerInfos[0].BlobID)).To(Equal([]string{"bytes=40-"}))
+				Expect(filepath.Join(tmpDir, source.PartialBlobsDirName, layerInfos[0].BlobID[len("sha256:"):])).NotTo(BeAnExistingFile())
+			})
+
+			Context("when the blob is streamed", func() {
+				It("resumes the download without keeping a partial blob", func() {
+					fakeRegistry.DropBlobConnections(layerInfos[0].BlobID, 40, 1)
+
+					stream, _, err := layerSource.StreamBlob(logger, layerInfos[0])
+					Expect(err).NotTo(HaveOccurred())
+					_, err = ioutil.ReadAll(stream)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(stream.Close()).To(Succeed())
+
+					Expect(fakeRegistry.BlobRangeRequests(layerInfos[0].BlobID)).To(Equal([]string{"bytes=40-"}))
+					Expect(filepath.Join(tmpDir, source.PartialBlobsDirName, layerInfos[0].BlobID[len("sha256:"):])).NotTo(BeAnExistingFile())
+				})
+			})
+
+			It("reuses the registry token to resume the following blobs", func() {
+				fakeRegistry.DropBlobConnections(layerInfos[0].BlobID, 40, 1)
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+				tokenRequests := len(fakeRegistry.TokenUsernames())
+
+				fakeRegistry.DropBlobConnections(layerInfos[1].BlobID, 40, 1)
+				_, _, err = layerSource.Blob(logger, layerInfos[1])
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeRegistry.BlobRangeRequests(layerInfos[1].BlobID)).To(Equal([]string{"bytes=40-"}))
+				Expect(fakeRegistry.TokenUsernames()).To(HaveLen(tokenRequests))
+			})
+
+			It("waits before resuming the download", func() {
+				fakeRegistry.DropBlobConnections(layerInfos[0].BlobID, 40, 1)
+
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(logger).To(gbytes.Say(`waiting-before-retry.*"attempt":1,"delay":"1ms"`))
+			})
+
+			Context("when it keeps dropping", func() {
+				BeforeEach(func() {
+					fakeRegistry.DropBlobConnections(layerInfos[0].BlobID, 20, 3)
+				})
+
+				It("gives up and keeps the partial blob", func() {
+					_, _, err := layerSource.Blob(logger, layerInfos[0])
+					Expect(err).To(HaveOccurred())
+
+					Expect(fakeRegistry.BlobRangeRequests(layerInfos[0].BlobID)).To(Equal([]string{"bytes=20-", "bytes=40-"}))
+					partialBlobPath := filepath.Join(tmpDir, source.PartialBlobsDirName, layerInfos[0].BlobID[len("sha256:"):])
+					Expect(partialBlobPath).To(BeAnExistingFile())
+					stat, err := os.Stat(partialBlobPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(stat.Size()).To(Equal(int64(60)))
+				})
+
+				It("resumes the download on the next attempt", func() {
+					_, _, err := layerSource.Blob(logger, layerInfos[0])
+					Expect(err).To(HaveOccurred())
+
+					blobPath, size, err := layerSource.Blob(logger, layerInfos[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(size).To(Equal(int64(90)))
+					Expect(blobPath).To(BeAnExistingFile())
+
+					Expect(fakeRegistry.BlobRangeRequests(layerInfos[0].BlobID)).To(Equal([]string{"bytes=20-", "bytes=40-", "bytes=60-"}))
+				})
+
+				It("does not count the bytes of the partial blob as downloaded", func() {
+					_, _, err := layerSource.Blob(logger, layerInfos[0])
+					Expect(err).To(HaveOccurred())
+
+					downloadCounter := source.NewDownloadCounter()
+					layerSource.WithDownloadCounter(downloadCounter)
+					_, _, err = layerSource.Blob(logger, layerInfos[0])
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(downloadCounter.BytesDownloaded()).To(Equal(int64(30)))
+				})
+			})
+		})
+
+		It("waits longer before each attempt", func() {
+			fakeRegistry.FailNextRequests(2)
+
+			_, err := layerSource.Manifest(logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(logger).To(gbytes.Say(`waiting-before-retry.*"attempt":1,"delay":"1ms"`))
+			Expect(logger).To(gbytes.Say(`waiting-before-retry.*"attempt":2,"delay":"2ms"`))
+		})
+
+		Context("when the registry fails with a client error", func() {
+			BeforeEach(func() {
+				fakeRegistry.WhenGettingBlob(layerInfos[0].BlobID, 1, func(resp http.ResponseWriter, req *http.Request) {
+					resp.WriteHeader(http.StatusNotFound)
+				})
+			})
+
+			It("does not retry", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).To(HaveOccurred())
+
+				Expect(logger.TestSink.LogMessages()).NotTo(
+					ContainElement("test-layer-source.streaming-blob.attempt-get-blob-2"))
+			})
+		})
+
 		It("retries fetching the config blob twice", func() {
 			fakeRegistry.WhenGettingBlob(configBlob, 1, func(resp http.ResponseWriter, req *http.Request) {
-				resp.WriteHeader(http.StatusTeapot)
+				resp.WriteHeader(http.StatusServiceUnavailable)
 				_, _ = resp.Write([]byte("null"))
 				return
 			})
@@ -335,6 +547,148 @@ var _ = Describe("Layer source: Docker", func() {
 		})
 	})
 
+	Context("when mirrors are configured", func() {
+		var (
+			mirror  *testhelpers.FakeRegistry
+			mirrors []source.Mirror
+		)
+
+		BeforeEach(func() {
+			dockerHubUrl, err := url.Parse("https://registry-1.docker.io")
+			Expect(err).NotTo(HaveOccurred())
+			mirror = testhelpers.NewFakeRegistry(dockerHubUrl)
+			mirror.Start()
+
+			mirrors = []source.Mirror{{Host: mirror.Addr(), Insecure: true}}
+		})
+
+		JustBeforeEach(func() {
+			layerSource.WithMirrors(mirrors)
+		})
+
+		AfterEach(func() {
+			mirror.Stop()
+		})
+
+		It("fetches the manifest from the mirror", func() {
+			manifest, err := layerSource.Manifest(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.LayerInfos()).To(HaveLen(2))
+
+			Expect(mirror.RequestedBlobs()).To(ContainElement(configBlob))
+		})
+
+		It("downloads the blob from the mirror", func() {
+			_, size, err := layerSource.Blob(logger, layerInfos[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(90)))
+
+			Expect(mirror.RequestedBlobs()).To(ContainElement(layerInfos[0].BlobID))
+		})
+
+		Context("when a signature policy is used", func() {
+			JustBeforeEach(func() {
+				policy, err := signature.NewPolicyFromBytes([]byte(`{
+					"default": [{"type": "reject"}],
+					"transports": {"docker": {"docker.io/cfgarden/empty": [{"type": "insecureAcceptAnything"}]}}
+				}`))
+				Expect(err).NotTo(HaveOccurred())
+				layerSource.WithSignaturePolicy(policy)
+			})
+
+			It("evaluates the policy against the upstream image", func() {
+				_, err := layerSource.Manifest(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mirror.RequestedBlobs()).To(ContainElement(configBlob))
+			})
+		})
+
+		Context("when the mirror has its own CA certificate", func() {
+			var certPath string
+
+			BeforeEach(func() {
+				var err error
+				certPath, err = ioutil.TempDir("", "certs")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(filepath.Join(certPath, "ca.crt"), mirror.CACertificate(), 0644)).To(Succeed())
+
+				mirrors = []source.Mirror{{Host: mirror.Addr(), CertPath: certPath}}
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(certPath)).To(Succeed())
+			})
+
+			It("trusts it for the mirror", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mirror.RequestedBlobs()).To(ContainElement(layerInfos[0].BlobID))
+			})
+		})
+
+		Context("when the mirror requires authentication", func() {
+			BeforeEach(func() {
+				mirror.ForceTokenAuthError()
+			})
+
+			It("does not send the upstream credentials to the mirror", func() {
+				_, err := layerSource.Manifest(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mirror.TokenUsernames()).NotTo(BeEmpty())
+				Expect(mirror.TokenUsernames()).NotTo(ContainElement(RegistryUsername))
+			})
+		})
+
+		Context("when the mirror is unavailable", func() {
+			BeforeEach(func() {
+				mirrors = append([]source.Mirror{{Host: "127.0.0.1:1", Insecure: true}}, mirrors...)
+			})
+
+			It("tries the next mirror", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(mirror.RequestedBlobs()).To(ContainElement(layerInfos[0].BlobID))
+			})
+		})
+
+		Context("when none of the mirrors are available", func() {
+			BeforeEach(func() {
+				mirrors = []source.Mirror{{Host: "127.0.0.1:1", Insecure: true}}
+			})
+
+			It("falls back to the upstream registry", func() {
+				manifest, err := layerSource.Manifest(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.LayerInfos()).To(HaveLen(2))
+
+				_, _, err = layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when the mirror serves a corrupted blob", func() {
+			BeforeEach(func() {
+				// the layer size is not checked, so that the digest check is hit
+				skipOCILayerValidation = true
+
+				mirror.WhenGettingBlob(layerInfos[0].BlobID, 0, func(rw http.ResponseWriter, req *http.Request) {
+					gzipWriter := gzip.NewWriter(rw)
+					_, _ = io.WriteString(gzipWriter, "corrupted-blob")
+					_ = gzipWriter.Close()
+				})
+			})
+
+			It("verifies the blob against the manifest", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).To(MatchError(ContainSubstring("layerID digest mismatch")))
+			})
+		})
+	})
+
 	Context("when a private registry is used", func() {
 		var fakeRegistry *testhelpers.FakeRegistry
 
@@ -405,6 +759,67 @@ var _ = Describe("Layer source: Docker", func() {
 			})
 		})
 
+		Context("when the CA certificate of the registry is in the cert path", func() {
+			var certPath string
+
+			BeforeEach(func() {
+				var err error
+				certPath, err = ioutil.TempDir("", "certs")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(filepath.Join(certPath, "ca.crt"), fakeRegistry.CACertificate(), 0644)).To(Succeed())
+
+				systemContext.DockerCertPath = certPath
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(certPath)).To(Succeed())
+			})
+
+			It("downloads the blob", func() {
+				_, size, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(int64(90)))
+			})
+
+			Context("when the client certificate cannot be loaded", func() {
+				BeforeEach(func() {
+					Expect(ioutil.WriteFile(filepath.Join(certPath, "client.cert"), []byte("not-a-cert"), 0644)).To(Succeed())
+				})
+
+				It("returns an error", func() {
+					_, _, err := layerSource.Blob(logger, layerInfos[0])
+					Expect(err).To(MatchError(ContainSubstring("loading client certificate")))
+				})
+			})
+		})
+
+		Context("when the CA certificate of the registry is in the per-host cert dir", func() {
+			var certsDir string
+
+			BeforeEach(func() {
+				var err error
+				certsDir, err = ioutil.TempDir("", "certs.d")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.MkdirAll(filepath.Join(certsDir, fakeRegistry.Addr()), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(certsDir, fakeRegistry.Addr(), "ca.crt"), fakeRegistry.CACertificate(), 0644)).To(Succeed())
+
+				systemContext.DockerPerHostCertDirPath = certsDir
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(certsDir)).To(Succeed())
+			})
+
+			It("resumes the download of the blob", func() {
+				fakeRegistry.DropBlobConnections(layerInfos[0].BlobID, 40, 1)
+
+				_, size, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(int64(90)))
+				Expect(fakeRegistry.BlobRangeRequests(layerInfos[0].BlobID)).To(Equal([]string{"bytes=40-"}))
+			})
+		})
+
 		Context("when using private images", func() {
 			BeforeEach(func() {
 				var err error
@@ -479,6 +894,52 @@ var _ = Describe("Layer source: Docker", func() {
 			Eventually(sess).Should(gexec.Exit(0))
 		})
 
+		Context("when a progress reporter is provided", func() {
+			var fakeProgressReporter *grootfakes.FakeProgressReporter
+
+			JustBeforeEach(func() {
+				fakeProgressReporter = new(grootfakes.FakeProgressReporter)
+				layerSource.WithProgressReporter(fakeProgressReporter)
+			})
+
+			It("reports the bytes downloaded from the blob", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeProgressReporter.ReportCallCount()).To(BeNumerically(">=", 2))
+				_, event := fakeProgressReporter.ReportArgsForCall(0)
+				Expect(event).To(Equal(groot.ProgressEvent{
+					Event:      groot.ProgressLayerDownloading,
+					BlobID:     layerInfos[0].BlobID,
+					TotalBytes: 90,
+				}))
+
+				_, event = fakeProgressReporter.ReportArgsForCall(fakeProgressReporter.ReportCallCount() - 1)
+				Expect(event).To(Equal(groot.ProgressEvent{
+					Event:      groot.ProgressLayerDownloaded,
+					BlobID:     layerInfos[0].BlobID,
+					Bytes:      90,
+					TotalBytes: 90,
+				}))
+			})
+		})
+
+		Context("when a download counter is provided", func() {
+			var downloadCounter *source.DownloadCounter
+
+			JustBeforeEach(func() {
+				downloadCounter = source.NewDownloadCounter()
+				layerSource.WithDownloadCounter(downloadCounter)
+			})
+
+			It("counts the bytes downloaded from the blob", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(downloadCounter.BytesDownloaded()).To(Equal(int64(90)))
+			})
+		})
+
 		Context("when the media type doesn't match the blob", func() {
 			var fakeRegistry *testhelpers.FakeRegistry
 
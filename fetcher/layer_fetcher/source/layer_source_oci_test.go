@@ -1,20 +1,31 @@
 package source_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
 	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/store/blob_cache"
 	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/containers/image/signature"
 	"github.com/containers/image/types"
+	"github.com/klauspost/compress/zstd"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	digestpkg "github.com/opencontainers/go-digest"
+	errorspkg "github.com/pkg/errors"
 )
 
 var _ = Describe("Layer source: OCI", func() {
@@ -127,6 +138,43 @@ var _ = Describe("Layer source: OCI", func() {
 			})
 		})
 
+		Context("when the image layout is archived", func() {
+			var archivePath string
+
+			BeforeEach(func() {
+				archiveFile, err := ioutil.TempFile("", "oci-archive")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(archiveFile.Close()).To(Succeed())
+				archivePath = archiveFile.Name()
+
+				layoutPath := filepath.Join(workDir, "../../../integration/assets/oci-test-image/opq-whiteouts-busybox")
+				Expect(exec.Command("tar", "-C", layoutPath, "-cf", archivePath, ".").Run()).To(Succeed())
+
+				baseImageURL, err = url.Parse(fmt.Sprintf("oci-archive://%s:latest", archivePath))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(archivePath)).To(Succeed())
+			})
+
+			It("fetches the manifest", func() {
+				manifest, err := layerSource.Manifest(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(manifest.ConfigInfo().Digest.String()).To(Equal(configBlob))
+				Expect(manifest.LayerInfos()).To(HaveLen(2))
+				Expect(manifest.LayerInfos()[0].Digest.String()).To(Equal(layerInfos[0].BlobID))
+			})
+
+			It("streams the blobs out of the archive", func() {
+				blobPath, size, err := layerSource.Blob(logger, layerInfos[1])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(layerInfos[1].Size))
+				Expect(blobPath).To(BeAnExistingFile())
+			})
+		})
+
 		Context("when the config blob does not exist", func() {
 			BeforeEach(func() {
 				var err error
@@ -141,6 +189,55 @@ var _ = Describe("Layer source: OCI", func() {
 		})
 	})
 
+	Context("when a signature policy is used", func() {
+		var policyJSON string
+
+		JustBeforeEach(func() {
+			policy, err := signature.NewPolicyFromBytes([]byte(policyJSON))
+			Expect(err).NotTo(HaveOccurred())
+			layerSource.WithSignaturePolicy(policy)
+		})
+
+		Context("when the policy allows the image", func() {
+			BeforeEach(func() {
+				policyJSON = `{"default": [{"type": "insecureAcceptAnything"}]}`
+			})
+
+			It("fetches the manifest", func() {
+				manifest, err := layerSource.Manifest(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(manifest.ConfigInfo().Digest.String()).To(Equal(configBlob))
+			})
+		})
+
+		Context("when the policy rejects the image", func() {
+			BeforeEach(func() {
+				policyJSON = `{"default": [{"type": "reject"}]}`
+			})
+
+			It("returns an image rejected error", func() {
+				_, err := layerSource.Manifest(logger)
+				Expect(err).To(HaveOccurred())
+
+				rejectedErr, ok := errorspkg.Cause(err).(*source.ImageRejectedError)
+				Expect(ok).To(BeTrue(), fmt.Sprintf("unexpected error: %s", err))
+				Expect(rejectedErr.Error()).To(HavePrefix("image rejected by signature policy"))
+			})
+		})
+
+		Context("when the policy requires a signature the image does not have", func() {
+			BeforeEach(func() {
+				policyJSON = `{"default": [{"type": "signedBy", "keyType": "GPGKeys", "keyPath": "/does/not/matter.gpg"}]}`
+			})
+
+			It("returns an image rejected error", func() {
+				_, err := layerSource.Manifest(logger)
+				_, ok := errorspkg.Cause(err).(*source.ImageRejectedError)
+				Expect(ok).To(BeTrue(), fmt.Sprintf("unexpected error: %v", err))
+			})
+		})
+	})
+
 	Describe("Blob", func() {
 		It("downloads a blob", func() {
 			blobPath, size, err := layerSource.Blob(logger, layerInfos[0])
@@ -273,4 +370,302 @@ var _ = Describe("Layer source: OCI", func() {
 			})
 		})
 	})
+
+	Context("when layers use other compressions", func() {
+		var (
+			imageDir  string
+			tarball   []byte
+			layerInfo groot.LayerInfo
+		)
+
+		// addLayer adds the blob to the image and returns its layer info
+		addLayer := func(blob []byte, mediaType string) groot.LayerInfo {
+			blobDigest := digestpkg.FromBytes(blob)
+			blobPath := filepath.Join(imageDir, "blobs", "sha256", blobDigest.Hex())
+			Expect(ioutil.WriteFile(blobPath, blob, 0644)).To(Succeed())
+
+			return groot.LayerInfo{
+				BlobID:    blobDigest.String(),
+				DiffID:    digestpkg.FromBytes(tarball).Hex(),
+				Size:      int64(len(blob)),
+				MediaType: mediaType,
+			}
+		}
+
+		gzipped := func(contents []byte) []byte {
+			buffer := bytes.NewBuffer([]byte{})
+			gzipWriter := gzip.NewWriter(buffer)
+			_, err := gzipWriter.Write(contents)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gzipWriter.Close()).To(Succeed())
+			return buffer.Bytes()
+		}
+
+		zstdCompressed := func(contents []byte) []byte {
+			zstdWriter, err := zstd.NewWriter(nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer zstdWriter.Close()
+			return zstdWriter.EncodeAll(contents, nil)
+		}
+
+		BeforeEach(func() {
+			tmpDir, err := ioutil.TempDir("", "oci-image")
+			Expect(err).NotTo(HaveOccurred())
+			imageDir = filepath.Join(tmpDir, "image")
+			cmd := exec.Command("cp", "-r", filepath.Join(workDir, "../../../integration/assets/oci-test-image/opq-whiteouts-busybox"), imageDir)
+			Expect(cmd.Run()).To(Succeed())
+
+			baseImageURL, err = url.Parse(fmt.Sprintf("oci:///%s:latest", imageDir))
+			Expect(err).NotTo(HaveOccurred())
+
+			tarBuffer := bytes.NewBuffer([]byte{})
+			tarWriter := tar.NewWriter(tarBuffer)
+			Expect(tarWriter.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5})).To(Succeed())
+			_, err = tarWriter.Write([]byte("world"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tarWriter.Close()).To(Succeed())
+			tarball = tarBuffer.Bytes()
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(filepath.Dir(imageDir))).To(Succeed())
+		})
+
+		readBlob := func() []byte {
+			blobPath, _, err := layerSource.Blob(logger, layerInfo)
+			Expect(err).NotTo(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(blobPath)
+			Expect(err).NotTo(HaveOccurred())
+			return contents
+		}
+
+		Context("when the layer is compressed with zstd", func() {
+			BeforeEach(func() {
+				layerInfo = addLayer(zstdCompressed(tarball), "application/vnd.oci.image.layer.v1.tar+zstd")
+			})
+
+			It("uncompresses the blob", func() {
+				Expect(readBlob()).To(Equal(tarball))
+			})
+		})
+
+		Context("when the layer is not compressed", func() {
+			BeforeEach(func() {
+				layerInfo = addLayer(tarball, "application/vnd.oci.image.layer.v1.tar")
+			})
+
+			It("returns the blob as it is", func() {
+				Expect(readBlob()).To(Equal(tarball))
+			})
+		})
+
+		Context("when the media type is empty", func() {
+			It("detects gzip blobs", func() {
+				layerInfo = addLayer(gzipped(tarball), "")
+				Expect(readBlob()).To(Equal(tarball))
+			})
+
+			It("detects zstd blobs", func() {
+				layerInfo = addLayer(zstdCompressed(tarball), "")
+				Expect(readBlob()).To(Equal(tarball))
+			})
+
+			It("detects uncompressed blobs", func() {
+				layerInfo = addLayer(tarball, "")
+				Expect(readBlob()).To(Equal(tarball))
+			})
+		})
+
+		Context("when the blob doesn't match the diffID", func() {
+			BeforeEach(func() {
+				layerInfo = addLayer(zstdCompressed([]byte("not-the-tarball")), "application/vnd.oci.image.layer.v1.tar+zstd")
+			})
+
+			It("returns an error", func() {
+				_, _, err := layerSource.Blob(logger, layerInfo)
+				Expect(err).To(MatchError(ContainSubstring("diffID digest mismatch")))
+			})
+		})
+	})
+
+	Context("when a blob cache is used", func() {
+		var (
+			blobCachePath string
+			blobCache     *blob_cache.BlobCache
+		)
+
+		BeforeEach(func() {
+			var err error
+			blobCachePath, err = ioutil.TempDir("", "blobs")
+			Expect(err).NotTo(HaveOccurred())
+			blobCache = blob_cache.NewBlobCache(blobCachePath, 10*1024*1024)
+		})
+
+		JustBeforeEach(func() {
+			layerSource.WithBlobCache(blobCache)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(blobCachePath)).To(Succeed())
+		})
+
+		It("adds the downloaded blob to the cache", func() {
+			_, _, err := layerSource.Blob(logger, layerInfos[0])
+			Expect(err).NotTo(HaveOccurred())
+
+			cachedBlob, size, ok := blobCache.Get(logger, layerInfos[0].BlobID)
+			Expect(ok).To(BeTrue())
+			Expect(cachedBlob.Close()).To(Succeed())
+			Expect(size).To(Equal(int64(668151)))
+		})
+
+		It("uses the cached blob instead of the image", func() {
+			_, _, err := layerSource.Blob(logger, layerInfos[0])
+			Expect(err).NotTo(HaveOccurred())
+
+			missingImageURL, err := url.Parse(fmt.Sprintf("oci:///%s/../../../integration/assets/oci-test-image/does-not-exist:latest", workDir))
+			Expect(err).NotTo(HaveOccurred())
+			otherSource := source.NewLayerSource(systemContext, false, true, 0, missingImageURL)
+			otherSource.WithBlobCache(blobCache)
+
+			blobPath, size, err := otherSource.Blob(logger, layerInfos[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(668151)))
+			Expect(blobPath).To(BeAnExistingFile())
+		})
+
+		It("does not count the cached blob as downloaded", func() {
+			_, _, err := layerSource.Blob(logger, layerInfos[0])
+			Expect(err).NotTo(HaveOccurred())
+
+			downloadCounter := source.NewDownloadCounter()
+			layerSource.WithDownloadCounter(downloadCounter)
+			_, _, err = layerSource.Blob(logger, layerInfos[0])
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(downloadCounter.BytesDownloaded()).To(BeZero())
+		})
+
+		Context("when the cached blob is corrupted", func() {
+			var cachedBlobPath string
+
+			JustBeforeEach(func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+
+				cachedBlobPath = filepath.Join(blobCachePath, "sha256", strings.TrimPrefix(layerInfos[0].BlobID, "sha256:"))
+				contents, err := ioutil.ReadFile(cachedBlobPath)
+				Expect(err).NotTo(HaveOccurred())
+				contents[len(contents)/2] ^= 0xff
+				Expect(ioutil.WriteFile(cachedBlobPath, contents, 0644)).To(Succeed())
+			})
+
+			It("fails and evicts the blob, so that it is fetched again", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).To(HaveOccurred())
+				Expect(cachedBlobPath).NotTo(BeAnExistingFile())
+
+				_, _, err = layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cachedBlobPath).To(BeAnExistingFile())
+			})
+		})
+
+		Context("when the blob is corrupted", func() {
+			BeforeEach(func() {
+				var err error
+				baseImageURL, err = url.Parse(fmt.Sprintf("oci:///%s/../../../integration/assets/oci-test-image/corrupted:latest", workDir))
+				Expect(err).NotTo(HaveOccurred())
+				layerInfos[0].Size = 668551
+				skipOCILayerValidation = true
+			})
+
+			It("does not cache it, even when the validation is skipped", func() {
+				_, _, err := layerSource.Blob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+
+				_, _, ok := blobCache.Get(logger, layerInfos[0].BlobID)
+				Expect(ok).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("StreamBlob", func() {
+		It("streams the uncompressed blob", func() {
+			stream, size, err := layerSource.StreamBlob(logger, layerInfos[0])
+			Expect(err).NotTo(HaveOccurred())
+			defer stream.Close()
+			Expect(size).To(Equal(int64(668151)))
+
+			buffer := gbytes.NewBuffer()
+			cmd := exec.Command("tar", "tv")
+			cmd.Stdin = stream
+			sess, err := gexec.Start(cmd, buffer, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(sess, "2s").Should(gexec.Exit(0))
+			Expect(string(buffer.Contents())).To(ContainSubstring("etc/localtime"))
+		})
+
+		Context("when the blob is corrupted", func() {
+			BeforeEach(func() {
+				var err error
+				baseImageURL, err = url.Parse(fmt.Sprintf("oci:///%s/../../../integration/assets/oci-test-image/corrupted:latest", workDir))
+				Expect(err).NotTo(HaveOccurred())
+				layerInfos[0].Size = 668551
+			})
+
+			It("fails at the end of the stream", func() {
+				stream, _, err := layerSource.StreamBlob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+				defer stream.Close()
+
+				_, err = ioutil.ReadAll(stream)
+				Expect(err).To(MatchError(ContainSubstring("layerID digest mismatch")))
+			})
+		})
+
+		Context("when the blob doesn't match the diffID", func() {
+			BeforeEach(func() {
+				layerInfos[0].DiffID = "0000000000000000000000000000000000000000000000000000000000000000"
+			})
+
+			It("fails at the end of the stream", func() {
+				stream, _, err := layerSource.StreamBlob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+				defer stream.Close()
+
+				_, err = ioutil.ReadAll(stream)
+				Expect(err).To(MatchError(ContainSubstring("diffID digest mismatch")))
+			})
+		})
+
+		Context("when the actual blob size is different than the layersize in the manifest", func() {
+			BeforeEach(func() {
+				layerInfos[0].Size = 100
+			})
+
+			It("returns an error", func() {
+				_, _, err := layerSource.StreamBlob(logger, layerInfos[0])
+				Expect(err).To(MatchError(ContainSubstring("layer size is different from the value in the manifest")))
+			})
+		})
+
+		Context("when the uncompressed layer size is bigger that the quota", func() {
+			BeforeEach(func() {
+				skipImageQuotaValidation = false
+				imageQuota = 1
+			})
+
+			It("fails while streaming", func() {
+				stream, _, err := layerSource.StreamBlob(logger, layerInfos[0])
+				Expect(err).NotTo(HaveOccurred())
+				defer stream.Close()
+
+				_, err = ioutil.ReadAll(stream)
+				Expect(err).To(MatchError(ContainSubstring("uncompressed layer size exceeds quota")))
+			})
+		})
+	})
 })
@@ -0,0 +1,61 @@
+package source
+
+import (
+	"io"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager"
+)
+
+// progressReportInterval is the shortest time between two reports of the
+// bytes downloaded for a blob
+const progressReportInterval = time.Second
+
+// progressReader reports the bytes read from a blob, when it is opened, at
+// most once per progressReportInterval while it is read, and when it ends
+type progressReader struct {
+	logger     lager.Logger
+	reader     io.Reader
+	reporter   groot.ProgressReporter
+	blobID     string
+	total      int64
+	read       int64
+	lastReport time.Time
+}
+
+func newProgressReader(logger lager.Logger, reader io.Reader, reporter groot.ProgressReporter, blobID string, total int64) *progressReader {
+	r := &progressReader{
+		logger:   logger,
+		reader:   reader,
+		reporter: reporter,
+		blobID:   blobID,
+		total:    total,
+	}
+	r.report(groot.ProgressLayerDownloading)
+
+	return r
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+
+	if err == io.EOF {
+		r.report(groot.ProgressLayerDownloaded)
+	} else if time.Since(r.lastReport) >= progressReportInterval {
+		r.report(groot.ProgressLayerDownloading)
+	}
+
+	return n, err
+}
+
+func (r *progressReader) report(event string) {
+	r.lastReport = time.Now()
+	r.reporter.Report(r.logger, groot.ProgressEvent{
+		Event:      event,
+		BlobID:     r.blobID,
+		Bytes:      r.read,
+		TotalBytes: r.total,
+	})
+}
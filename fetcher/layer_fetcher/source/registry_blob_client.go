@@ -0,0 +1,461 @@
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/types"
+	digestpkg "github.com/opencontainers/go-digest"
+	errorspkg "github.com/pkg/errors"
+)
+
+const dockerHubDomain = "docker.io"
+const dockerHubRegistry = "registry-1.docker.io"
+
+// systemPerHostCertDir is where containers/image looks for the certificates
+// of a registry when the system context does not say otherwise
+const systemPerHostCertDir = "/etc/docker/certs.d"
+
+var errRangeNotSatisfiable = errorspkg.New("requested range not satisfiable")
+
+// registryStatusError is a registry response with a status the blob client
+// cannot handle
+type registryStatusError struct {
+	operation  string
+	statusCode int
+	status     string
+}
+
+func (e *registryStatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %s", e.operation, e.status)
+}
+
+// StatusCode returns the HTTP status code of the response
+func (e *registryStatusError) StatusCode() int {
+	return e.statusCode
+}
+
+// imageSourceStatusError is a blob request of containers/image that failed
+// with an unexpected status, which it only reports in the message
+type imageSourceStatusError struct {
+	error
+	statusCode int
+}
+
+// StatusCode returns the HTTP status code of the response
+func (e *imageSourceStatusError) StatusCode() int {
+	return e.statusCode
+}
+
+// blobStatusError gives the status of a failed containers/image blob request
+// back its type, so that the retry policy can tell server errors from client
+// errors
+func blobStatusError(err error) error {
+	var statusCode int
+	if _, scanErr := fmt.Sscanf(err.Error(), "Invalid status code returned when fetching blob %d", &statusCode); scanErr != nil {
+		return err
+	}
+
+	return &imageSourceStatusError{error: err, statusCode: statusCode}
+}
+
+// registryBlobClient fetches blobs from a docker registry with HTTP Range
+// requests. containers/image always downloads a blob from its first byte, so
+// it cannot be used to resume an interrupted download. A client is meant to be
+// shared by the downloads from the same repository, which then reuse its
+// connections and its token.
+type registryBlobClient struct {
+	httpClient *http.Client
+	registry   string
+	repository string
+	authConfig *types.DockerAuthConfig
+	insecure   bool
+
+	// mutex guards the scheme and the authorization, as blobs can be
+	// resumed concurrently
+	mutex      sync.Mutex
+	scheme     string
+	authHeader string
+}
+
+func newRegistryBlobClient(ref reference.Named, systemContext types.SystemContext) (*registryBlobClient, error) {
+	registry := reference.Domain(ref)
+	if registry == dockerHubDomain {
+		registry = dockerHubRegistry
+	}
+
+	tlsConfig, err := registryTLSConfig(systemContext, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &registryBlobClient{
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		scheme:     "https",
+		registry:   registry,
+		repository: reference.Path(ref),
+		authConfig: systemContext.DockerAuthConfig,
+		insecure:   systemContext.DockerInsecureSkipTLSVerify,
+	}, nil
+}
+
+// registryTLSConfig trusts the certificates of the registry cert dir, as
+// containers/image does for the manifests
+func registryTLSConfig(systemContext types.SystemContext, registry string) (*tls.Config, error) {
+	return TLSConfig(registryCertDir(systemContext, registry), systemContext.DockerInsecureSkipTLSVerify)
+}
+
+// TLSConfig trusts the `*.crt` files of the cert dir on top of the system CAs,
+// and presents its `*.cert` and `*.key` pairs. A missing cert dir keeps the
+// system CAs.
+func TLSConfig(certDir string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+
+	if certDir == "" {
+		return tlsConfig, nil
+	}
+
+	entries, err := ioutil.ReadDir(certDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tlsConfig, nil
+		}
+		return nil, errorspkg.Wrap(err, "reading cert dir")
+	}
+
+	for _, entry := range entries {
+		certPath := filepath.Join(certDir, entry.Name())
+
+		switch filepath.Ext(entry.Name()) {
+		case ".crt":
+			caCert, err := ioutil.ReadFile(certPath)
+			if err != nil {
+				return nil, errorspkg.Wrap(err, "reading CA certificate")
+			}
+			if tlsConfig.RootCAs == nil {
+				tlsConfig.RootCAs, err = x509.SystemCertPool()
+				if err != nil {
+					tlsConfig.RootCAs = x509.NewCertPool()
+				}
+			}
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+				return nil, errorspkg.Errorf("no certificate found in `%s`", certPath)
+			}
+
+		case ".cert":
+			keyPath := strings.TrimSuffix(certPath, ".cert") + ".key"
+			clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+			if err != nil {
+				return nil, errorspkg.Wrap(err, "loading client certificate")
+			}
+			tlsConfig.Certificates = append(tlsConfig.Certificates, clientCert)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// registryCertDir returns the directory with the certificates of the
+// registry. Like containers/image, it falls back to the registry directory in
+// the per-host cert dir when no cert path is given.
+func registryCertDir(systemContext types.SystemContext, registry string) string {
+	if systemContext.DockerCertPath != "" {
+		return systemContext.DockerCertPath
+	}
+
+	perHostCertDir := systemContext.DockerPerHostCertDirPath
+	if perHostCertDir == "" {
+		perHostCertDir = systemPerHostCertDir
+	}
+
+	return filepath.Join(perHostCertDir, registry)
+}
+
+// GetBlobFrom returns the blob starting at offset, along with the total size
+// of the blob. Registries that do not support Range requests send the whole
+// blob, in which case the bytes before offset are skipped.
+func (c *registryBlobClient) GetBlobFrom(ctx context.Context, digest digestpkg.Digest, offset int64) (io.ReadCloser, int64, error) {
+	resp, err := c.get(ctx, digest, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			resp.Body.Close()
+			return nil, 0, err
+		}
+		if start != offset {
+			resp.Body.Close()
+			return nil, 0, errorspkg.Errorf("registry returned the blob from byte %d instead of %d", start, offset)
+		}
+		return resp.Body, total, nil
+
+	case http.StatusOK:
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, 0, errorspkg.Wrap(err, "skipping the downloaded part of the blob")
+		}
+		return resp.Body, resp.ContentLength, nil
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, 0, errRangeNotSatisfiable
+
+	default:
+		resp.Body.Close()
+		return nil, 0, &registryStatusError{operation: "fetching blob", statusCode: resp.StatusCode, status: resp.Status}
+	}
+}
+
+func (c *registryBlobClient) get(ctx context.Context, digest digestpkg.Digest, offset int64) (*http.Response, error) {
+	resp, err := c.do(ctx, digest, offset)
+	if err != nil && c.insecure && c.getScheme() == "https" && isPlainHTTPServer(err) {
+		c.setScheme("http")
+		resp, err = c.do(ctx, digest, offset)
+	}
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "fetching blob")
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("Www-Authenticate")
+	resp.Body.Close()
+	if err := c.authenticate(ctx, challenge); err != nil {
+		return nil, err
+	}
+
+	resp, err = c.do(ctx, digest, offset)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "fetching blob")
+	}
+
+	return resp, nil
+}
+
+func (c *registryBlobClient) getScheme() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.scheme
+}
+
+func (c *registryBlobClient) setScheme(scheme string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.scheme = scheme
+}
+
+func (c *registryBlobClient) do(ctx context.Context, digest digestpkg.Digest, offset int64) (*http.Response, error) {
+	c.mutex.Lock()
+	scheme, authHeader := c.scheme, c.authHeader
+	c.mutex.Unlock()
+
+	blobURL := fmt.Sprintf("%s://%s/v2/%s/blobs/%s", scheme, c.registry, c.repository, digest)
+	req, err := http.NewRequest("GET", blobURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+func (c *registryBlobClient) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.authConfig == nil || c.authConfig.Username == "" {
+			return errorspkg.New("registry requires credentials")
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(c.authConfig.Username, c.authConfig.Password)
+		c.setAuthHeader(req.Header.Get("Authorization"))
+		return nil
+
+	case "bearer":
+		token, err := c.fetchToken(ctx, params)
+		if err != nil {
+			return err
+		}
+		c.setAuthHeader("Bearer " + token)
+		return nil
+
+	default:
+		return errorspkg.Errorf("unsupported authentication challenge `%s`", challenge)
+	}
+}
+
+func (c *registryBlobClient) setAuthHeader(authHeader string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.authHeader = authHeader
+}
+
+func (c *registryBlobClient) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errorspkg.Errorf("invalid token realm `%s`", params["realm"])
+	}
+
+	query := realm.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	scope, ok := params["scope"]
+	if !ok {
+		scope = fmt.Sprintf("repository:%s:pull", c.repository)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if c.authConfig != nil && c.authConfig.Username != "" {
+		req.SetBasicAuth(c.authConfig.Username, c.authConfig.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", errorspkg.Wrap(err, "fetching registry token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &registryStatusError{operation: "fetching registry token", statusCode: resp.StatusCode, status: resp.Status}
+	}
+
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", errorspkg.Wrap(err, "decoding registry token")
+	}
+
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	return tokenResponse.AccessToken, nil
+}
+
+// isPlainHTTPServer returns whether the TLS handshake failed because the
+// registry only speaks plain HTTP. Insecure registries are only retried over
+// plain HTTP then, so that timeouts or dropped connections are not mistaken
+// for it.
+func isPlainHTTPServer(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	if errors.As(err, &recordHeaderErr) {
+		return true
+	}
+
+	// net/http replaces the TLS error when the response looks like HTTP
+	return strings.Contains(err.Error(), "server gave HTTP response to HTTPS client")
+}
+
+// parseChallenge parses a `Www-Authenticate` header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+// Quoted values can hold commas, as in `scope="repository:a:pull,push"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+
+	challenge = strings.TrimSpace(challenge)
+	parts := strings.SplitN(challenge, " ", 2)
+	if len(parts) < 2 {
+		return challenge, params
+	}
+
+	rest := parts[1]
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		equals := strings.IndexByte(rest, '=')
+		if equals < 0 {
+			break
+		}
+
+		key := strings.ToLower(strings.TrimSpace(rest[:equals]))
+		var value string
+		value, rest = parseChallengeValue(strings.TrimLeft(rest[equals+1:], " "))
+		params[key] = value
+	}
+
+	return parts[0], params
+}
+
+// parseChallengeValue returns the value at the start of the parameters, with
+// its quotes and escapes removed, and the parameters that follow it
+func parseChallengeValue(params string) (string, string) {
+	if !strings.HasPrefix(params, `"`) {
+		end := strings.IndexByte(params, ',')
+		if end < 0 {
+			return strings.TrimSpace(params), ""
+		}
+		return strings.TrimSpace(params[:end]), params[end+1:]
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(params); i++ {
+		switch params[i] {
+		case '\\':
+			if i+1 < len(params) {
+				i++
+				value.WriteByte(params[i])
+			}
+		case '"':
+			return value.String(), params[i+1:]
+		default:
+			value.WriteByte(params[i])
+		}
+	}
+
+	return value.String(), ""
+}
+
+// parseContentRange parses a `Content-Range` header such as
+// `bytes 100-999/1000` and returns the first byte and the total size
+func parseContentRange(contentRange string) (int64, int64, error) {
+	var start, end, total int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		return 0, 0, errorspkg.Wrapf(err, "invalid content range `%s`", contentRange)
+	}
+
+	return start, total, nil
+}
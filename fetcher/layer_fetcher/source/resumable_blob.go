@@ -0,0 +1,252 @@
+package source
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	digestpkg "github.com/opencontainers/go-digest"
+	errorspkg "github.com/pkg/errors"
+)
+
+// PartialBlobsDirName is the directory, inside the temporary directory, where
+// interrupted downloads are kept until they can be resumed
+const PartialBlobsDirName = "partial-blobs"
+
+// partialBlobTTL is how long a partial blob that nobody is downloading is kept
+// for a later create to resume it
+const partialBlobTTL = time.Hour
+
+// resumableBlob is a blob being downloaded from a registry. When the
+// connection drops, the download carries on from the last byte received with
+// a Range request, as many times as the retry policy allows.
+//
+// When the blob is staged on disk anyway, the download also goes into a
+// partial file, so that a later create can resume it. A partial file left
+// behind by a previous download is replayed before the rest of the blob is
+// fetched. Streamed blobs are never written to a partial file.
+type resumableBlob struct {
+	logger  lager.Logger
+	digest  digestpkg.Digest
+	client  *registryBlobClient
+	file    *os.File
+	replay  io.Reader
+	body    io.ReadCloser
+	offset  int64
+	size    int64
+	retries int
+	retry   RetryPolicy
+	done    bool
+	// counter only counts the bytes received from the registry, not the
+	// ones replayed from the partial file
+	counter *DownloadCounter
+}
+
+func openPartialBlob(digest digestpkg.Digest) (*os.File, int64, error) {
+	partialBlobsDir := filepath.Join(os.TempDir(), PartialBlobsDirName)
+	if err := os.MkdirAll(partialBlobsDir, 0700); err != nil {
+		return nil, 0, errorspkg.Wrap(err, "creating partial blobs directory")
+	}
+
+	file, err := os.OpenFile(filepath.Join(partialBlobsDir, digest.Hex()), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, 0, errorspkg.Wrap(err, "opening partial blob")
+	}
+
+	// another process might be downloading the same blob
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		return nil, 0, errorspkg.Wrap(err, "locking partial blob")
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, errorspkg.Wrap(err, "stating partial blob")
+	}
+
+	return file, stat.Size(), nil
+}
+
+func (b *resumableBlob) Read(p []byte) (int, error) {
+	for {
+		if b.replay != nil {
+			n, err := b.replay.Read(p)
+			if err == io.EOF {
+				b.replay = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if b.body == nil {
+			if b.size >= 0 && b.offset >= b.size {
+				b.complete()
+				return 0, io.EOF
+			}
+
+			b.waitBeforeRetry()
+			if err := b.resume(); err != nil {
+				b.logger.Error("resuming-blob-download-failed", err)
+				b.retries++
+				if !b.retry.ShouldRetry(b.retries, err) {
+					return 0, err
+				}
+				continue
+			}
+		}
+
+		n, err := b.body.Read(p)
+		if n > 0 {
+			if b.file != nil {
+				if _, writeErr := b.file.Write(p[:n]); writeErr != nil {
+					return n, errorspkg.Wrap(writeErr, "writing partial blob")
+				}
+			}
+			b.offset += int64(n)
+			b.counter.Add(n)
+		}
+
+		if err == io.EOF && (b.size < 0 || b.offset == b.size) {
+			b.complete()
+			return n, io.EOF
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			b.logger.Error("blob-download-interrupted", err, lager.Data{"offset": b.offset, "size": b.size})
+			b.body.Close()
+			b.body = nil
+
+			b.retries++
+			if !b.retry.ShouldRetry(b.retries, err) {
+				return n, err
+			}
+		}
+
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (b *resumableBlob) waitBeforeRetry() {
+	if b.retries == 0 {
+		return
+	}
+
+	delay := b.retry.Delay(b.retries)
+	b.logger.Debug("waiting-before-retry", lager.Data{"attempt": b.retries, "delay": delay.String()})
+	time.Sleep(delay)
+}
+
+func (b *resumableBlob) resume() error {
+	b.logger.Debug("resuming-blob-download", lager.Data{"offset": b.offset, "attempt": b.retries})
+
+	body, size, err := b.client.GetBlobFrom(context.TODO(), b.digest, b.offset)
+	if err != nil {
+		return errorspkg.Wrap(err, "resuming blob download")
+	}
+
+	b.body = body
+	if b.size < 0 {
+		b.size = size
+	}
+
+	return nil
+}
+
+// complete removes the partial file, as the whole blob has been downloaded
+func (b *resumableBlob) complete() {
+	if b.done || b.file == nil {
+		return
+	}
+	b.done = true
+
+	if err := os.Remove(b.file.Name()); err != nil {
+		b.logger.Error("removing-partial-blob-failed", err)
+	}
+}
+
+// Close keeps the partial file around, so that the download can be resumed
+// by a later attempt.
+func (b *resumableBlob) Close() error {
+	if b.body != nil {
+		b.body.Close()
+	}
+
+	if b.file == nil {
+		return nil
+	}
+
+	if b.offset == 0 {
+		b.complete()
+	}
+
+	return b.file.Close()
+}
+
+// PartialBlobs are the interrupted downloads kept in a temporary directory
+type PartialBlobs struct {
+	tempDir string
+}
+
+func NewPartialBlobs(tempDir string) *PartialBlobs {
+	return &PartialBlobs{tempDir: tempDir}
+}
+
+// RemoveStale removes the partial blobs that no download holds and that have
+// not been written to for partialBlobTTL
+func (p *PartialBlobs) RemoveStale(logger lager.Logger) error {
+	logger = logger.Session("removing-stale-partial-blobs")
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	partialBlobsDir := filepath.Join(p.tempDir, PartialBlobsDirName)
+	entries, err := ioutil.ReadDir(partialBlobsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errorspkg.Wrap(err, "listing partial blobs")
+	}
+
+	for _, entry := range entries {
+		if time.Since(entry.ModTime()) < partialBlobTTL {
+			continue
+		}
+
+		if err := removeUnlockedFile(filepath.Join(partialBlobsDir, entry.Name())); err != nil {
+			logger.Error("removing-partial-blob-failed", err, lager.Data{"name": entry.Name()})
+			continue
+		}
+		logger.Debug("partial-blob-removed", lager.Data{"name": entry.Name(), "size": entry.Size()})
+	}
+
+	return nil
+}
+
+func removeUnlockedFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the download holding the lock could still resume it
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
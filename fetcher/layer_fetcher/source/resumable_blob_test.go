@@ -0,0 +1,82 @@
+package source_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PartialBlobs", func() {
+	var (
+		logger          *lagertest.TestLogger
+		tempDir         string
+		partialBlobsDir string
+		partialBlobs    *source.PartialBlobs
+	)
+
+	writePartialBlob := func(name string, age time.Duration) string {
+		path := filepath.Join(partialBlobsDir, name)
+		Expect(ioutil.WriteFile(path, []byte("partial"), 0600)).To(Succeed())
+		modTime := time.Now().Add(-age)
+		Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "partial-blobs")
+		Expect(err).NotTo(HaveOccurred())
+		partialBlobsDir = filepath.Join(tempDir, source.PartialBlobsDirName)
+		Expect(os.Mkdir(partialBlobsDir, 0700)).To(Succeed())
+
+		logger = lagertest.NewTestLogger("partial-blobs")
+		partialBlobs = source.NewPartialBlobs(tempDir)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Describe("RemoveStale", func() {
+		It("removes the partial blobs that were not written to for an hour", func() {
+			stalePath := writePartialBlob("stale", 2*time.Hour)
+			recentPath := writePartialBlob("recent", time.Minute)
+
+			Expect(partialBlobs.RemoveStale(logger)).To(Succeed())
+
+			Expect(stalePath).NotTo(BeAnExistingFile())
+			Expect(recentPath).To(BeAnExistingFile())
+		})
+
+		Context("when a download holds the partial blob", func() {
+			It("keeps it", func() {
+				path := writePartialBlob("downloading", 2*time.Hour)
+				file, err := os.Open(path)
+				Expect(err).NotTo(HaveOccurred())
+				defer file.Close()
+				Expect(syscall.Flock(int(file.Fd()), syscall.LOCK_EX)).To(Succeed())
+
+				Expect(partialBlobs.RemoveStale(logger)).To(Succeed())
+
+				Expect(path).To(BeAnExistingFile())
+			})
+		})
+
+		Context("when there are no partial blobs", func() {
+			BeforeEach(func() {
+				Expect(os.RemoveAll(partialBlobsDir)).To(Succeed())
+			})
+
+			It("does nothing", func() {
+				Expect(partialBlobs.RemoveStale(logger)).To(Succeed())
+			})
+		})
+	})
+})
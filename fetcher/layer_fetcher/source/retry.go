@@ -0,0 +1,148 @@
+package source
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client"
+	errorspkg "github.com/pkg/errors"
+)
+
+// DefaultRetryPolicy is used for the registry operations, unless another
+// policy is given with WithRetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: MAX_DOCKER_RETRIES,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+	Jitter:      0.2,
+}
+
+// RetryPolicy is how often and how quickly failed registry operations are
+// attempted again. The delay doubles after each attempt, from BaseDelay up to
+// MaxDelay, and is spread by up to Jitter (a fraction of the delay) so that
+// concurrent creates do not hit the registry in lockstep.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// Delay returns how long to wait before the attempt following the given one.
+// Attempts start at 1.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// ShouldRetry returns whether the operation should be attempted again after
+// the given attempt failed with err
+func (p RetryPolicy) ShouldRetry(attempt int, err error) bool {
+	return attempt < p.MaxAttempts && IsRetryable(err)
+}
+
+// IsRetryable returns whether the error is likely to go away by itself:
+// server errors, rate limiting, timeouts and dropped connections. Client
+// errors, such as 401 or 404, are never retried.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if _, ok := errorspkg.Cause(err).(*ImageRejectedError); ok {
+		return false
+	}
+
+	if statusCode, ok := responseStatus(err); ok {
+		return isRetryableStatus(statusCode)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// the connection was closed before the response could be read
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && errors.Is(urlErr.Err, io.EOF) {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests || statusCode == http.StatusRequestTimeout
+}
+
+// responseStatus returns the status code of the registry response that
+// caused the error, if any
+func responseStatus(err error) (int, bool) {
+	if statusCode, ok := registryErrorStatus(err); ok {
+		return statusCode, true
+	}
+
+	var statusErr interface{ StatusCode() int }
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode(), true
+	}
+
+	var responseErr *client.UnexpectedHTTPResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode, true
+	}
+
+	// the status is only reported as the status line, e.g. `503 Service Unavailable`
+	var unexpectedStatusErr *client.UnexpectedHTTPStatusError
+	if errors.As(err, &unexpectedStatusErr) {
+		fields := strings.Fields(unexpectedStatusErr.Status)
+		if len(fields) > 0 {
+			if statusCode, err := strconv.Atoi(fields[0]); err == nil {
+				return statusCode, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// registryErrorStatus returns the status code of the errors that registries
+// describe in their response body
+func registryErrorStatus(err error) (int, bool) {
+	switch registryErr := errorspkg.Cause(err).(type) {
+	case errcode.Error:
+		return registryErr.Code.Descriptor().HTTPStatusCode, true
+	case errcode.ErrorCode:
+		return registryErr.Descriptor().HTTPStatusCode, true
+	case errcode.Errors:
+		if len(registryErr) == 0 {
+			return 0, false
+		}
+		return registryErrorStatus(registryErr[0])
+	}
+
+	return 0, false
+}
@@ -0,0 +1,133 @@
+package source_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher/source"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	errorspkg "github.com/pkg/errors"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	var policy source.RetryPolicy
+
+	BeforeEach(func() {
+		policy = source.RetryPolicy{
+			MaxAttempts: 4,
+			BaseDelay:   100 * time.Millisecond,
+			MaxDelay:    time.Second,
+		}
+	})
+
+	Describe("Delay", func() {
+		It("doubles after each attempt", func() {
+			Expect(policy.Delay(1)).To(Equal(100 * time.Millisecond))
+			Expect(policy.Delay(2)).To(Equal(200 * time.Millisecond))
+			Expect(policy.Delay(3)).To(Equal(400 * time.Millisecond))
+		})
+
+		It("never exceeds the max delay", func() {
+			Expect(policy.Delay(10)).To(Equal(time.Second))
+		})
+
+		Context("when there is jitter", func() {
+			BeforeEach(func() {
+				policy.Jitter = 0.5
+			})
+
+			It("spreads the delay", func() {
+				for i := 0; i < 20; i++ {
+					delay := policy.Delay(2)
+					Expect(delay).To(BeNumerically(">=", 100*time.Millisecond))
+					Expect(delay).To(BeNumerically("<=", 300*time.Millisecond))
+				}
+			})
+		})
+
+		Context("when there is no base delay", func() {
+			BeforeEach(func() {
+				policy.BaseDelay = 0
+			})
+
+			It("retries immediately", func() {
+				Expect(policy.Delay(3)).To(BeZero())
+			})
+		})
+	})
+
+	Describe("ShouldRetry", func() {
+		serverErr := statusError(503)
+
+		It("retries retryable errors", func() {
+			Expect(policy.ShouldRetry(1, serverErr)).To(BeTrue())
+		})
+
+		It("stops after the max attempts", func() {
+			Expect(policy.ShouldRetry(4, serverErr)).To(BeFalse())
+		})
+
+		It("does not retry other errors", func() {
+			Expect(policy.ShouldRetry(1, statusError(404))).To(BeFalse())
+		})
+	})
+
+	Describe("IsRetryable", func() {
+		It("retries server errors and rate limiting", func() {
+			Expect(source.IsRetryable(statusError(500))).To(BeTrue())
+			Expect(source.IsRetryable(errorspkg.Wrap(statusError(429), "fetching registry token"))).To(BeTrue())
+			Expect(source.IsRetryable(&client.UnexpectedHTTPStatusError{Status: "502 Bad Gateway"})).To(BeTrue())
+			Expect(source.IsRetryable(&client.UnexpectedHTTPResponseError{StatusCode: 503})).To(BeTrue())
+		})
+
+		It("does not parse the status from error messages", func() {
+			Expect(source.IsRetryable(errors.New("layer `sha256:503` not found"))).To(BeFalse())
+		})
+
+		It("retries registry errors with a server error status", func() {
+			Expect(source.IsRetryable(errcode.ErrorCodeUnavailable.WithMessage("try later"))).To(BeTrue())
+			Expect(source.IsRetryable(errorspkg.Wrap(errcode.Errors{errcode.ErrorCodeTooManyRequests}, "reading manifest"))).To(BeTrue())
+		})
+
+		It("retries timeouts and dropped connections", func() {
+			Expect(source.IsRetryable(&net.OpError{Op: "dial", Err: timeoutError{}})).To(BeTrue())
+			Expect(source.IsRetryable(errorspkg.Wrap(&net.OpError{Op: "read", Err: syscall.ECONNRESET}, "pinging registry"))).To(BeTrue())
+			Expect(source.IsRetryable(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED})).To(BeTrue())
+			Expect(source.IsRetryable(errorspkg.Wrap(io.ErrUnexpectedEOF, "reading manifest"))).To(BeTrue())
+			Expect(source.IsRetryable(&url.Error{Op: "Get", URL: "https://registry.example.org/v2/", Err: io.EOF})).To(BeTrue())
+		})
+
+		It("does not retry client errors", func() {
+			Expect(source.IsRetryable(statusError(401))).To(BeFalse())
+			Expect(source.IsRetryable(&client.UnexpectedHTTPResponseError{StatusCode: 404})).To(BeFalse())
+			Expect(source.IsRetryable(errcode.Errors{errcode.ErrorCodeUnauthorized.WithMessage("authentication required")})).To(BeFalse())
+			Expect(source.IsRetryable(errcode.Errors{v2.ErrorCodeManifestUnknown})).To(BeFalse())
+		})
+
+		It("does not retry other errors", func() {
+			Expect(source.IsRetryable(&source.ImageRejectedError{Reason: "unsigned"})).To(BeFalse())
+			Expect(source.IsRetryable(errors.New("open /images/busybox: no such file or directory"))).To(BeFalse())
+			Expect(source.IsRetryable(nil)).To(BeFalse())
+		})
+	})
+})
+
+type statusError int
+
+func (e statusError) Error() string   { return fmt.Sprintf("unexpected status %d", int(e)) }
+func (e statusError) StatusCode() int { return int(e) }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,81 @@
+package source
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/containers/image/image"
+	"github.com/containers/image/signature"
+	"github.com/containers/image/types"
+	digestpkg "github.com/opencontainers/go-digest"
+	errorspkg "github.com/pkg/errors"
+)
+
+// ImageRejectedError is returned when the signature policy does not allow the
+// image to be used
+type ImageRejectedError struct {
+	Reason string
+}
+
+func (e *ImageRejectedError) Error() string {
+	return "image rejected by signature policy: " + e.Reason
+}
+
+// upstreamImageSource is an image source of a mirror that refers to the
+// upstream image, so that the policy requirements of the upstream image apply
+// to what the mirror serves
+type upstreamImageSource struct {
+	types.ImageSource
+	reference types.ImageReference
+}
+
+func (s *upstreamImageSource) Reference() types.ImageReference {
+	return s.reference
+}
+
+// checkSignaturePolicy evaluates the signature policy against the image
+// manifest, before any of its layers are downloaded. Signatures are verified
+// with the keys referenced by the policy. Images served by mirrors are
+// evaluated as the upstream image.
+func (s *LayerSource) checkSignaturePolicy(logger lager.Logger, location imageLocation, imageSource types.ImageSource, instanceDigest *digestpkg.Digest) error {
+	if s.signaturePolicy == nil {
+		return nil
+	}
+
+	logger = logger.Session("checking-signature-policy", lager.Data{"registry": location.url.Host})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	if location.url != s.baseImageURL {
+		upstreamRef, err := s.reference(logger, s.baseImageURL)
+		if err != nil {
+			return err
+		}
+		imageSource = &upstreamImageSource{ImageSource: imageSource, reference: upstreamRef}
+	}
+	unparsedImage := image.UnparsedInstance(imageSource, instanceDigest)
+
+	policyContext, err := signature.NewPolicyContext(s.signaturePolicy)
+	if err != nil {
+		return errorspkg.Wrap(err, "creating signature policy context")
+	}
+	defer func() {
+		if err := policyContext.Destroy(); err != nil {
+			logger.Error("destroying-policy-context-failed", err)
+		}
+	}()
+
+	allowed, err := policyContext.IsRunningImageAllowed(context.TODO(), unparsedImage)
+	if err != nil {
+		if _, ok := err.(signature.PolicyRequirementError); ok {
+			return &ImageRejectedError{Reason: err.Error()}
+		}
+		return errorspkg.Wrap(err, "evaluating signature policy")
+	}
+
+	if !allowed {
+		return &ImageRejectedError{Reason: "image is not allowed"}
+	}
+
+	return nil
+}
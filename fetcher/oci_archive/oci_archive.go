@@ -0,0 +1,287 @@
+// Package oci_archive provides a containers/image transport for OCI image
+// layouts that are kept as a single tar file. Unlike the oci-archive
+// transport of containers/image, it does not extract the layout, but streams
+// the blobs straight out of the archive.
+package oci_archive // import "code.cloudfoundry.org/grootfs/fetcher/oci_archive"
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/image"
+	manifestpkg "github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	digestpkg "github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	errorspkg "github.com/pkg/errors"
+)
+
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// Transport is the oci-archive transport
+var Transport = archiveTransport{}
+
+type archiveTransport struct{}
+
+func (t archiveTransport) Name() string {
+	return "oci-archive"
+}
+
+// ParseReference parses `path[:ref]`, where ref is the name of the image in
+// the index of the layout
+func (t archiveTransport) ParseReference(refString string) (types.ImageReference, error) {
+	archivePath, imageRef := refString, ""
+	if i := strings.Index(refString, ":"); i >= 0 {
+		archivePath, imageRef = refString[:i], refString[i+1:]
+	}
+
+	if archivePath == "" {
+		return nil, errorspkg.New("oci archive path cannot be empty")
+	}
+
+	archivePath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "resolving oci archive path")
+	}
+
+	return archiveReference{path: archivePath, image: imageRef}, nil
+}
+
+func (t archiveTransport) ValidatePolicyConfigurationScope(scope string) error {
+	if !strings.HasPrefix(scope, "/") {
+		return errorspkg.Errorf("invalid scope `%s`: must be an absolute path", scope)
+	}
+
+	return nil
+}
+
+type archiveReference struct {
+	path  string
+	image string
+}
+
+func (r archiveReference) Transport() types.ImageTransport {
+	return Transport
+}
+
+func (r archiveReference) StringWithinTransport() string {
+	if r.image == "" {
+		return r.path
+	}
+
+	return r.path + ":" + r.image
+}
+
+func (r archiveReference) DockerReference() reference.Named {
+	return nil
+}
+
+func (r archiveReference) PolicyConfigurationIdentity() string {
+	return r.StringWithinTransport()
+}
+
+// PolicyConfigurationNamespaces returns the archive path followed by its
+// parent directories, from the closest to the root
+func (r archiveReference) PolicyConfigurationNamespaces() []string {
+	namespaces := []string{}
+	if r.image != "" {
+		namespaces = append(namespaces, r.path)
+	}
+
+	for dir := filepath.Dir(r.path); dir != "/"; dir = filepath.Dir(dir) {
+		namespaces = append(namespaces, dir)
+	}
+
+	return namespaces
+}
+
+func (r archiveReference) NewImage(ctx context.Context, sys *types.SystemContext) (types.ImageCloser, error) {
+	src, err := r.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+
+	return image.FromSource(ctx, sys, src)
+}
+
+func (r archiveReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	if _, err := os.Stat(r.path); err != nil {
+		return nil, errorspkg.Wrap(err, "opening oci archive")
+	}
+
+	return &archiveSource{ref: r}, nil
+}
+
+func (r archiveReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
+	return errorspkg.New("deleting images from oci archives is not supported")
+}
+
+type archiveSource struct {
+	ref archiveReference
+}
+
+func (s *archiveSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *archiveSource) Close() error {
+	return nil
+}
+
+func (s *archiveSource) GetManifest(ctx context.Context, instanceDigest *digestpkg.Digest) ([]byte, string, error) {
+	if instanceDigest != nil {
+		contents, err := s.readBlob(*instanceDigest)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return contents, manifestMediaType(contents), nil
+	}
+
+	descriptor, err := s.imageDescriptor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	contents, err := s.readBlob(descriptor.Digest)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mediaType := descriptor.MediaType
+	if mediaType == "" {
+		mediaType = manifestMediaType(contents)
+	}
+
+	return contents, mediaType, nil
+}
+
+func (s *archiveSource) GetBlob(ctx context.Context, info types.BlobInfo) (io.ReadCloser, int64, error) {
+	if err := info.Digest.Validate(); err != nil {
+		return nil, 0, errorspkg.Wrap(err, "invalid blob digest")
+	}
+
+	return s.openEntry(blobPath(info.Digest))
+}
+
+func (s *archiveSource) GetSignatures(ctx context.Context, instanceDigest *digestpkg.Digest) ([][]byte, error) {
+	return [][]byte{}, nil
+}
+
+func (s *archiveSource) LayerInfosForCopy(ctx context.Context) ([]types.BlobInfo, error) {
+	return nil, nil
+}
+
+// imageDescriptor finds the image in the index of the layout. Without an
+// image name, the index must have a single image.
+func (s *archiveSource) imageDescriptor() (specsv1.Descriptor, error) {
+	contents, err := s.readEntry("index.json")
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	var index specsv1.Index
+	if err := json.Unmarshal(contents, &index); err != nil {
+		return specsv1.Descriptor{}, errorspkg.Wrap(err, "parsing oci archive index")
+	}
+
+	if s.ref.image == "" {
+		if len(index.Manifests) != 1 {
+			return specsv1.Descriptor{}, errorspkg.Errorf("oci archive has %d images, the image name must be given", len(index.Manifests))
+		}
+
+		return index.Manifests[0], nil
+	}
+
+	for _, descriptor := range index.Manifests {
+		if descriptor.Annotations[refNameAnnotation] == s.ref.image {
+			return descriptor, nil
+		}
+	}
+
+	return specsv1.Descriptor{}, errorspkg.Errorf("image `%s` not found in oci archive", s.ref.image)
+}
+
+func (s *archiveSource) readBlob(digest digestpkg.Digest) ([]byte, error) {
+	if err := digest.Validate(); err != nil {
+		return nil, errorspkg.Wrap(err, "invalid blob digest")
+	}
+
+	return s.readEntry(blobPath(digest))
+}
+
+func (s *archiveSource) readEntry(name string) ([]byte, error) {
+	entry, _, err := s.openEntry(name)
+	if err != nil {
+		return nil, err
+	}
+	defer entry.Close()
+
+	return ioutil.ReadAll(entry)
+}
+
+// openEntry streams a single file out of the archive. The headers of the
+// entries before it are read, but their contents are skipped.
+func (s *archiveSource) openEntry(name string) (io.ReadCloser, int64, error) {
+	archive, err := os.Open(s.ref.path)
+	if err != nil {
+		return nil, 0, errorspkg.Wrap(err, "opening oci archive")
+	}
+
+	tarReader := tar.NewReader(archive)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			archive.Close()
+			return nil, 0, errorspkg.Errorf("`%s` not found in oci archive", name)
+		}
+		if err != nil {
+			archive.Close()
+			return nil, 0, errorspkg.Wrap(err, "reading oci archive")
+		}
+
+		if path.Clean(strings.TrimPrefix(header.Name, "./")) == name {
+			return &archiveEntry{Reader: tarReader, archive: archive}, header.Size, nil
+		}
+	}
+}
+
+func blobPath(digest digestpkg.Digest) string {
+	return path.Join("blobs", digest.Algorithm().String(), digest.Hex())
+}
+
+// manifestMediaType reads the media type of a manifest or index, which is
+// optional in OCI manifests
+func manifestMediaType(contents []byte) string {
+	var manifest struct {
+		MediaType string          `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(contents, &manifest); err == nil {
+		if manifest.MediaType != "" {
+			return manifest.MediaType
+		}
+		if manifest.Manifests != nil {
+			return specsv1.MediaTypeImageIndex
+		}
+	}
+
+	return manifestpkg.GuessMIMEType(contents)
+}
+
+type archiveEntry struct {
+	io.Reader
+	archive *os.File
+}
+
+func (e *archiveEntry) Close() error {
+	return e.archive.Close()
+}
@@ -0,0 +1,13 @@
+package oci_archive_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestOciArchive(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OCI Archive Suite")
+}
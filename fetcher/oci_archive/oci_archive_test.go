@@ -0,0 +1,167 @@
+package oci_archive_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/grootfs/fetcher/oci_archive"
+	"github.com/containers/image/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	digestpkg "github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var _ = Describe("OCI archive transport", func() {
+	const (
+		manifestDigest = "sha256:a68a8bf77d0e1c0630dec7f829889a4d607bc151fe31827cf589558560336c46"
+		configDigest   = "sha256:18c5d86cd64efe05ea5e2e18de4b48848a4f5a425235097f34e17f6aca81f4f3"
+		layerDigest    = "sha256:e8fbc9c5bf16d3409f75a9d0f0751d90ab562565335b793673e906efcc7bd7c8"
+	)
+
+	var (
+		archivePath string
+		imageName   string
+		imageSource types.ImageSource
+	)
+
+	BeforeEach(func() {
+		workDir, err := os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		layoutPath := filepath.Join(workDir, "../../integration/assets/oci-test-image/opq-whiteouts-busybox")
+
+		archiveFile, err := ioutil.TempFile("", "oci-archive")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(archiveFile.Close()).To(Succeed())
+		archivePath = archiveFile.Name()
+
+		cmd := exec.Command("tar", "-C", layoutPath, "-cf", archivePath, ".")
+		Expect(cmd.Run()).To(Succeed())
+
+		imageName = ""
+	})
+
+	JustBeforeEach(func() {
+		refString := archivePath
+		if imageName != "" {
+			refString += ":" + imageName
+		}
+
+		ref, err := oci_archive.Transport.ParseReference(refString)
+		Expect(err).NotTo(HaveOccurred())
+
+		imageSource, err = ref.NewImageSource(context.TODO(), &types.SystemContext{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(imageSource.Close()).To(Succeed())
+		Expect(os.RemoveAll(archivePath)).To(Succeed())
+	})
+
+	Describe("ParseReference", func() {
+		It("keeps the path and the image name", func() {
+			ref, err := oci_archive.Transport.ParseReference("/images/busybox.tar:latest")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ref.StringWithinTransport()).To(Equal("/images/busybox.tar:latest"))
+			Expect(ref.Transport().Name()).To(Equal("oci-archive"))
+			Expect(ref.PolicyConfigurationNamespaces()).To(Equal([]string{"/images/busybox.tar", "/images"}))
+		})
+
+		It("fails without a path", func() {
+			_, err := oci_archive.Transport.ParseReference(":latest")
+			Expect(err).To(MatchError(ContainSubstring("path cannot be empty")))
+		})
+	})
+
+	Describe("NewImageSource", func() {
+		It("fails when the archive does not exist", func() {
+			ref, err := oci_archive.Transport.ParseReference("/not-here.tar")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ref.NewImageSource(context.TODO(), &types.SystemContext{})
+			Expect(err).To(MatchError(ContainSubstring("opening oci archive")))
+		})
+	})
+
+	Describe("GetManifest", func() {
+		It("returns the manifest of the only image", func() {
+			contents, mediaType, err := imageSource.GetManifest(context.TODO(), nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mediaType).To(Equal(specsv1.MediaTypeImageManifest))
+			Expect(digestpkg.FromBytes(contents).String()).To(Equal(manifestDigest))
+		})
+
+		Context("when the image is named", func() {
+			BeforeEach(func() {
+				imageName = "latest"
+			})
+
+			It("returns its manifest", func() {
+				contents, _, err := imageSource.GetManifest(context.TODO(), nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(digestpkg.FromBytes(contents).String()).To(Equal(manifestDigest))
+			})
+		})
+
+		Context("when the named image is not in the archive", func() {
+			BeforeEach(func() {
+				imageName = "not-here"
+			})
+
+			It("returns an error", func() {
+				_, _, err := imageSource.GetManifest(context.TODO(), nil)
+				Expect(err).To(MatchError("image `not-here` not found in oci archive"))
+			})
+		})
+
+		Context("when an instance digest is given", func() {
+			It("returns that manifest", func() {
+				instanceDigest := digestpkg.Digest(manifestDigest)
+				contents, _, err := imageSource.GetManifest(context.TODO(), &instanceDigest)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(digestpkg.FromBytes(contents).String()).To(Equal(manifestDigest))
+			})
+		})
+	})
+
+	Describe("GetBlob", func() {
+		It("streams the blob out of the archive", func() {
+			blob, size, err := imageSource.GetBlob(context.TODO(), types.BlobInfo{Digest: layerDigest})
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			contents, err := ioutil.ReadAll(blob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(172)))
+			Expect(digestpkg.FromBytes(contents).String()).To(Equal(layerDigest))
+		})
+
+		It("returns the config blob", func() {
+			blob, _, err := imageSource.GetBlob(context.TODO(), types.BlobInfo{Digest: configDigest})
+			Expect(err).NotTo(HaveOccurred())
+			defer blob.Close()
+
+			contents, err := ioutil.ReadAll(blob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(digestpkg.FromBytes(contents).String()).To(Equal(configDigest))
+		})
+
+		Context("when the blob is not in the archive", func() {
+			It("returns an error", func() {
+				_, _, err := imageSource.GetBlob(context.TODO(), types.BlobInfo{Digest: digestpkg.FromString("not-here")})
+				Expect(err).To(MatchError(ContainSubstring("not found in oci archive")))
+			})
+		})
+
+		Context("when the digest is invalid", func() {
+			It("returns an error", func() {
+				_, _, err := imageSource.GetBlob(context.TODO(), types.BlobInfo{Digest: "sha256:steamed-blob"})
+				Expect(err).To(MatchError(ContainSubstring("invalid blob digest")))
+			})
+		})
+	})
+})
@@ -8,19 +8,37 @@ import (
 	"net/url"
 	"os"
 
+	"code.cloudfoundry.org/grootfs/fetcher/compression"
 	"code.cloudfoundry.org/grootfs/groot"
 	"code.cloudfoundry.org/lager"
 	errorspkg "github.com/pkg/errors"
 )
 
+//go:generate counterfeiter . DigestCache
+
+// DigestCache remembers the digest of the tarball until it changes
+type DigestCache interface {
+	Get(logger lager.Logger, filePath string, stat os.FileInfo) (string, bool)
+	Set(logger lager.Logger, filePath string, stat os.FileInfo, digest string) error
+}
+
 type TarFetcher struct {
 	baseImagePath string
+	digestCache   DigestCache
 }
 
 func NewTarFetcher(baseImageURL *url.URL) *TarFetcher {
 	return &TarFetcher{baseImagePath: baseImageURL.String()}
 }
 
+// WithContentChainIDs makes the chain ID derive from the sha256 of the
+// tarball, instead of its path and modification time. The digest is cached,
+// so that the tarball is only hashed again when it changes.
+func (l *TarFetcher) WithContentChainIDs(digestCache DigestCache) *TarFetcher {
+	l.digestCache = digestCache
+	return l
+}
+
 func (l *TarFetcher) StreamBlob(logger lager.Logger, layerInfo groot.LayerInfo) (io.ReadCloser, int64, error) {
 	logger = logger.Session("stream-blob", lager.Data{
 		"baseImagePath": l.baseImagePath,
@@ -38,12 +56,21 @@ func (l *TarFetcher) StreamBlob(logger lager.Logger, layerInfo groot.LayerInfo)
 	}
 
 	logger.Debug("opening-tar", lager.Data{"baseImagePath": l.baseImagePath})
-	stream, err := os.Open(l.baseImagePath)
+	file, err := os.Open(l.baseImagePath)
 	if err != nil {
 		return nil, 0, errorspkg.Wrap(err, "reading local image")
 	}
 
-	return stream, 0, nil
+	tarCompression, stream := compression.Detect(file)
+	logger.Debug("detected-compression", lager.Data{"compression": tarCompression})
+
+	tarStream, err := compression.Decompress(stream, tarCompression)
+	if err != nil {
+		file.Close()
+		return nil, 0, errorspkg.Wrap(err, "decompressing local image")
+	}
+
+	return &decompressedFile{ReadCloser: tarStream, file: file}, 0, nil
 }
 
 func (l *TarFetcher) BaseImageInfo(logger lager.Logger) (groot.BaseImageInfo, error) {
@@ -57,17 +84,36 @@ func (l *TarFetcher) BaseImageInfo(logger lager.Logger) (groot.BaseImageInfo, er
 			errorspkg.Wrap(err, "fetching image timestamp")
 	}
 
+	chainID := l.generateChainID(stat.ModTime().UnixNano())
+	if l.digestCache != nil {
+		chainID, err = l.contentChainID(logger, stat)
+		if err != nil {
+			return groot.BaseImageInfo{}, err
+		}
+	}
+
 	return groot.BaseImageInfo{
 		LayerInfos: []groot.LayerInfo{
 			groot.LayerInfo{
 				BlobID:        l.baseImagePath,
 				ParentChainID: "",
-				ChainID:       l.generateChainID(stat.ModTime().UnixNano()),
+				ChainID:       chainID,
 			},
 		},
 	}, nil
 }
 
+// decompressedFile closes the file once the decompressed stream is closed
+type decompressedFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (f *decompressedFile) Close() error {
+	f.ReadCloser.Close()
+	return f.file.Close()
+}
+
 func (l *TarFetcher) Close() error {
 	return nil
 }
@@ -77,6 +123,32 @@ func (l *TarFetcher) generateChainID(timestamp int64) string {
 	return hex.EncodeToString(shaSum[:])
 }
 
+func (l *TarFetcher) contentChainID(logger lager.Logger, stat os.FileInfo) (string, error) {
+	if digest, ok := l.digestCache.Get(logger, l.baseImagePath, stat); ok {
+		logger.Debug("using-cached-digest", lager.Data{"digest": digest})
+		return digest, nil
+	}
+
+	logger.Debug("hashing-tarball")
+	file, err := os.Open(l.baseImagePath)
+	if err != nil {
+		return "", errorspkg.Wrap(err, "opening local image")
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", errorspkg.Wrap(err, "hashing local image")
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	if err := l.digestCache.Set(logger, l.baseImagePath, stat, digest); err != nil {
+		logger.Error("caching-digest-failed", err)
+	}
+
+	return digest, nil
+}
+
 func (l *TarFetcher) validateBaseImage() error {
 	stat, err := os.Stat(l.baseImagePath)
 	if err != nil {
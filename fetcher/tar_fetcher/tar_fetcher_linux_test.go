@@ -2,6 +2,10 @@ package tar_fetcher_test
 
 import (
 	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/url"
@@ -11,12 +15,15 @@ import (
 	"time"
 
 	fetcherpkg "code.cloudfoundry.org/grootfs/fetcher/tar_fetcher"
+	"code.cloudfoundry.org/grootfs/fetcher/tar_fetcher/tar_fetcherfakes"
 	"code.cloudfoundry.org/grootfs/groot"
 	"code.cloudfoundry.org/grootfs/integration"
+	"github.com/klauspost/compress/zstd"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/opencontainers/image-spec/specs-go/v1"
 	. "github.com/st3v/glager"
+	"github.com/ulikunitz/xz"
 )
 
 var _ = Describe("Tar Fetcher", func() {
@@ -75,6 +82,80 @@ var _ = Describe("Tar Fetcher", func() {
 			))
 		})
 
+		Context("when the tarball is compressed", func() {
+			var compressedPath string
+
+			compressedTarball := func(extension string, compress func(io.Writer) io.WriteCloser) string {
+				tarball, err := ioutil.ReadFile(baseImagePath)
+				Expect(err).NotTo(HaveOccurred())
+
+				compressedFile, err := ioutil.TempFile("", "image-*.tar."+extension)
+				Expect(err).NotTo(HaveOccurred())
+				defer compressedFile.Close()
+
+				writer := compress(compressedFile)
+				_, err = writer.Write(tarball)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(writer.Close()).To(Succeed())
+
+				return compressedFile.Name()
+			}
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(compressedPath)).To(Succeed())
+			})
+
+			itDecompressesTheTarball := func() {
+				It("decompresses the tarball", func() {
+					stream, _, err := fetcher.StreamBlob(logger, groot.LayerInfo{})
+					Expect(err).ToNot(HaveOccurred())
+					defer stream.Close()
+
+					entries := streamTar(tar.NewReader(stream))
+					Expect(entries).To(HaveLen(2))
+					Expect(entries[1].header.Name).To(Equal("./a_file"))
+					Expect(string(entries[1].contents)).To(Equal("hello-world"))
+				})
+			}
+
+			Context("with gzip", func() {
+				BeforeEach(func() {
+					compressedPath = compressedTarball("gz", func(w io.Writer) io.WriteCloser {
+						return gzip.NewWriter(w)
+					})
+					baseImageURL, _ = url.Parse(compressedPath)
+				})
+
+				itDecompressesTheTarball()
+			})
+
+			Context("with xz", func() {
+				BeforeEach(func() {
+					compressedPath = compressedTarball("xz", func(w io.Writer) io.WriteCloser {
+						writer, err := xz.NewWriter(w)
+						Expect(err).NotTo(HaveOccurred())
+						return writer
+					})
+					baseImageURL, _ = url.Parse(compressedPath)
+				})
+
+				itDecompressesTheTarball()
+			})
+
+			Context("with zstd", func() {
+				BeforeEach(func() {
+					compressedPath = compressedTarball("zst", func(w io.Writer) io.WriteCloser {
+						writer, err := zstd.NewWriter(w)
+						Expect(err).NotTo(HaveOccurred())
+						return writer
+					})
+					baseImageURL, _ = url.Parse(compressedPath)
+				})
+
+				itDecompressesTheTarball()
+			})
+		})
+
 		Context("when the source is a directory", func() {
 			BeforeEach(func() {
 				tempDir, err := ioutil.TempDir("", "")
@@ -147,6 +228,85 @@ var _ = Describe("Tar Fetcher", func() {
 				Expect(imageInfoErr).To(MatchError(ContainSubstring("fetching image timestamp")))
 			})
 		})
+
+		Context("when content chain IDs are enabled", func() {
+			var digestCache *tar_fetcherfakes.FakeDigestCache
+
+			BeforeEach(func() {
+				digestCache = new(tar_fetcherfakes.FakeDigestCache)
+			})
+
+			JustBeforeEach(func() {
+				fetcher = fetcherpkg.NewTarFetcher(baseImageURL).WithContentChainIDs(digestCache)
+				baseImageInfo, imageInfoErr = fetcher.BaseImageInfo(logger)
+			})
+
+			It("uses the sha256 of the tarball as the chain ID", func() {
+				Expect(imageInfoErr).NotTo(HaveOccurred())
+
+				contents, err := ioutil.ReadFile(baseImagePath)
+				Expect(err).NotTo(HaveOccurred())
+				shaSum := sha256.Sum256(contents)
+				Expect(baseImageInfo.LayerInfos[0].ChainID).To(Equal(hex.EncodeToString(shaSum[:])))
+			})
+
+			It("caches the digest", func() {
+				Expect(digestCache.SetCallCount()).To(Equal(1))
+				_, filePath, stat, digest := digestCache.SetArgsForCall(0)
+				Expect(filePath).To(Equal(baseImagePath))
+				Expect(stat.Name()).To(Equal(filepath.Base(baseImagePath)))
+				Expect(digest).To(Equal(baseImageInfo.LayerInfos[0].ChainID))
+			})
+
+			It("does not change the chain ID when the tarball is only touched", func() {
+				later := time.Now().Add(time.Hour)
+				Expect(os.Chtimes(baseImagePath, later, later)).To(Succeed())
+
+				newBaseImageInfo, err := fetcher.BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newBaseImageInfo.LayerInfos[0].ChainID).To(Equal(baseImageInfo.LayerInfos[0].ChainID))
+			})
+
+			It("generates the same chain ID for the same content at another path", func() {
+				contents, err := ioutil.ReadFile(baseImagePath)
+				Expect(err).NotTo(HaveOccurred())
+				copyPath := baseImagePath + "-copy"
+				Expect(ioutil.WriteFile(copyPath, contents, 0600)).To(Succeed())
+				defer os.Remove(copyPath)
+
+				copyURL, err := url.Parse(copyPath)
+				Expect(err).NotTo(HaveOccurred())
+				copyInfo, err := fetcherpkg.NewTarFetcher(copyURL).WithContentChainIDs(digestCache).BaseImageInfo(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(copyInfo.LayerInfos[0].ChainID).To(Equal(baseImageInfo.LayerInfos[0].ChainID))
+			})
+
+			Context("when the digest is cached", func() {
+				BeforeEach(func() {
+					digestCache.GetReturns("cached-digest", true)
+				})
+
+				It("does not hash the tarball again", func() {
+					Expect(imageInfoErr).NotTo(HaveOccurred())
+					Expect(baseImageInfo.LayerInfos[0].ChainID).To(Equal("cached-digest"))
+					Expect(digestCache.SetCallCount()).To(BeZero())
+
+					_, filePath, _ := digestCache.GetArgsForCall(0)
+					Expect(filePath).To(Equal(baseImagePath))
+				})
+			})
+
+			Context("when caching the digest fails", func() {
+				BeforeEach(func() {
+					digestCache.SetReturns(errors.New("disk full"))
+				})
+
+				It("still returns the chain ID", func() {
+					Expect(imageInfoErr).NotTo(HaveOccurred())
+					Expect(baseImageInfo.LayerInfos[0].ChainID).NotTo(BeEmpty())
+				})
+			})
+		})
 	})
 })
 
@@ -0,0 +1,182 @@
+// Package throttle limits the rate at which blobs are downloaded, either per
+// create or across all the creates sharing a store.
+package throttle // import "code.cloudfoundry.org/grootfs/fetcher/throttle"
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	errorspkg "github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Limiter hands out the bytes that can be transferred. WaitN accounts for n
+// bytes and blocks until the rate allows them.
+type Limiter interface {
+	WaitN(n int) error
+}
+
+// bucket is the state of a token bucket holding up to a second worth of
+// bytes. Tokens go negative when more bytes are taken than there are, and
+// the caller waits until the debt has been refilled.
+type bucket struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"`
+}
+
+func (b *bucket) take(n int64, bytesPerSecond int64, now time.Time) time.Duration {
+	if b.Updated == 0 {
+		b.Tokens = float64(bytesPerSecond)
+	} else {
+		elapsed := now.Sub(time.Unix(0, b.Updated)).Seconds()
+		if elapsed > 0 {
+			b.Tokens += elapsed * float64(bytesPerSecond)
+		}
+		if b.Tokens > float64(bytesPerSecond) {
+			b.Tokens = float64(bytesPerSecond)
+		}
+	}
+	b.Updated = now.UnixNano()
+
+	b.Tokens -= float64(n)
+	if b.Tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.Tokens / float64(bytesPerSecond) * float64(time.Second))
+}
+
+// TokenBucket limits the bytes transferred by a single process, across all
+// the readers sharing it
+type TokenBucket struct {
+	bytesPerSecond int64
+	bucket         bucket
+	mutex          sync.Mutex
+}
+
+func NewTokenBucket(bytesPerSecond int64) *TokenBucket {
+	return &TokenBucket{bytesPerSecond: bytesPerSecond}
+}
+
+func (t *TokenBucket) WaitN(n int) error {
+	t.mutex.Lock()
+	wait := t.bucket.take(int64(n), t.bytesPerSecond, time.Now())
+	t.mutex.Unlock()
+
+	time.Sleep(wait)
+	return nil
+}
+
+// reservationsPerSecond is how often a SharedTokenBucket takes tokens from
+// the shared state at full rate, rather than locking the state file for
+// every read
+const reservationsPerSecond = 10
+
+// SharedTokenBucket limits the bytes transferred by all the processes using
+// the same state file. The file is locked while the bucket is updated, and
+// tokens are taken from it in batches that the process then hands out.
+type SharedTokenBucket struct {
+	path           string
+	bytesPerSecond int64
+	// reserved tokens have been taken from the state, and can be used once
+	// readyAt has passed
+	reserved int64
+	readyAt  time.Time
+	mutex    sync.Mutex
+}
+
+func NewSharedTokenBucket(path string, bytesPerSecond int64) *SharedTokenBucket {
+	return &SharedTokenBucket{path: path, bytesPerSecond: bytesPerSecond}
+}
+
+func (t *SharedTokenBucket) WaitN(n int) error {
+	t.mutex.Lock()
+	if t.reserved < int64(n) {
+		batch := t.bytesPerSecond / reservationsPerSecond
+		if needed := int64(n) - t.reserved; batch < needed {
+			batch = needed
+		}
+
+		wait, err := t.take(batch)
+		if err != nil {
+			t.mutex.Unlock()
+			return err
+		}
+		t.reserved += batch
+		t.readyAt = time.Now().Add(wait)
+	}
+	t.reserved -= int64(n)
+	wait := time.Until(t.readyAt)
+	t.mutex.Unlock()
+
+	time.Sleep(wait)
+	return nil
+}
+
+func (t *SharedTokenBucket) take(n int64) (time.Duration, error) {
+	stateFile, err := os.OpenFile(t.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return 0, errorspkg.Wrap(err, "opening bandwidth state")
+	}
+	defer stateFile.Close()
+
+	if err := unix.Flock(int(stateFile.Fd()), unix.LOCK_EX); err != nil {
+		return 0, errorspkg.Wrap(err, "locking bandwidth state")
+	}
+	defer unix.Flock(int(stateFile.Fd()), unix.LOCK_UN)
+
+	contents, err := ioutil.ReadAll(stateFile)
+	if err != nil {
+		return 0, errorspkg.Wrap(err, "reading bandwidth state")
+	}
+
+	var state bucket
+	if len(contents) > 0 {
+		// a corrupted state only resets the bucket
+		_ = json.Unmarshal(contents, &state)
+	}
+
+	wait := state.take(n, t.bytesPerSecond, time.Now())
+
+	contents, err = json.Marshal(state)
+	if err != nil {
+		return 0, errorspkg.Wrap(err, "encoding bandwidth state")
+	}
+	if err := stateFile.Truncate(0); err != nil {
+		return 0, errorspkg.Wrap(err, "writing bandwidth state")
+	}
+	if _, err := stateFile.WriteAt(contents, 0); err != nil {
+		return 0, errorspkg.Wrap(err, "writing bandwidth state")
+	}
+
+	return wait, nil
+}
+
+type reader struct {
+	reader   io.Reader
+	limiters []Limiter
+}
+
+// NewReader returns a reader that is only as fast as all the limiters allow
+func NewReader(r io.Reader, limiters ...Limiter) io.Reader {
+	return &reader{reader: r, limiters: limiters}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+
+	for _, limiter := range r.limiters {
+		if limitErr := limiter.WaitN(n); limitErr != nil {
+			return n, errorspkg.Wrap(limitErr, "limiting download bandwidth")
+		}
+	}
+
+	return n, err
+}
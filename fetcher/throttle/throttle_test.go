@@ -0,0 +1,159 @@
+package throttle_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/fetcher/throttle"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Throttle", func() {
+	const bytesPerSecond = 100 * 1024
+
+	var (
+		server  *httptest.Server
+		payload []byte
+	)
+
+	BeforeEach(func() {
+		// a second worth of burst, and half a second more
+		payload = bytes.Repeat([]byte("a"), bytesPerSecond*3/2)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(payload)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	download := func(limiters ...throttle.Limiter) time.Duration {
+		start := time.Now()
+		resp, err := http.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		contents, err := ioutil.ReadAll(throttle.NewReader(resp.Body, limiters...))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contents).To(Equal(payload))
+
+		return time.Since(start)
+	}
+
+	Describe("TokenBucket", func() {
+		It("limits the download rate", func() {
+			Expect(download(throttle.NewTokenBucket(bytesPerSecond))).To(BeNumerically(">=", 450*time.Millisecond))
+		})
+
+		It("does not slow down downloads within the limit", func() {
+			Expect(download(throttle.NewTokenBucket(bytesPerSecond * 4))).To(BeNumerically("<", 250*time.Millisecond))
+		})
+
+		It("is shared by the readers using it", func() {
+			limiter := throttle.NewTokenBucket(bytesPerSecond * 2)
+
+			var wg sync.WaitGroup
+			durations := make([]time.Duration, 2)
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					durations[i] = download(limiter)
+				}(i)
+			}
+			wg.Wait()
+
+			// together, they went over the limit by half a second
+			Expect(longest(durations)).To(BeNumerically(">=", 450*time.Millisecond))
+		})
+	})
+
+	Describe("SharedTokenBucket", func() {
+		var (
+			locksDir  string
+			statePath string
+		)
+
+		BeforeEach(func() {
+			var err error
+			locksDir, err = ioutil.TempDir("", "locks")
+			Expect(err).NotTo(HaveOccurred())
+			statePath = filepath.Join(locksDir, "download-bandwidth")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(locksDir)).To(Succeed())
+		})
+
+		It("limits the download rate", func() {
+			Expect(download(throttle.NewSharedTokenBucket(statePath, bytesPerSecond))).To(BeNumerically(">=", 450*time.Millisecond))
+		})
+
+		It("shares the limit between the buckets using the same state", func() {
+			var wg sync.WaitGroup
+			durations := make([]time.Duration, 2)
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					durations[i] = download(throttle.NewSharedTokenBucket(statePath, bytesPerSecond*2))
+				}(i)
+			}
+			wg.Wait()
+
+			// together, they went over the limit by half a second
+			Expect(longest(durations)).To(BeNumerically(">=", 450*time.Millisecond))
+		})
+
+		It("takes the tokens from the state in batches", func() {
+			limiter := throttle.NewSharedTokenBucket(statePath, bytesPerSecond)
+			Expect(limiter.WaitN(1)).To(Succeed())
+			state, err := ioutil.ReadFile(statePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state).To(ContainSubstring(`"tokens":%d`, bytesPerSecond*9/10))
+
+			Expect(limiter.WaitN(1024)).To(Succeed())
+			Expect(ioutil.ReadFile(statePath)).To(Equal(state))
+		})
+
+		Context("when the state is corrupted", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(statePath, []byte("not-json"), 0600)).To(Succeed())
+			})
+
+			It("starts over", func() {
+				Expect(download(throttle.NewSharedTokenBucket(statePath, bytesPerSecond))).To(BeNumerically(">=", 450*time.Millisecond))
+			})
+		})
+
+		Context("when the state cannot be opened", func() {
+			It("fails the read", func() {
+				limiter := throttle.NewSharedTokenBucket(filepath.Join(locksDir, "not-here", "state"), bytesPerSecond)
+				_, err := io.Copy(ioutil.Discard, throttle.NewReader(bytes.NewReader(payload), limiter))
+				Expect(err).To(MatchError(ContainSubstring("opening bandwidth state")))
+			})
+		})
+	})
+})
+
+func longest(durations []time.Duration) time.Duration {
+	longest := durations[0]
+	for _, duration := range durations {
+		if duration > longest {
+			longest = duration
+		}
+	}
+
+	return longest
+}
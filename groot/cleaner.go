@@ -12,11 +12,23 @@ type Cleaner interface {
 	Clean(logger lager.Logger, cacheSize int64) (bool, error)
 }
 
+//go:generate counterfeiter . BlobCache
+type BlobCache interface {
+	Trim(logger lager.Logger) error
+}
+
+//go:generate counterfeiter . PartialBlobs
+type PartialBlobs interface {
+	RemoveStale(logger lager.Logger) error
+}
+
 type cleaner struct {
 	storeMeasurer    StoreMeasurer
 	garbageCollector GarbageCollector
 	locksmith        Locksmith
 	metricsEmitter   MetricsEmitter
+	blobCache        BlobCache
+	partialBlobs     PartialBlobs
 }
 
 func IamCleaner(locksmith Locksmith, sm StoreMeasurer,
@@ -30,6 +42,19 @@ func IamCleaner(locksmith Locksmith, sm StoreMeasurer,
 	}
 }
 
+// WithBlobCache makes the cleaner keep the blob cache within its size budget
+func (c *cleaner) WithBlobCache(blobCache BlobCache) *cleaner {
+	c.blobCache = blobCache
+	return c
+}
+
+// WithPartialBlobs makes the cleaner remove the interrupted downloads that
+// were not resumed in time
+func (c *cleaner) WithPartialBlobs(partialBlobs PartialBlobs) *cleaner {
+	c.partialBlobs = partialBlobs
+	return c
+}
+
 func (c *cleaner) Clean(logger lager.Logger, threshold int64) (bool, error) {
 	logger = logger.Session("groot-cleaning")
 	logger.Info("starting")
@@ -37,6 +62,18 @@ func (c *cleaner) Clean(logger lager.Logger, threshold int64) (bool, error) {
 	defer c.metricsEmitter.TryEmitDurationFrom(logger, MetricImageCleanTime, time.Now())
 	defer logger.Info("ending")
 
+	if c.blobCache != nil {
+		if err := c.blobCache.Trim(logger); err != nil {
+			logger.Error("trimming-blob-cache-failed", err)
+		}
+	}
+
+	if c.partialBlobs != nil {
+		if err := c.partialBlobs.RemoveStale(logger); err != nil {
+			logger.Error("removing-stale-partial-blobs-failed", err)
+		}
+	}
+
 	if threshold > 0 {
 		committedQuota, err := c.storeMeasurer.CommittedQuota(logger)
 		if err != nil {
@@ -163,6 +163,75 @@ var _ = Describe("Cleaner", func() {
 			})
 		})
 
+		Context("when a blob cache is used", func() {
+			var fakeBlobCache *grootfakes.FakeBlobCache
+
+			BeforeEach(func() {
+				fakeBlobCache = new(grootfakes.FakeBlobCache)
+				cleaner = groot.IamCleaner(fakeLocksmith, fakeStoreMeasurer,
+					fakeGarbageCollector, fakeMetricsEmitter).WithBlobCache(fakeBlobCache)
+			})
+
+			It("trims the blob cache", func() {
+				_, err := cleaner.Clean(logger, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeBlobCache.TrimCallCount()).To(Equal(1))
+			})
+
+			Context("when the threshold is not reached", func() {
+				BeforeEach(func() {
+					fakeStoreMeasurer.TotalVolumesSizeReturns(10, nil)
+				})
+
+				It("still trims the blob cache", func() {
+					noop, err := cleaner.Clean(logger, 1000)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(noop).To(BeTrue())
+					Expect(fakeBlobCache.TrimCallCount()).To(Equal(1))
+				})
+			})
+
+			Context("when trimming the blob cache fails", func() {
+				BeforeEach(func() {
+					fakeBlobCache.TrimReturns(errors.New("failed to trim"))
+				})
+
+				It("still collects the garbage", func() {
+					_, err := cleaner.Clean(logger, 0)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeGarbageCollector.CollectCallCount()).To(Equal(1))
+				})
+			})
+		})
+
+		Context("when partial blobs are kept", func() {
+			var fakePartialBlobs *grootfakes.FakePartialBlobs
+
+			BeforeEach(func() {
+				fakePartialBlobs = new(grootfakes.FakePartialBlobs)
+				cleaner = groot.IamCleaner(fakeLocksmith, fakeStoreMeasurer,
+					fakeGarbageCollector, fakeMetricsEmitter).WithPartialBlobs(fakePartialBlobs)
+			})
+
+			It("removes the stale partial blobs", func() {
+				_, err := cleaner.Clean(logger, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakePartialBlobs.RemoveStaleCallCount()).To(Equal(1))
+			})
+
+			Context("when removing the partial blobs fails", func() {
+				BeforeEach(func() {
+					fakePartialBlobs.RemoveStaleReturns(errors.New("failed to remove"))
+				})
+
+				It("still collects the garbage", func() {
+					_, err := cleaner.Clean(logger, 0)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeGarbageCollector.CollectCallCount()).To(Equal(1))
+				})
+			})
+		})
+
 		Context("when a threshold is provided", func() {
 			var threshold int64
 
@@ -67,7 +67,7 @@ func (c *Creator) Create(logger lager.Logger, spec CreateSpec) (info ImageInfo,
 		return ImageInfo{}, errorspkg.Errorf("image for id `%s` already exists", spec.ID)
 	}
 
-	ownerUid, ownerGid := c.parseOwner(spec.UIDMappings, spec.GIDMappings)
+	ownerUid, ownerGid := parseOwner(spec.UIDMappings, spec.GIDMappings)
 	baseImageSpec := BaseImageSpec{
 		DiskLimit:                 spec.DiskLimit,
 		ExcludeBaseImageFromQuota: spec.ExcludeBaseImageFromQuota,
@@ -127,6 +127,11 @@ func (c *Creator) Create(logger lager.Logger, spec CreateSpec) (info ImageInfo,
 		return ImageInfo{}, err
 	}
 
+	if baseImageInfo.Platform.OS != "" || baseImageInfo.Platform.Architecture != "" {
+		platform := baseImageInfo.Platform
+		image.Platform = &platform
+	}
+
 	return image, nil
 }
 
@@ -138,7 +143,7 @@ func chainIDs(layerInfos []LayerInfo) []string {
 	return chainIDs
 }
 
-func (c *Creator) parseOwner(uidMappings, gidMappings []IDMappingSpec) (int, int) {
+func parseOwner(uidMappings, gidMappings []IDMappingSpec) (int, int) {
 	uid := os.Getuid()
 	gid := os.Getgid()
 
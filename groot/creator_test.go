@@ -191,6 +191,20 @@ var _ = Describe("Creator", func() {
 			Expect(image).To(Equal(expectedImage))
 		})
 
+		Context("when the platform of the base image is known", func() {
+			BeforeEach(func() {
+				baseImageInfo.Platform = specsv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+			})
+
+			It("returns it with the image", func() {
+				image, err := creator.Create(logger, groot.CreateSpec{
+					BaseImageURL: baseImageUrl,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(image.Platform).To(Equal(&specsv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}))
+			})
+		})
+
 		It("emits metrics for creation", func() {
 			_, err := creator.Create(logger, groot.CreateSpec{
 				ID:           "some-id",
@@ -11,6 +11,7 @@ import (
 const (
 	GlobalLockKey                      = "global-groot-lock"
 	MetricImageCreationTime            = "ImageCreationTime"
+	MetricImagePullTime                = "ImagePullTime"
 	MetricImageDeletionTime            = "ImageDeletionTime"
 	MetricImageStatsTime               = "ImageStatsTime"
 	MetricImageCleanTime               = "ImageCleanTime"
@@ -19,6 +20,15 @@ const (
 	MetricDiskPurgeableCachePercentage = "DiskPurgeableCachePercentage"
 )
 
+const (
+	ProgressManifestResolved = "manifest-resolved"
+	ProgressLayerDownloading = "layer-downloading"
+	ProgressLayerDownloaded  = "layer-downloaded"
+	ProgressUnpackStarted    = "unpack-started"
+	ProgressUnpackFinished   = "unpack-finished"
+	ProgressImageCreated     = "image-created"
+)
+
 //go:generate counterfeiter . ImageCloner
 //go:generate counterfeiter . BaseImagePuller
 //go:generate counterfeiter . Locksmith
@@ -27,12 +37,16 @@ const (
 //go:generate counterfeiter . StoreMeasurer
 //go:generate counterfeiter . RootFSConfigurer
 //go:generate counterfeiter . MetricsEmitter
+//go:generate counterfeiter . ProgressReporter
+//go:generate counterfeiter . VolumeFinder
 
 type ImageInfo struct {
 	Rootfs string        `json:"rootfs"`
 	Image  specsv1.Image `json:"image,omitempty"`
 	Mounts []MountInfo   `json:"mounts,omitempty"`
-	Path   string        `json:"-"`
+	// Platform is only known for docker and oci images
+	Platform *specsv1.Platform `json:"platform,omitempty"`
+	Path     string            `json:"-"`
 }
 
 type MountInfo struct {
@@ -76,6 +90,9 @@ type LayerInfo struct {
 type BaseImageInfo struct {
 	LayerInfos []LayerInfo
 	Config     specsv1.Image
+	Platform   specsv1.Platform
+	// ManifestDigest is only known for docker and oci images
+	ManifestDigest string
 }
 
 type BaseImagePuller interface {
@@ -101,6 +118,10 @@ type ImageCloner interface {
 	Stats(logger lager.Logger, id string) (VolumeStats, error)
 }
 
+type VolumeFinder interface {
+	VolumePath(logger lager.Logger, id string) (string, error)
+}
+
 type RootFSConfigurer interface {
 	Configure(rootFSPath string, baseImage *specsv1.Image) error
 }
@@ -108,6 +129,7 @@ type RootFSConfigurer interface {
 type DependencyManager interface {
 	Register(id string, chainIDs []string) error
 	Deregister(id string) error
+	IDs(prefix string) ([]string, error)
 }
 
 type GarbageCollector interface {
@@ -131,6 +153,24 @@ type MetricsEmitter interface {
 	TryEmitDurationFrom(logger lager.Logger, name string, from time.Time)
 }
 
+// ProgressEvent is a step of the creation of an image. Fields that do not
+// apply to the step are left empty.
+type ProgressEvent struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	ImageID    string    `json:"image_id,omitempty"`
+	BlobID     string    `json:"blob_id,omitempty"`
+	ChainID    string    `json:"chain_id,omitempty"`
+	Layers     int       `json:"layers,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	TotalBytes int64     `json:"total_bytes,omitempty"`
+	Rootfs     string    `json:"rootfs,omitempty"`
+}
+
+type ProgressReporter interface {
+	Report(logger lager.Logger, event ProgressEvent)
+}
+
 type DiskUsage struct {
 	TotalBytesUsed     int64 `json:"total_bytes_used"`
 	ExclusiveBytesUsed int64 `json:"exclusive_bytes_used"`
@@ -31,6 +31,19 @@ type FakeDependencyManager struct {
 	deregisterReturnsOnCall map[int]struct {
 		result1 error
 	}
+	IDsStub        func(prefix string) ([]string, error)
+	iDsMutex       sync.RWMutex
+	iDsArgsForCall []struct {
+		prefix string
+	}
+	iDsReturns struct {
+		result1 []string
+		result2 error
+	}
+	iDsReturnsOnCall map[int]struct {
+		result1 []string
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -137,6 +150,57 @@ func (fake *FakeDependencyManager) DeregisterReturnsOnCall(i int, result1 error)
 	}{result1}
 }
 
+func (fake *FakeDependencyManager) IDs(prefix string) ([]string, error) {
+	fake.iDsMutex.Lock()
+	ret, specificReturn := fake.iDsReturnsOnCall[len(fake.iDsArgsForCall)]
+	fake.iDsArgsForCall = append(fake.iDsArgsForCall, struct {
+		prefix string
+	}{prefix})
+	fake.recordInvocation("IDs", []interface{}{prefix})
+	fake.iDsMutex.Unlock()
+	if fake.IDsStub != nil {
+		return fake.IDsStub(prefix)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.iDsReturns.result1, fake.iDsReturns.result2
+}
+
+func (fake *FakeDependencyManager) IDsCallCount() int {
+	fake.iDsMutex.RLock()
+	defer fake.iDsMutex.RUnlock()
+	return len(fake.iDsArgsForCall)
+}
+
+func (fake *FakeDependencyManager) IDsArgsForCall(i int) string {
+	fake.iDsMutex.RLock()
+	defer fake.iDsMutex.RUnlock()
+	return fake.iDsArgsForCall[i].prefix
+}
+
+func (fake *FakeDependencyManager) IDsReturns(result1 []string, result2 error) {
+	fake.IDsStub = nil
+	fake.iDsReturns = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDependencyManager) IDsReturnsOnCall(i int, result1 []string, result2 error) {
+	fake.IDsStub = nil
+	if fake.iDsReturnsOnCall == nil {
+		fake.iDsReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 error
+		})
+	}
+	fake.iDsReturnsOnCall[i] = struct {
+		result1 []string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeDependencyManager) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -144,6 +208,8 @@ func (fake *FakeDependencyManager) Invocations() map[string][][]interface{} {
 	defer fake.registerMutex.RUnlock()
 	fake.deregisterMutex.RLock()
 	defer fake.deregisterMutex.RUnlock()
+	fake.iDsMutex.RLock()
+	defer fake.iDsMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
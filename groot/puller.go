@@ -0,0 +1,180 @@
+package groot
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	LayerPresent    = "present"
+	LayerDownloaded = "downloaded"
+)
+
+// Layers registered under a pin reference are kept by the garbage collector
+// even when no image uses them
+const (
+	PinReferencePrefix = "pin:"
+	PinReferenceFormat = PinReferencePrefix + "%s"
+)
+
+// PullSpec describes the image to pull. When PinID is set, the layers of the
+// image are pinned under it.
+type PullSpec struct {
+	BaseImageURL *url.URL
+	UIDMappings  []IDMappingSpec
+	GIDMappings  []IDMappingSpec
+	PinID        string
+}
+
+type PulledLayer struct {
+	ChainID string `json:"chain_id"`
+	BlobID  string `json:"blob_id"`
+	Size    int64  `json:"size"`
+	Status  string `json:"status"`
+}
+
+// PullSummary describes the layers of a pulled image. BytesTransferred is the
+// number of bytes downloaded for the image, leaving out the blobs found in
+// the blob cache and resumed partial downloads.
+type PullSummary struct {
+	ManifestDigest   string        `json:"manifest_digest,omitempty"`
+	Layers           []PulledLayer `json:"layers"`
+	BytesTransferred int64         `json:"bytes_transferred"`
+}
+
+//go:generate counterfeiter . DownloadCounter
+
+// DownloadCounter counts the bytes downloaded by the base image puller
+type DownloadCounter interface {
+	BytesDownloaded() int64
+}
+
+//go:generate counterfeiter . UnpackedLayers
+
+// UnpackedLayers tells which layers the base image puller has unpacked
+type UnpackedLayers interface {
+	Unpacked(chainID string) bool
+}
+
+// Puller brings the layers of an image into the store without creating an
+// image from them
+type Puller struct {
+	baseImagePuller   BaseImagePuller
+	volumeFinder      VolumeFinder
+	locksmith         Locksmith
+	dependencyManager DependencyManager
+	metricsEmitter    MetricsEmitter
+	downloadCounter   DownloadCounter
+	unpackedLayers    UnpackedLayers
+}
+
+func IamPuller(baseImagePuller BaseImagePuller, volumeFinder VolumeFinder, locksmith Locksmith, dependencyManager DependencyManager, metricsEmitter MetricsEmitter) *Puller {
+	return &Puller{
+		baseImagePuller:   baseImagePuller,
+		volumeFinder:      volumeFinder,
+		locksmith:         locksmith,
+		dependencyManager: dependencyManager,
+		metricsEmitter:    metricsEmitter,
+	}
+}
+
+// WithDownloadCounter fills the bytes transferred in the summary from the
+// counter. Without one, no bytes are reported.
+func (p *Puller) WithDownloadCounter(downloadCounter DownloadCounter) *Puller {
+	p.downloadCounter = downloadCounter
+	return p
+}
+
+// WithUnpackedLayers takes the status of the layers from the ones the base
+// image puller unpacked. Without it, the layers missing before the pull are
+// reported as downloaded, even when a concurrent create added them.
+func (p *Puller) WithUnpackedLayers(unpackedLayers UnpackedLayers) *Puller {
+	p.unpackedLayers = unpackedLayers
+	return p
+}
+
+func (p *Puller) Pull(logger lager.Logger, spec PullSpec) (PullSummary, error) {
+	defer p.metricsEmitter.TryEmitDurationFrom(logger, MetricImagePullTime, time.Now())
+
+	logger = logger.Session("groot-pulling", lager.Data{"spec": spec})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	ownerUid, ownerGid := parseOwner(spec.UIDMappings, spec.GIDMappings)
+	baseImageSpec := BaseImageSpec{
+		UIDMappings: spec.UIDMappings,
+		GIDMappings: spec.GIDMappings,
+		OwnerUID:    ownerUid,
+		OwnerGID:    ownerGid,
+	}
+
+	baseImageInfo, err := p.baseImagePuller.FetchBaseImageInfo(logger)
+	if err != nil {
+		return PullSummary{}, err
+	}
+
+	lockFile, err := p.locksmith.Lock(GlobalLockKey)
+	if err != nil {
+		return PullSummary{}, err
+	}
+	defer func() {
+		if err := p.locksmith.Unlock(lockFile); err != nil {
+			logger.Error("failed-to-unlock", err)
+		}
+	}()
+
+	summary := PullSummary{
+		ManifestDigest: baseImageInfo.ManifestDigest,
+		Layers:         []PulledLayer{},
+	}
+	for _, layerInfo := range baseImageInfo.LayerInfos {
+		layer := PulledLayer{
+			ChainID: layerInfo.ChainID,
+			BlobID:  layerInfo.BlobID,
+			Size:    layerInfo.Size,
+			Status:  LayerPresent,
+		}
+		if _, err := p.volumeFinder.VolumePath(logger, layerInfo.ChainID); err != nil {
+			layer.Status = LayerDownloaded
+		}
+		summary.Layers = append(summary.Layers, layer)
+	}
+
+	var bytesBefore int64
+	if p.downloadCounter != nil {
+		bytesBefore = p.downloadCounter.BytesDownloaded()
+	}
+	if err := p.baseImagePuller.Pull(logger, baseImageInfo, baseImageSpec); err != nil {
+		return PullSummary{}, errorspkg.Wrap(err, "pulling the image")
+	}
+	if p.downloadCounter != nil {
+		summary.BytesTransferred = p.downloadCounter.BytesDownloaded() - bytesBefore
+	}
+
+	if p.unpackedLayers != nil {
+		for i := range summary.Layers {
+			summary.Layers[i].Status = LayerPresent
+			if p.unpackedLayers.Unpacked(summary.Layers[i].ChainID) {
+				summary.Layers[i].Status = LayerDownloaded
+			}
+		}
+	}
+
+	if spec.PinID != "" {
+		chainIDs := []string{}
+		for _, layerInfo := range baseImageInfo.LayerInfos {
+			chainIDs = append(chainIDs, layerInfo.ChainID)
+		}
+
+		pinRefName := fmt.Sprintf(PinReferenceFormat, spec.PinID)
+		if err := p.dependencyManager.Register(pinRefName, chainIDs); err != nil {
+			return PullSummary{}, errorspkg.Wrap(err, "pinning the image")
+		}
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,278 @@
+package groot_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/groot/grootfakes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Puller", func() {
+	var (
+		baseImageUrl          *url.URL
+		fakeBaseImagePuller   *grootfakes.FakeBaseImagePuller
+		fakeVolumeFinder      *grootfakes.FakeVolumeFinder
+		fakeLocksmith         *grootfakes.FakeLocksmith
+		fakeDependencyManager *grootfakes.FakeDependencyManager
+		fakeMetricsEmitter    *grootfakes.FakeMetricsEmitter
+		lockFile              *os.File
+
+		puller *groot.Puller
+		logger lager.Logger
+
+		baseImageInfo groot.BaseImageInfo
+	)
+
+	BeforeEach(func() {
+		baseImageUrl, _ = url.Parse("docker:///cfgarden/empty")
+
+		fakeBaseImagePuller = new(grootfakes.FakeBaseImagePuller)
+		fakeVolumeFinder = new(grootfakes.FakeVolumeFinder)
+		fakeLocksmith = new(grootfakes.FakeLocksmith)
+		fakeDependencyManager = new(grootfakes.FakeDependencyManager)
+		fakeMetricsEmitter = new(grootfakes.FakeMetricsEmitter)
+
+		var err error
+		lockFile, err = ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+		fakeLocksmith.LockReturns(lockFile, nil)
+
+		logger = lagertest.NewTestLogger("puller")
+
+		baseImageInfo = groot.BaseImageInfo{
+			ManifestDigest: "sha256:manifest",
+			LayerInfos: []groot.LayerInfo{
+				{BlobID: "sha256:blob-1", ChainID: "id-1", Size: 100},
+				{BlobID: "sha256:blob-2", ChainID: "id-2", ParentChainID: "id-1", Size: 200},
+			},
+		}
+		fakeBaseImagePuller.FetchBaseImageInfoReturns(baseImageInfo, nil)
+
+		fakeVolumeFinder.VolumePathStub = func(_ lager.Logger, id string) (string, error) {
+			if id == "id-1" {
+				return "/volumes/id-1", nil
+			}
+			return "", errors.New("volume does not exist")
+		}
+
+		puller = groot.IamPuller(fakeBaseImagePuller, fakeVolumeFinder, fakeLocksmith, fakeDependencyManager, fakeMetricsEmitter)
+	})
+
+	AfterEach(func() {
+		Expect(os.Remove(lockFile.Name())).To(Succeed())
+	})
+
+	It("pulls the image under the global lock", func() {
+		fakeBaseImagePuller.PullStub = func(_ lager.Logger, _ groot.BaseImageInfo, _ groot.BaseImageSpec) error {
+			Expect(fakeLocksmith.LockCallCount()).To(Equal(1))
+			Expect(fakeLocksmith.UnlockCallCount()).To(BeZero())
+			return nil
+		}
+
+		_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeBaseImagePuller.PullCallCount()).To(Equal(1))
+		_, actualBaseImageInfo, _ := fakeBaseImagePuller.PullArgsForCall(0)
+		Expect(actualBaseImageInfo).To(Equal(baseImageInfo))
+
+		Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+		Expect(fakeLocksmith.UnlockArgsForCall(0)).To(Equal(lockFile))
+	})
+
+	It("pulls the layers with the owner of the mappings", func() {
+		uidMappings := []groot.IDMappingSpec{{HostID: 2, NamespaceID: 0, Size: 1}}
+		gidMappings := []groot.IDMappingSpec{{HostID: 3, NamespaceID: 0, Size: 1}}
+
+		_, err := puller.Pull(logger, groot.PullSpec{
+			BaseImageURL: baseImageUrl,
+			UIDMappings:  uidMappings,
+			GIDMappings:  gidMappings,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, baseImageSpec := fakeBaseImagePuller.PullArgsForCall(0)
+		Expect(baseImageSpec).To(Equal(groot.BaseImageSpec{
+			UIDMappings: uidMappings,
+			GIDMappings: gidMappings,
+			OwnerUID:    2,
+			OwnerGID:    3,
+		}))
+	})
+
+	It("summarises the layers that were present and the ones that were downloaded", func() {
+		summary, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(summary).To(Equal(groot.PullSummary{
+			ManifestDigest: "sha256:manifest",
+			Layers: []groot.PulledLayer{
+				{ChainID: "id-1", BlobID: "sha256:blob-1", Size: 100, Status: groot.LayerPresent},
+				{ChainID: "id-2", BlobID: "sha256:blob-2", Size: 200, Status: groot.LayerDownloaded},
+			},
+		}))
+	})
+
+	Context("when the unpacked layers are recorded", func() {
+		var fakeUnpackedLayers *grootfakes.FakeUnpackedLayers
+
+		BeforeEach(func() {
+			fakeUnpackedLayers = new(grootfakes.FakeUnpackedLayers)
+			puller.WithUnpackedLayers(fakeUnpackedLayers)
+		})
+
+		It("reports as downloaded the layers unpacked by the pull", func() {
+			fakeUnpackedLayers.UnpackedStub = func(chainID string) bool {
+				return chainID == "id-2"
+			}
+
+			summary, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(summary.Layers).To(Equal([]groot.PulledLayer{
+				{ChainID: "id-1", BlobID: "sha256:blob-1", Size: 100, Status: groot.LayerPresent},
+				{ChainID: "id-2", BlobID: "sha256:blob-2", Size: 200, Status: groot.LayerDownloaded},
+			}))
+		})
+
+		Context("when a concurrent create adds a missing layer during the pull", func() {
+			BeforeEach(func() {
+				fakeUnpackedLayers.UnpackedReturns(false)
+			})
+
+			It("reports it as present", func() {
+				summary, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(summary.Layers[1].Status).To(Equal(groot.LayerPresent))
+			})
+		})
+	})
+
+	It("does not report any bytes transferred", func() {
+		summary, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.BytesTransferred).To(BeZero())
+	})
+
+	Context("when a download counter is provided", func() {
+		var fakeDownloadCounter *grootfakes.FakeDownloadCounter
+
+		BeforeEach(func() {
+			fakeDownloadCounter = new(grootfakes.FakeDownloadCounter)
+			fakeDownloadCounter.BytesDownloadedReturnsOnCall(0, 50)
+			fakeDownloadCounter.BytesDownloadedReturnsOnCall(1, 170)
+			puller.WithDownloadCounter(fakeDownloadCounter)
+		})
+
+		It("reports the bytes downloaded while pulling the image", func() {
+			fakeBaseImagePuller.PullStub = func(_ lager.Logger, _ groot.BaseImageInfo, _ groot.BaseImageSpec) error {
+				Expect(fakeDownloadCounter.BytesDownloadedCallCount()).To(Equal(1))
+				return nil
+			}
+
+			summary, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.BytesTransferred).To(Equal(int64(120)))
+		})
+	})
+
+	It("does not pin the image", func() {
+		_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeDependencyManager.RegisterCallCount()).To(BeZero())
+	})
+
+	Context("when a pin id is provided", func() {
+		It("pins the layers of the image under the global lock", func() {
+			fakeDependencyManager.RegisterStub = func(_ string, _ []string) error {
+				Expect(fakeLocksmith.UnlockCallCount()).To(BeZero())
+				return nil
+			}
+
+			_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl, PinID: "my-pin"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeDependencyManager.RegisterCallCount()).To(Equal(1))
+			id, chainIDs := fakeDependencyManager.RegisterArgsForCall(0)
+			Expect(id).To(Equal("pin:my-pin"))
+			Expect(chainIDs).To(Equal([]string{"id-1", "id-2"}))
+		})
+
+		Context("when pinning fails", func() {
+			BeforeEach(func() {
+				fakeDependencyManager.RegisterReturns(errors.New("disk full"))
+			})
+
+			It("returns the error", func() {
+				_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl, PinID: "my-pin"})
+				Expect(err).To(MatchError(ContainSubstring("disk full")))
+			})
+		})
+
+		Context("when pulling the layers fails", func() {
+			BeforeEach(func() {
+				fakeBaseImagePuller.PullReturns(errors.New("unpacking failed"))
+			})
+
+			It("does not pin the image", func() {
+				_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl, PinID: "my-pin"})
+				Expect(err).To(HaveOccurred())
+				Expect(fakeDependencyManager.RegisterCallCount()).To(BeZero())
+			})
+		})
+	})
+
+	It("emits the pull time", func() {
+		_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeMetricsEmitter.TryEmitDurationFromCallCount()).To(Equal(1))
+		_, name, _ := fakeMetricsEmitter.TryEmitDurationFromArgsForCall(0)
+		Expect(name).To(Equal(groot.MetricImagePullTime))
+	})
+
+	Context("when fetching the image info fails", func() {
+		BeforeEach(func() {
+			fakeBaseImagePuller.FetchBaseImageInfoReturns(groot.BaseImageInfo{}, errors.New("manifest not found"))
+		})
+
+		It("returns the error without taking the lock", func() {
+			_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+			Expect(err).To(MatchError("manifest not found"))
+			Expect(fakeLocksmith.LockCallCount()).To(BeZero())
+		})
+	})
+
+	Context("when taking the lock fails", func() {
+		BeforeEach(func() {
+			fakeLocksmith.LockReturns(nil, errors.New("lock failed"))
+		})
+
+		It("does not pull the image", func() {
+			_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+			Expect(err).To(MatchError("lock failed"))
+			Expect(fakeBaseImagePuller.PullCallCount()).To(BeZero())
+		})
+	})
+
+	Context("when pulling the layers fails", func() {
+		BeforeEach(func() {
+			fakeBaseImagePuller.PullReturns(errors.New("unpacking failed"))
+		})
+
+		It("returns the error and releases the lock", func() {
+			_, err := puller.Pull(logger, groot.PullSpec{BaseImageURL: baseImageUrl})
+			Expect(err).To(MatchError(ContainSubstring("unpacking failed")))
+			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,145 @@
+package groot
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+const (
+	ImageWarm   = "warm"
+	ImagePulled = "pulled"
+	ImageFailed = "failed"
+)
+
+//go:generate counterfeiter . ImagePuller
+
+type ImagePuller interface {
+	Pull(logger lager.Logger, spec PullSpec) (PullSummary, error)
+}
+
+type WarmSpec struct {
+	BaseImageURLs []*url.URL
+	UIDMappings   []IDMappingSpec
+	GIDMappings   []IDMappingSpec
+}
+
+type WarmedImage struct {
+	BaseImageURL string `json:"image"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+type WarmSummary struct {
+	Images       []WarmedImage `json:"images"`
+	ReleasedPins int           `json:"released_pins"`
+}
+
+// Warmer keeps the layers of a list of images pulled and pinned, and releases
+// the pins of the images that are no longer listed
+type Warmer struct {
+	imagePuller       ImagePuller
+	dependencyManager DependencyManager
+	locksmith         Locksmith
+}
+
+func IamWarmer(imagePuller ImagePuller, dependencyManager DependencyManager, locksmith Locksmith) *Warmer {
+	return &Warmer{
+		imagePuller:       imagePuller,
+		dependencyManager: dependencyManager,
+		locksmith:         locksmith,
+	}
+}
+
+func (w *Warmer) Warm(logger lager.Logger, spec WarmSpec) (WarmSummary, error) {
+	logger = logger.Session("groot-warming", lager.Data{"spec": spec})
+	logger.Info("starting")
+	defer logger.Info("ending")
+
+	summary := WarmSummary{Images: []WarmedImage{}}
+	listedPins := map[string]bool{}
+	for _, baseImageURL := range spec.BaseImageURLs {
+		pinID := PinID(baseImageURL)
+		// a failed image keeps the pin of its previous pull
+		listedPins[fmt.Sprintf(PinReferenceFormat, pinID)] = true
+
+		summary.Images = append(summary.Images, w.warmImage(logger, baseImageURL, pinID, spec))
+	}
+
+	releasedPins, err := w.releasePins(logger, listedPins)
+	if err != nil {
+		return WarmSummary{}, err
+	}
+	summary.ReleasedPins = releasedPins
+
+	return summary, nil
+}
+
+// releasePins deregisters the pins that are not listed under the global lock,
+// so that clean does not look at the dependencies while they change
+func (w *Warmer) releasePins(logger lager.Logger, listedPins map[string]bool) (int, error) {
+	lockFile, err := w.locksmith.Lock(GlobalLockKey)
+	if err != nil {
+		return 0, errorspkg.Wrap(err, "obtaining a lock")
+	}
+	defer func() {
+		if err := w.locksmith.Unlock(lockFile); err != nil {
+			logger.Error("failed-to-unlock", err)
+		}
+	}()
+
+	pinIDs, err := w.dependencyManager.IDs(PinReferencePrefix)
+	if err != nil {
+		return 0, errorspkg.Wrap(err, "listing pins")
+	}
+
+	releasedPins := 0
+	for _, pinID := range pinIDs {
+		if listedPins[pinID] {
+			continue
+		}
+
+		if err := w.dependencyManager.Deregister(pinID); err != nil {
+			return 0, errorspkg.Wrapf(err, "releasing pin %s", pinID)
+		}
+		releasedPins++
+	}
+
+	return releasedPins, nil
+}
+
+func (w *Warmer) warmImage(logger lager.Logger, baseImageURL *url.URL, pinID string, spec WarmSpec) WarmedImage {
+	image := WarmedImage{BaseImageURL: baseImageURL.String()}
+
+	pullSummary, err := w.imagePuller.Pull(logger, PullSpec{
+		BaseImageURL: baseImageURL,
+		UIDMappings:  spec.UIDMappings,
+		GIDMappings:  spec.GIDMappings,
+		PinID:        pinID,
+	})
+	if err != nil {
+		logger.Error("failed-to-warm-image", err, lager.Data{"baseImageURL": image.BaseImageURL})
+		image.Status = ImageFailed
+		image.Error = err.Error()
+		return image
+	}
+
+	image.Status = ImageWarm
+	for _, layer := range pullSummary.Layers {
+		if layer.Status != LayerPresent {
+			image.Status = ImagePulled
+			break
+		}
+	}
+
+	return image
+}
+
+// PinID identifies the pin of an image. The url is hashed, as pin references
+// are stored as file names.
+func PinID(baseImageURL *url.URL) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(baseImageURL.String())))
+}
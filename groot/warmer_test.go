@@ -0,0 +1,205 @@
+package groot_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/groot/grootfakes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Warmer", func() {
+	var (
+		busyboxURL            *url.URL
+		ubuntuURL             *url.URL
+		fakeImagePuller       *grootfakes.FakeImagePuller
+		fakeDependencyManager *grootfakes.FakeDependencyManager
+		fakeLocksmith         *grootfakes.FakeLocksmith
+		lockFile              *os.File
+
+		warmer   *groot.Warmer
+		logger   lager.Logger
+		warmSpec groot.WarmSpec
+	)
+
+	BeforeEach(func() {
+		busyboxURL, _ = url.Parse("docker:///busybox")
+		ubuntuURL, _ = url.Parse("docker:///ubuntu")
+
+		fakeImagePuller = new(grootfakes.FakeImagePuller)
+		fakeImagePuller.PullStub = func(_ lager.Logger, spec groot.PullSpec) (groot.PullSummary, error) {
+			if spec.BaseImageURL.String() == busyboxURL.String() {
+				return groot.PullSummary{Layers: []groot.PulledLayer{
+					{ChainID: "busybox-1", Status: groot.LayerPresent},
+				}}, nil
+			}
+
+			return groot.PullSummary{Layers: []groot.PulledLayer{
+				{ChainID: "ubuntu-1", Status: groot.LayerPresent},
+				{ChainID: "ubuntu-2", Status: groot.LayerDownloaded},
+			}}, nil
+		}
+		fakeDependencyManager = new(grootfakes.FakeDependencyManager)
+
+		logger = lagertest.NewTestLogger("warmer")
+		warmSpec = groot.WarmSpec{
+			BaseImageURLs: []*url.URL{busyboxURL, ubuntuURL},
+			UIDMappings:   []groot.IDMappingSpec{{HostID: 2, NamespaceID: 0, Size: 1}},
+			GIDMappings:   []groot.IDMappingSpec{{HostID: 3, NamespaceID: 0, Size: 1}},
+		}
+
+		fakeLocksmith = new(grootfakes.FakeLocksmith)
+		var err error
+		lockFile, err = ioutil.TempFile("", "")
+		Expect(err).NotTo(HaveOccurred())
+		fakeLocksmith.LockReturns(lockFile, nil)
+
+		warmer = groot.IamWarmer(fakeImagePuller, fakeDependencyManager, fakeLocksmith)
+	})
+
+	AfterEach(func() {
+		Expect(os.Remove(lockFile.Name())).To(Succeed())
+	})
+
+	It("pulls and pins every listed image", func() {
+		_, err := warmer.Warm(logger, warmSpec)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeImagePuller.PullCallCount()).To(Equal(2))
+		_, busyboxSpec := fakeImagePuller.PullArgsForCall(0)
+		Expect(busyboxSpec).To(Equal(groot.PullSpec{
+			BaseImageURL: busyboxURL,
+			UIDMappings:  warmSpec.UIDMappings,
+			GIDMappings:  warmSpec.GIDMappings,
+			PinID:        groot.PinID(busyboxURL),
+		}))
+		_, ubuntuSpec := fakeImagePuller.PullArgsForCall(1)
+		Expect(ubuntuSpec.BaseImageURL).To(Equal(ubuntuURL))
+		Expect(ubuntuSpec.PinID).To(Equal(groot.PinID(ubuntuURL)))
+	})
+
+	It("reports whether each image was already warm or had to be pulled", func() {
+		summary, err := warmer.Warm(logger, warmSpec)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(summary.Images).To(Equal([]groot.WarmedImage{
+			{BaseImageURL: "docker:///busybox", Status: groot.ImageWarm},
+			{BaseImageURL: "docker:///ubuntu", Status: groot.ImagePulled},
+		}))
+	})
+
+	Context("when pulling an image fails", func() {
+		BeforeEach(func() {
+			fakeImagePuller.PullReturnsOnCall(0, groot.PullSummary{}, errors.New("manifest unknown"))
+			fakeImagePuller.PullStub = nil
+		})
+
+		It("reports it as failed and warms the rest", func() {
+			summary, err := warmer.Warm(logger, warmSpec)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeImagePuller.PullCallCount()).To(Equal(2))
+			Expect(summary.Images[0]).To(Equal(groot.WarmedImage{
+				BaseImageURL: "docker:///busybox",
+				Status:       groot.ImageFailed,
+				Error:        "manifest unknown",
+			}))
+			Expect(summary.Images[1].Status).To(Equal(groot.ImageWarm))
+		})
+	})
+
+	Describe("releasing pins", func() {
+		BeforeEach(func() {
+			fakeDependencyManager.IDsReturns([]string{
+				"pin:" + groot.PinID(busyboxURL),
+				"pin:" + groot.PinID(ubuntuURL),
+				"pin:no-longer-listed",
+			}, nil)
+		})
+
+		It("releases the pins of the images that are no longer listed", func() {
+			summary, err := warmer.Warm(logger, warmSpec)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeDependencyManager.IDsArgsForCall(0)).To(Equal(groot.PinReferencePrefix))
+			Expect(fakeDependencyManager.DeregisterCallCount()).To(Equal(1))
+			Expect(fakeDependencyManager.DeregisterArgsForCall(0)).To(Equal("pin:no-longer-listed"))
+			Expect(summary.ReleasedPins).To(Equal(1))
+		})
+
+		It("lists and releases the pins under the global lock", func() {
+			fakeDependencyManager.IDsStub = func(_ string) ([]string, error) {
+				Expect(fakeLocksmith.LockCallCount()).To(Equal(1))
+				return []string{"pin:no-longer-listed"}, nil
+			}
+			fakeDependencyManager.DeregisterStub = func(_ string) error {
+				Expect(fakeLocksmith.UnlockCallCount()).To(BeZero())
+				return nil
+			}
+
+			_, err := warmer.Warm(logger, warmSpec)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeDependencyManager.DeregisterCallCount()).To(Equal(1))
+			Expect(fakeLocksmith.LockArgsForCall(0)).To(Equal(groot.GlobalLockKey))
+			Expect(fakeLocksmith.UnlockCallCount()).To(Equal(1))
+			Expect(fakeLocksmith.UnlockArgsForCall(0)).To(Equal(lockFile))
+		})
+
+		Context("when acquiring the lock fails", func() {
+			BeforeEach(func() {
+				fakeLocksmith.LockReturns(nil, errors.New("failed to acquire lock"))
+			})
+
+			It("returns an error without releasing any pin", func() {
+				_, err := warmer.Warm(logger, warmSpec)
+				Expect(err).To(MatchError(ContainSubstring("failed to acquire lock")))
+				Expect(fakeDependencyManager.DeregisterCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when a listed image fails to warm", func() {
+			BeforeEach(func() {
+				fakeImagePuller.PullStub = nil
+				fakeImagePuller.PullReturns(groot.PullSummary{}, errors.New("registry down"))
+			})
+
+			It("keeps its pin", func() {
+				_, err := warmer.Warm(logger, warmSpec)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeDependencyManager.DeregisterCallCount()).To(Equal(1))
+				Expect(fakeDependencyManager.DeregisterArgsForCall(0)).To(Equal("pin:no-longer-listed"))
+			})
+		})
+
+		Context("when listing the pins fails", func() {
+			BeforeEach(func() {
+				fakeDependencyManager.IDsReturns(nil, errors.New("permission denied"))
+			})
+
+			It("returns an error", func() {
+				_, err := warmer.Warm(logger, warmSpec)
+				Expect(err).To(MatchError(ContainSubstring("permission denied")))
+			})
+		})
+
+		Context("when releasing a pin fails", func() {
+			BeforeEach(func() {
+				fakeDependencyManager.DeregisterReturns(errors.New("read-only file system"))
+			})
+
+			It("returns an error", func() {
+				_, err := warmer.Warm(logger, warmSpec)
+				Expect(err).To(MatchError(ContainSubstring("read-only file system")))
+			})
+		})
+	})
+})
@@ -612,7 +612,57 @@ var _ = Describe("Create with remote DOCKER images", func() {
 				ID:           randomImageID,
 				Mount:        mountByDefault(),
 			})
-			Expect(err).To(MatchError("This registry is insecure. To pull images from this registry, please use the --insecure-registry option."))
+			Expect(err).To(MatchError(fmt.Sprintf("This registry is signed by an unknown authority. To trust it, please set create.certs_dir in the config file and add its CA certificate as <certs_dir>/%s/ca.crt.", fakeRegistry.Addr())))
+		})
+
+		Context("when the certs dir is configured", func() {
+			var certsDir string
+
+			BeforeEach(func() {
+				var err error
+				certsDir, err = ioutil.TempDir("", "certs.d")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Chmod(certsDir, 0755)).To(Succeed())
+
+				cfg := config.Config{
+					Create: config.Create{
+						CertsDir: certsDir,
+					},
+				}
+				Expect(runner.SetConfig(cfg)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(certsDir)).To(Succeed())
+			})
+
+			It("points to the CA certificate of the registry", func() {
+				_, err := runner.Create(groot.CreateSpec{
+					BaseImageURL: baseImageURL,
+					ID:           randomImageID,
+					Mount:        mountByDefault(),
+				})
+				Expect(err).To(MatchError(fmt.Sprintf("This registry is signed by an unknown authority. To trust it, please add its CA certificate as %s.", filepath.Join(certsDir, fakeRegistry.Addr(), "ca.crt"))))
+			})
+
+			Context("when the CA certificate of the registry is in it", func() {
+				BeforeEach(func() {
+					registryCertsDir := filepath.Join(certsDir, fakeRegistry.Addr())
+					Expect(os.Mkdir(registryCertsDir, 0755)).To(Succeed())
+					Expect(ioutil.WriteFile(filepath.Join(registryCertsDir, "ca.crt"), fakeRegistry.CACertificate(), 0644)).To(Succeed())
+				})
+
+				It("creates a root filesystem based on the image provided by the private registry", func() {
+					containerSpec, err := runner.Create(groot.CreateSpec{
+						BaseImageURL: baseImageURL,
+						ID:           randomImageID,
+						Mount:        mountByDefault(),
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(runner.EnsureMounted(containerSpec)).To(Succeed())
+					Expect(path.Join(containerSpec.Root.Path, "hello")).To(BeAnExistingFile())
+				})
+			})
 		})
 
 		Context("when it's provided as a valid insecure registry", func() {
@@ -0,0 +1,69 @@
+package integration_test
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/integration"
+	"code.cloudfoundry.org/grootfs/store"
+	"code.cloudfoundry.org/grootfs/testhelpers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pull", func() {
+	var baseImageURL *url.URL
+
+	BeforeEach(func() {
+		baseImageURL = integration.String2URL("docker:///cfgarden/empty:v0.1.1")
+	})
+
+	It("pulls the layers of the image without creating an image", func() {
+		summary, err := Runner.Pull(baseImageURL)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, layer := range testhelpers.EmptyBaseImageV011.Layers {
+			Expect(filepath.Join(StorePath, store.VolumesDirName, layer.ChainID)).To(BeADirectory())
+		}
+
+		images, err := ioutil.ReadDir(filepath.Join(StorePath, store.ImageDirName))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(images).To(BeEmpty())
+
+		Expect(summary.ManifestDigest).To(HavePrefix("sha256:"))
+		Expect(summary.Layers).To(HaveLen(2))
+		for i, layer := range testhelpers.EmptyBaseImageV011.Layers {
+			Expect(summary.Layers[i].ChainID).To(Equal(layer.ChainID))
+			Expect(summary.Layers[i].BlobID).To(Equal(layer.BlobID))
+			Expect(summary.Layers[i].Status).To(Equal(groot.LayerDownloaded))
+		}
+		Expect(summary.BytesTransferred).To(BeNumerically(">", 0))
+	})
+
+	Context("when the layers are already in the store", func() {
+		BeforeEach(func() {
+			_, err := Runner.Pull(baseImageURL)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports them as present", func() {
+			summary, err := Runner.Pull(baseImageURL)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, layer := range summary.Layers {
+				Expect(layer.Status).To(Equal(groot.LayerPresent))
+			}
+			Expect(summary.BytesTransferred).To(BeZero())
+		})
+	})
+
+	Context("when the image does not exist", func() {
+		It("fails", func() {
+			_, err := Runner.Pull(integration.String2URL("docker:///cfgarden/sorry-not-here"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
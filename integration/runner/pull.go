@@ -0,0 +1,42 @@
+package runner
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"code.cloudfoundry.org/grootfs/groot"
+)
+
+func (r Runner) Pull(baseImageURL *url.URL) (groot.PullSummary, error) {
+	if !r.skipInitStore {
+		if err := r.initStoreAsRoot(); err != nil {
+			return groot.PullSummary{}, err
+		}
+	}
+
+	args := []string{}
+	if r.InsecureRegistry != "" {
+		args = append(args, "--insecure-registry", r.InsecureRegistry)
+	}
+
+	if r.RegistryUsername != "" {
+		args = append(args, "--username", r.RegistryUsername)
+	}
+
+	if r.RegistryPassword != "" {
+		args = append(args, "--password", r.RegistryPassword)
+	}
+
+	args = append(args, baseImageURL.String())
+	output, err := r.RunSubcommand("pull", args...)
+	if err != nil {
+		return groot.PullSummary{}, err
+	}
+
+	summary := groot.PullSummary{}
+	if err := json.Unmarshal([]byte(output), &summary); err != nil {
+		return groot.PullSummary{}, err
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,40 @@
+package runner
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/grootfs/groot"
+)
+
+func (r Runner) Warm(listPath string) (groot.WarmSummary, error) {
+	if !r.skipInitStore {
+		if err := r.initStoreAsRoot(); err != nil {
+			return groot.WarmSummary{}, err
+		}
+	}
+
+	args := []string{"--from", listPath}
+	if r.InsecureRegistry != "" {
+		args = append(args, "--insecure-registry", r.InsecureRegistry)
+	}
+
+	if r.RegistryUsername != "" {
+		args = append(args, "--username", r.RegistryUsername)
+	}
+
+	if r.RegistryPassword != "" {
+		args = append(args, "--password", r.RegistryPassword)
+	}
+
+	output, err := r.RunSubcommand("warm", args...)
+	if err != nil {
+		return groot.WarmSummary{}, err
+	}
+
+	summary := groot.WarmSummary{}
+	if err := json.Unmarshal([]byte(output), &summary); err != nil {
+		return groot.WarmSummary{}, err
+	}
+
+	return summary, nil
+}
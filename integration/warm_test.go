@@ -0,0 +1,117 @@
+package integration_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/store"
+	"code.cloudfoundry.org/grootfs/testhelpers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Warm", func() {
+	var listPath string
+
+	writeList := func(contents string) {
+		Expect(ioutil.WriteFile(listPath, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		listFile, err := ioutil.TempFile("", "warm-list")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listFile.Close()).To(Succeed())
+		listPath = listFile.Name()
+
+		writeList("- docker:///cfgarden/empty:v0.1.1\n")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(listPath)).To(Succeed())
+	})
+
+	It("pulls the listed images", func() {
+		summary, err := Runner.Warm(listPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(summary.Images).To(Equal([]groot.WarmedImage{
+			{BaseImageURL: "docker:///cfgarden/empty:v0.1.1", Status: groot.ImagePulled},
+		}))
+		for _, layer := range testhelpers.EmptyBaseImageV011.Layers {
+			Expect(filepath.Join(StorePath, store.VolumesDirName, layer.ChainID)).To(BeADirectory())
+		}
+	})
+
+	It("keeps the layers of the listed images from being cleaned", func() {
+		_, err := Runner.Warm(listPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = Runner.Clean(0)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, layer := range testhelpers.EmptyBaseImageV011.Layers {
+			Expect(filepath.Join(StorePath, store.VolumesDirName, layer.ChainID)).To(BeADirectory())
+		}
+	})
+
+	Context("when the images are already warm", func() {
+		BeforeEach(func() {
+			_, err := Runner.Warm(listPath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports them as warm", func() {
+			summary, err := Runner.Warm(listPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Images[0].Status).To(Equal(groot.ImageWarm))
+		})
+
+		Context("and an image is no longer listed", func() {
+			BeforeEach(func() {
+				writeList("[]\n")
+			})
+
+			It("releases its pin, so that clean removes its layers", func() {
+				summary, err := Runner.Warm(listPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(summary.ReleasedPins).To(Equal(1))
+
+				_, err = Runner.Clean(0)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, layer := range testhelpers.EmptyBaseImageV011.Layers {
+					Expect(filepath.Join(StorePath, store.VolumesDirName, layer.ChainID)).NotTo(BeADirectory())
+				}
+			})
+		})
+	})
+
+	Context("when a listed image is private", func() {
+		BeforeEach(func() {
+			writeList("- docker:///cfgarden/private\n")
+		})
+
+		It("pulls it with the given credentials", func() {
+			summary, err := Runner.WithCredentials(RegistryUsername, RegistryPassword).Warm(listPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(summary.Images).To(Equal([]groot.WarmedImage{
+				{BaseImageURL: "docker:///cfgarden/private", Status: groot.ImagePulled},
+			}))
+		})
+	})
+
+	Context("when an image cannot be pulled", func() {
+		BeforeEach(func() {
+			writeList("- docker:///cfgarden/sorry-not-here\n")
+		})
+
+		It("fails", func() {
+			_, err := Runner.Warm(listPath)
+			Expect(err).To(MatchError(ContainSubstring("1/1 images failed to warm")))
+		})
+	})
+})
@@ -87,6 +87,8 @@ func main() {
 		commands.DeleteStoreCommand,
 		commands.GenerateVolumeSizeMetadata,
 		commands.CreateCommand,
+		commands.PullCommand,
+		commands.WarmCommand,
 		commands.DeleteCommand,
 		commands.StatsCommand,
 		commands.CleanCommand,
@@ -0,0 +1,36 @@
+package progress // import "code.cloudfoundry.org/grootfs/progress"
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager"
+)
+
+// LayerRecorder records the layers unpacked by the base image puller from its
+// progress events
+type LayerRecorder struct {
+	mutex    sync.Mutex
+	unpacked map[string]bool
+}
+
+func NewLayerRecorder() *LayerRecorder {
+	return &LayerRecorder{unpacked: map[string]bool{}}
+}
+
+func (r *LayerRecorder) Report(logger lager.Logger, event groot.ProgressEvent) {
+	if event.Event != groot.ProgressUnpackFinished {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.unpacked[event.ChainID] = true
+}
+
+// Unpacked returns whether the layer has been unpacked
+func (r *LayerRecorder) Unpacked(chainID string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.unpacked[chainID]
+}
@@ -0,0 +1,31 @@
+package progress_test
+
+import (
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/progress"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LayerRecorder", func() {
+	var (
+		recorder *progress.LayerRecorder
+		logger   *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		recorder = progress.NewLayerRecorder()
+		logger = lagertest.NewTestLogger("progress")
+	})
+
+	It("records the layers that finished unpacking", func() {
+		recorder.Report(logger, groot.ProgressEvent{Event: groot.ProgressUnpackStarted, ChainID: "chain-1"})
+		recorder.Report(logger, groot.ProgressEvent{Event: groot.ProgressUnpackStarted, ChainID: "chain-2"})
+		recorder.Report(logger, groot.ProgressEvent{Event: groot.ProgressUnpackFinished, ChainID: "chain-2"})
+
+		Expect(recorder.Unpacked("chain-1")).To(BeFalse())
+		Expect(recorder.Unpacked("chain-2")).To(BeTrue())
+	})
+})
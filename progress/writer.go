@@ -0,0 +1,36 @@
+package progress // import "code.cloudfoundry.org/grootfs/progress"
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/lager"
+)
+
+// Writer reports progress events as JSON lines
+type Writer struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{encoder: json.NewEncoder(w)}
+}
+
+// Report writes the event on its own line. Events are reported on a best
+// effort basis, failing to write one does not fail the create.
+func (w *Writer) Report(logger lager.Logger, event groot.ProgressEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := w.encoder.Encode(event); err != nil {
+		logger.Error("failed-to-report-progress", err, lager.Data{"event": event.Event})
+	}
+}
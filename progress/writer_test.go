@@ -0,0 +1,91 @@
+package progress_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/progress"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writer", func() {
+	var (
+		output *bytes.Buffer
+		writer *progress.Writer
+		logger *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		output = bytes.NewBuffer(nil)
+		writer = progress.NewWriter(output)
+		logger = lagertest.NewTestLogger("progress")
+	})
+
+	readEvents := func() []map[string]interface{} {
+		events := []map[string]interface{}{}
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			event := map[string]interface{}{}
+			Expect(json.Unmarshal(scanner.Bytes(), &event)).To(Succeed())
+			events = append(events, event)
+		}
+		return events
+	}
+
+	It("writes each event as a JSON line", func() {
+		writer.Report(logger, groot.ProgressEvent{Event: groot.ProgressManifestResolved, Layers: 2, TotalBytes: 1024})
+		writer.Report(logger, groot.ProgressEvent{Event: groot.ProgressLayerDownloading, BlobID: "sha256:abc", Bytes: 512, TotalBytes: 1024})
+
+		events := readEvents()
+		Expect(events).To(HaveLen(2))
+		Expect(events[0]).To(HaveKeyWithValue("event", "manifest-resolved"))
+		Expect(events[0]).To(HaveKeyWithValue("layers", BeNumerically("==", 2)))
+		Expect(events[0]).To(HaveKeyWithValue("total_bytes", BeNumerically("==", 1024)))
+		Expect(events[1]).To(HaveKeyWithValue("event", "layer-downloading"))
+		Expect(events[1]).To(HaveKeyWithValue("blob_id", "sha256:abc"))
+		Expect(events[1]).To(HaveKeyWithValue("bytes", BeNumerically("==", 512)))
+	})
+
+	It("leaves out the fields that do not apply to the event", func() {
+		writer.Report(logger, groot.ProgressEvent{Event: groot.ProgressImageCreated, ImageID: "my-image"})
+
+		events := readEvents()
+		Expect(events).To(HaveLen(1))
+		Expect(events[0]).To(HaveKeyWithValue("image_id", "my-image"))
+		Expect(events[0]).NotTo(HaveKey("blob_id"))
+		Expect(events[0]).NotTo(HaveKey("bytes"))
+	})
+
+	It("timestamps the events", func() {
+		before := time.Now()
+		writer.Report(logger, groot.ProgressEvent{Event: groot.ProgressUnpackStarted})
+
+		var event groot.ProgressEvent
+		Expect(json.Unmarshal(output.Bytes(), &event)).To(Succeed())
+		Expect(event.Time).To(BeTemporally(">=", before))
+	})
+
+	Context("when the event cannot be written", func() {
+		It("logs the failure", func() {
+			writer = progress.NewWriter(failingWriter{})
+			writer.Report(logger, groot.ProgressEvent{Event: groot.ProgressUnpackStarted})
+
+			Expect(logger.LogMessages()).To(ContainElement("progress.failed-to-report-progress"))
+			Expect(logger.Logs()[0].LogLevel).To(Equal(lager.ERROR))
+		})
+	})
+})
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("bad file descriptor")
+}
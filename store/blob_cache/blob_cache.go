@@ -0,0 +1,235 @@
+package blob_cache // import "code.cloudfoundry.org/grootfs/store/blob_cache"
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/fetcher/layer_fetcher"
+	"code.cloudfoundry.org/lager"
+	digestpkg "github.com/opencontainers/go-digest"
+	errorspkg "github.com/pkg/errors"
+)
+
+const incompleteBlobMarker = ".incomplete-"
+
+// incomplete blobs older than this were left behind by a crashed download
+const incompleteBlobTTL = time.Hour
+
+// BlobCache keeps compressed blobs in `<path>/sha256/<hex>`, evicting the
+// least recently used ones once the cache grows over maxSizeBytes.
+type BlobCache struct {
+	path         string
+	maxSizeBytes int64
+}
+
+func NewBlobCache(path string, maxSizeBytes int64) *BlobCache {
+	return &BlobCache{
+		path:         path,
+		maxSizeBytes: maxSizeBytes,
+	}
+}
+
+// Get opens a cached blob and marks it as recently used. The blob is not
+// verified here: it is checked against its digest as it is streamed, and
+// evicted if it does not match.
+func (c *BlobCache) Get(logger lager.Logger, digest string) (io.ReadCloser, int64, bool) {
+	blobPath, err := c.blobPath(digest)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	blobFile, err := os.Open(blobPath)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	stat, err := blobFile.Stat()
+	if err != nil {
+		blobFile.Close()
+		return nil, 0, false
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(blobPath, now, now); err != nil {
+		logger.Error("touching-cached-blob-failed", err, lager.Data{"digest": digest})
+	}
+
+	logger.Debug("blob-cache-hit", lager.Data{"digest": digest, "size": stat.Size()})
+	return blobFile, stat.Size(), true
+}
+
+// Writer returns a writer that adds the blob to the cache once committed.
+// Closing the writer without committing it discards what was written.
+func (c *BlobCache) Writer(logger lager.Logger, digest string) (layer_fetcher.BlobCacheWriter, error) {
+	blobPath, err := c.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return nil, errorspkg.Wrap(err, "creating blob cache directory")
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(blobPath), filepath.Base(blobPath)+incompleteBlobMarker)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "creating cached blob")
+	}
+
+	return &blobWriter{
+		logger:   logger.Session("blob-cache-writer", lager.Data{"digest": digest}),
+		file:     tempFile,
+		blobPath: blobPath,
+		cache:    c,
+	}, nil
+}
+
+// Evict removes a cached blob
+func (c *BlobCache) Evict(logger lager.Logger, digest string) error {
+	blobPath, err := c.blobPath(digest)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("evicting-blob", lager.Data{"digest": digest})
+	if err := os.Remove(blobPath); err != nil && !os.IsNotExist(err) {
+		return errorspkg.Wrapf(err, "evicting blob `%s`", digest)
+	}
+
+	return nil
+}
+
+// Size returns the total size of the cached blobs
+func (c *BlobCache) Size(logger lager.Logger) (int64, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size()
+	}
+
+	return size, nil
+}
+
+// Trim evicts the least recently used blobs until the cache fits in its
+// budget
+func (c *BlobCache) Trim(logger lager.Logger) error {
+	logger = logger.Session("trimming-blob-cache", lager.Data{"maxSizeBytes": c.maxSizeBytes})
+	logger.Debug("starting")
+	defer logger.Debug("ending")
+
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	for _, entry := range entries {
+		if size <= c.maxSizeBytes {
+			break
+		}
+
+		logger.Debug("evicting-blob", lager.Data{"blob": entry.Name(), "size": entry.Size()})
+		if err := os.Remove(filepath.Join(c.sha256Path(), entry.Name())); err != nil && !os.IsNotExist(err) {
+			return errorspkg.Wrapf(err, "evicting blob `%s`", entry.Name())
+		}
+		size -= entry.Size()
+	}
+
+	return nil
+}
+
+// entries returns the complete cached blobs, removing incomplete ones that
+// have been abandoned
+func (c *BlobCache) entries() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(c.sha256Path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errorspkg.Wrap(err, "listing cached blobs")
+	}
+
+	entries := []os.FileInfo{}
+	for _, info := range infos {
+		if !strings.Contains(info.Name(), incompleteBlobMarker) {
+			entries = append(entries, info)
+			continue
+		}
+
+		if time.Since(info.ModTime()) > incompleteBlobTTL {
+			_ = os.Remove(filepath.Join(c.sha256Path(), info.Name()))
+		}
+	}
+
+	return entries, nil
+}
+
+func (c *BlobCache) sha256Path() string {
+	return filepath.Join(c.path, string(digestpkg.SHA256))
+}
+
+func (c *BlobCache) blobPath(digest string) (string, error) {
+	parsedDigest, err := digestpkg.Parse(digest)
+	if err != nil {
+		return "", errorspkg.Wrapf(err, "parsing digest `%s`", digest)
+	}
+
+	if parsedDigest.Algorithm() != digestpkg.SHA256 {
+		return "", errorspkg.Errorf("unsupported digest algorithm `%s`", parsedDigest.Algorithm())
+	}
+
+	return filepath.Join(c.sha256Path(), parsedDigest.Hex()), nil
+}
+
+type blobWriter struct {
+	logger    lager.Logger
+	file      *os.File
+	blobPath  string
+	cache     *BlobCache
+	committed bool
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+// Commit moves the blob into the cache. It must only be called once the
+// blob has been verified.
+func (w *blobWriter) Commit() error {
+	if err := w.file.Close(); err != nil {
+		return errorspkg.Wrap(err, "closing cached blob")
+	}
+
+	if err := os.Rename(w.file.Name(), w.blobPath); err != nil {
+		return errorspkg.Wrap(err, "committing cached blob")
+	}
+	w.committed = true
+	w.logger.Debug("blob-cached")
+
+	return w.cache.Trim(w.logger)
+}
+
+func (w *blobWriter) Close() error {
+	if w.committed {
+		return nil
+	}
+
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
@@ -0,0 +1,215 @@
+package blob_cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/store/blob_cache"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	digestpkg "github.com/opencontainers/go-digest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BlobCache", func() {
+	var (
+		cachePath    string
+		maxSizeBytes int64
+		cache        *blob_cache.BlobCache
+		logger       lager.Logger
+	)
+
+	addBlob := func(contents string) string {
+		digest := digestpkg.FromString(contents).String()
+		writer, err := cache.Writer(logger, digest)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = writer.Write([]byte(contents))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(writer.Commit()).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		return digest
+	}
+
+	blobPath := func(digest string) string {
+		return filepath.Join(cachePath, "sha256", strings.TrimPrefix(digest, "sha256:"))
+	}
+
+	BeforeEach(func() {
+		var err error
+		cachePath, err = ioutil.TempDir("", "blobs")
+		Expect(err).NotTo(HaveOccurred())
+
+		maxSizeBytes = 1024
+		logger = lagertest.NewTestLogger("blob-cache")
+	})
+
+	JustBeforeEach(func() {
+		cache = blob_cache.NewBlobCache(cachePath, maxSizeBytes)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cachePath)).To(Succeed())
+	})
+
+	Describe("Get", func() {
+		It("returns the cached blob", func() {
+			digest := addBlob("hello-world")
+
+			blob, size, ok := cache.Get(logger, digest)
+			Expect(ok).To(BeTrue())
+			defer blob.Close()
+			Expect(size).To(Equal(int64(len("hello-world"))))
+
+			contents, err := ioutil.ReadAll(blob)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("hello-world"))
+		})
+
+		It("marks the blob as recently used", func() {
+			digest := addBlob("hello-world")
+			oldTime := time.Now().Add(-time.Hour)
+			Expect(os.Chtimes(blobPath(digest), oldTime, oldTime)).To(Succeed())
+
+			blob, _, ok := cache.Get(logger, digest)
+			Expect(ok).To(BeTrue())
+			Expect(blob.Close()).To(Succeed())
+
+			stat, err := os.Stat(blobPath(digest))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stat.ModTime()).To(BeTemporally(">", oldTime))
+		})
+
+		Context("when the blob is not cached", func() {
+			It("returns false", func() {
+				_, _, ok := cache.Get(logger, digestpkg.FromString("not-cached").String())
+				Expect(ok).To(BeFalse())
+			})
+		})
+
+		Context("when the digest is invalid", func() {
+			It("returns false", func() {
+				_, _, ok := cache.Get(logger, "sha256:../../etc/passwd")
+				Expect(ok).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Writer", func() {
+		It("stores the blob under its digest", func() {
+			digest := addBlob("hello-world")
+
+			contents, err := ioutil.ReadFile(blobPath(digest))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("hello-world"))
+		})
+
+		Context("when the writer is closed without being committed", func() {
+			It("discards the blob", func() {
+				digest := digestpkg.FromString("hello-world").String()
+				writer, err := cache.Writer(logger, digest)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = writer.Write([]byte("hello"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(writer.Close()).To(Succeed())
+
+				_, _, ok := cache.Get(logger, digest)
+				Expect(ok).To(BeFalse())
+				Expect(ioutil.ReadDir(filepath.Join(cachePath, "sha256"))).To(BeEmpty())
+			})
+		})
+
+		Context("when the digest algorithm is not supported", func() {
+			It("returns an error", func() {
+				_, err := cache.Writer(logger, digestpkg.SHA512.FromString("hello").String())
+				Expect(err).To(MatchError(ContainSubstring("unsupported digest algorithm")))
+			})
+		})
+
+		Context("when the cache goes over its budget", func() {
+			BeforeEach(func() {
+				maxSizeBytes = 10
+			})
+
+			It("evicts the least recently used blobs", func() {
+				oldDigest := addBlob("12345")
+				oldTime := time.Now().Add(-time.Hour)
+				Expect(os.Chtimes(blobPath(oldDigest), oldTime, oldTime)).To(Succeed())
+				recentDigest := addBlob("67890")
+				newDigest := addBlob("abcde")
+
+				Expect(blobPath(oldDigest)).NotTo(BeAnExistingFile())
+				Expect(blobPath(recentDigest)).To(BeAnExistingFile())
+				Expect(blobPath(newDigest)).To(BeAnExistingFile())
+			})
+		})
+	})
+
+	Describe("Evict", func() {
+		It("removes the cached blob", func() {
+			digest := addBlob("hello-world")
+
+			Expect(cache.Evict(logger, digest)).To(Succeed())
+			Expect(blobPath(digest)).NotTo(BeAnExistingFile())
+
+			_, _, ok := cache.Get(logger, digest)
+			Expect(ok).To(BeFalse())
+		})
+
+		Context("when the blob is not cached", func() {
+			It("does not fail", func() {
+				Expect(cache.Evict(logger, digestpkg.FromString("not-cached").String())).To(Succeed())
+			})
+		})
+	})
+
+	Describe("Trim", func() {
+		It("evicts the least recently used blobs until the cache fits in its budget", func() {
+			digests := []string{addBlob(strings.Repeat("a", 300)), addBlob(strings.Repeat("b", 300)), addBlob(strings.Repeat("c", 300))}
+			for i, digest := range digests {
+				usedAt := time.Now().Add(time.Duration(i-3) * time.Minute)
+				Expect(os.Chtimes(blobPath(digest), usedAt, usedAt)).To(Succeed())
+			}
+
+			cache = blob_cache.NewBlobCache(cachePath, 500)
+			Expect(cache.Trim(logger)).To(Succeed())
+
+			Expect(blobPath(digests[0])).NotTo(BeAnExistingFile())
+			Expect(blobPath(digests[1])).NotTo(BeAnExistingFile())
+			Expect(blobPath(digests[2])).To(BeAnExistingFile())
+
+			Expect(cache.Size(logger)).To(Equal(int64(300)))
+		})
+
+		It("removes abandoned incomplete blobs", func() {
+			digest := digestpkg.FromString("hello-world").String()
+			_, err := cache.Writer(logger, digest)
+			Expect(err).NotTo(HaveOccurred())
+
+			incompleteBlobs, err := filepath.Glob(blobPath(digest) + ".incomplete-*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(incompleteBlobs).To(HaveLen(1))
+			oldTime := time.Now().Add(-2 * time.Hour)
+			Expect(os.Chtimes(incompleteBlobs[0], oldTime, oldTime)).To(Succeed())
+
+			Expect(cache.Trim(logger)).To(Succeed())
+			Expect(incompleteBlobs[0]).NotTo(BeAnExistingFile())
+		})
+
+		Context("when the cache is empty", func() {
+			BeforeEach(func() {
+				Expect(os.RemoveAll(cachePath)).To(Succeed())
+			})
+
+			It("does not fail", func() {
+				Expect(cache.Trim(logger)).To(Succeed())
+				Expect(cache.Size(logger)).To(BeZero())
+			})
+		})
+	})
+})
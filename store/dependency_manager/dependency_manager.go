@@ -51,6 +51,25 @@ func (d *DependencyManager) Dependencies(id string) ([]string, error) {
 	return chainIDs, nil
 }
 
+// IDs returns the registered ids that start with the prefix. Ids holding a
+// `/` are returned escaped, and can be passed back as they are.
+func (d *DependencyManager) IDs(prefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(d.dependenciesPath)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "listing dependencies")
+	}
+
+	ids := []string{}
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), ".json")
+		if id != file.Name() && strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
 func (d *DependencyManager) filePath(id string) string {
 	escapedId := strings.Replace(id, "/", "__", -1)
 	return filepath.Join(d.dependenciesPath, fmt.Sprintf("%s.json", escapedId))
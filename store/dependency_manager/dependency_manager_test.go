@@ -88,4 +88,42 @@ var _ = Describe("DependencyManager", func() {
 			})
 		})
 	})
+
+	Describe("IDs", func() {
+		BeforeEach(func() {
+			Expect(manager.Register("image:my-image", []string{"sha256:vol-1"})).To(Succeed())
+			Expect(manager.Register("pin:my-pin", []string{"sha256:vol-2"})).To(Succeed())
+			Expect(manager.Register("pin:my/pin", []string{"sha256:vol-3"})).To(Succeed())
+		})
+
+		It("returns the ids with the prefix", func() {
+			ids, err := manager.IDs("pin:")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(ConsistOf("pin:my-pin", "pin:my__pin"))
+		})
+
+		It("returns ids that can be deregistered", func() {
+			ids, err := manager.IDs("pin:")
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, id := range ids {
+				Expect(manager.Deregister(id)).To(Succeed())
+			}
+
+			ids, err = manager.IDs("pin:")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ids).To(BeEmpty())
+		})
+
+		Context("when the base path does not exist", func() {
+			BeforeEach(func() {
+				manager = dependency_manager.NewDependencyManager("/path/to/non/existent/dir")
+			})
+
+			It("returns an error", func() {
+				_, err := manager.IDs("pin:")
+				Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+			})
+		})
+	})
 })
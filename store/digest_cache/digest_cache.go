@@ -0,0 +1,97 @@
+package digest_cache // import "code.cloudfoundry.org/grootfs/store/digest_cache"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+// DigestCache remembers the digest of local files, so that they are only
+// hashed again when their path, size or modification time change
+type DigestCache struct {
+	path string
+}
+
+type entry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Digest  string    `json:"digest"`
+}
+
+func NewDigestCache(path string) *DigestCache {
+	return &DigestCache{
+		path: path,
+	}
+}
+
+// Get returns the cached digest of the file, if the file has not changed
+// since it was cached
+func (c *DigestCache) Get(logger lager.Logger, filePath string, stat os.FileInfo) (string, bool) {
+	contents, err := ioutil.ReadFile(c.entryPath(filePath))
+	if err != nil {
+		return "", false
+	}
+
+	var cachedEntry entry
+	if err := json.Unmarshal(contents, &cachedEntry); err != nil {
+		logger.Error("parsing-cached-digest-failed", err, lager.Data{"path": filePath})
+		return "", false
+	}
+
+	if cachedEntry.Path != filePath || cachedEntry.Size != stat.Size() || !cachedEntry.ModTime.Equal(stat.ModTime()) {
+		return "", false
+	}
+
+	return cachedEntry.Digest, true
+}
+
+// Set caches the digest of the file
+func (c *DigestCache) Set(logger lager.Logger, filePath string, stat os.FileInfo, digest string) error {
+	contents, err := json.Marshal(entry{
+		Path:    filePath,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+		Digest:  digest,
+	})
+	if err != nil {
+		return errorspkg.Wrap(err, "encoding digest cache entry")
+	}
+
+	if err := os.MkdirAll(c.path, 0755); err != nil {
+		return errorspkg.Wrap(err, "creating digest cache directory")
+	}
+
+	// concurrent creates might be caching the same file
+	tempFile, err := ioutil.TempFile(c.path, "entry")
+	if err != nil {
+		return errorspkg.Wrap(err, "creating digest cache entry")
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(contents); err != nil {
+		tempFile.Close()
+		return errorspkg.Wrap(err, "writing digest cache entry")
+	}
+	if err := tempFile.Close(); err != nil {
+		return errorspkg.Wrap(err, "writing digest cache entry")
+	}
+
+	if err := os.Rename(tempFile.Name(), c.entryPath(filePath)); err != nil {
+		return errorspkg.Wrap(err, "committing digest cache entry")
+	}
+
+	return nil
+}
+
+func (c *DigestCache) entryPath(filePath string) string {
+	pathSha := sha256.Sum256([]byte(filePath))
+	return filepath.Join(c.path, hex.EncodeToString(pathSha[:])+".json")
+}
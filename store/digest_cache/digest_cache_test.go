@@ -0,0 +1,97 @@
+package digest_cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/grootfs/store/digest_cache"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DigestCache", func() {
+	var (
+		cachePath string
+		filePath  string
+		cache     *digest_cache.DigestCache
+		logger    lager.Logger
+	)
+
+	stat := func() os.FileInfo {
+		info, err := os.Stat(filePath)
+		Expect(err).NotTo(HaveOccurred())
+		return info
+	}
+
+	BeforeEach(func() {
+		var err error
+		cachePath, err = ioutil.TempDir("", "digest-cache")
+		Expect(err).NotTo(HaveOccurred())
+
+		filePath = filepath.Join(cachePath, "image.tar")
+		Expect(ioutil.WriteFile(filePath, []byte("hello-world"), 0644)).To(Succeed())
+
+		logger = lagertest.NewTestLogger("digest-cache")
+		cache = digest_cache.NewDigestCache(filepath.Join(cachePath, "meta", "digests"))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cachePath)).To(Succeed())
+	})
+
+	It("returns the cached digest", func() {
+		Expect(cache.Set(logger, filePath, stat(), "the-digest")).To(Succeed())
+
+		digest, ok := cache.Get(logger, filePath, stat())
+		Expect(ok).To(BeTrue())
+		Expect(digest).To(Equal("the-digest"))
+	})
+
+	Context("when the file has not been cached", func() {
+		It("returns false", func() {
+			_, ok := cache.Get(logger, filePath, stat())
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("when the file size changes", func() {
+		It("returns false", func() {
+			Expect(cache.Set(logger, filePath, stat(), "the-digest")).To(Succeed())
+			modTime := stat().ModTime()
+			Expect(ioutil.WriteFile(filePath, []byte("hello-world-again"), 0644)).To(Succeed())
+			Expect(os.Chtimes(filePath, modTime, modTime)).To(Succeed())
+
+			_, ok := cache.Get(logger, filePath, stat())
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("when the file modification time changes", func() {
+		It("returns false", func() {
+			Expect(cache.Set(logger, filePath, stat(), "the-digest")).To(Succeed())
+			newTime := time.Now().Add(time.Hour)
+			Expect(os.Chtimes(filePath, newTime, newTime)).To(Succeed())
+
+			_, ok := cache.Get(logger, filePath, stat())
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("when the entry is corrupted", func() {
+		It("returns false", func() {
+			Expect(cache.Set(logger, filePath, stat(), "the-digest")).To(Succeed())
+			entries, err := filepath.Glob(filepath.Join(cachePath, "meta", "digests", "*.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(ioutil.WriteFile(entries[0], []byte("not-json"), 0644)).To(Succeed())
+
+			_, ok := cache.Get(logger, filePath, stat())
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,88 @@
+package etag_cache // import "code.cloudfoundry.org/grootfs/store/etag_cache"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager"
+	errorspkg "github.com/pkg/errors"
+)
+
+// ETagCache remembers the last ETag seen for remote base images, so that
+// they can be fetched with conditional requests
+type ETagCache struct {
+	path string
+}
+
+type entry struct {
+	URL  string `json:"url"`
+	ETag string `json:"etag"`
+}
+
+func NewETagCache(path string) *ETagCache {
+	return &ETagCache{
+		path: path,
+	}
+}
+
+// Get returns the cached ETag of the url
+func (c *ETagCache) Get(logger lager.Logger, url string) (string, bool) {
+	contents, err := ioutil.ReadFile(c.entryPath(url))
+	if err != nil {
+		return "", false
+	}
+
+	var cachedEntry entry
+	if err := json.Unmarshal(contents, &cachedEntry); err != nil {
+		logger.Error("parsing-cached-etag-failed", err, lager.Data{"url": url})
+		return "", false
+	}
+
+	if cachedEntry.URL != url || cachedEntry.ETag == "" {
+		return "", false
+	}
+
+	return cachedEntry.ETag, true
+}
+
+// Set caches the ETag of the url
+func (c *ETagCache) Set(logger lager.Logger, url, etag string) error {
+	contents, err := json.Marshal(entry{URL: url, ETag: etag})
+	if err != nil {
+		return errorspkg.Wrap(err, "encoding etag cache entry")
+	}
+
+	if err := os.MkdirAll(c.path, 0755); err != nil {
+		return errorspkg.Wrap(err, "creating etag cache directory")
+	}
+
+	// concurrent creates might be caching the same url
+	tempFile, err := ioutil.TempFile(c.path, "entry")
+	if err != nil {
+		return errorspkg.Wrap(err, "creating etag cache entry")
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(contents); err != nil {
+		tempFile.Close()
+		return errorspkg.Wrap(err, "writing etag cache entry")
+	}
+	if err := tempFile.Close(); err != nil {
+		return errorspkg.Wrap(err, "writing etag cache entry")
+	}
+
+	if err := os.Rename(tempFile.Name(), c.entryPath(url)); err != nil {
+		return errorspkg.Wrap(err, "committing etag cache entry")
+	}
+
+	return nil
+}
+
+func (c *ETagCache) entryPath(url string) string {
+	urlSha := sha256.Sum256([]byte(url))
+	return filepath.Join(c.path, hex.EncodeToString(urlSha[:])+".json")
+}
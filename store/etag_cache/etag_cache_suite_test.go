@@ -0,0 +1,13 @@
+package etag_cache_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestETagCache(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ETag Cache Suite")
+}
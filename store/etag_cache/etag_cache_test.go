@@ -0,0 +1,74 @@
+package etag_cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/grootfs/store/etag_cache"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ETagCache", func() {
+	const imageURL = "https://artifacts.example.com/rootfs.tar.gz"
+
+	var (
+		cachePath string
+		cache     *etag_cache.ETagCache
+		logger    lager.Logger
+	)
+
+	BeforeEach(func() {
+		var err error
+		cachePath, err = ioutil.TempDir("", "etag-cache")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("etag-cache")
+		cache = etag_cache.NewETagCache(filepath.Join(cachePath, "meta", "etags"))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cachePath)).To(Succeed())
+	})
+
+	It("returns the cached etag", func() {
+		Expect(cache.Set(logger, imageURL, `"the-etag"`)).To(Succeed())
+
+		etag, ok := cache.Get(logger, imageURL)
+		Expect(ok).To(BeTrue())
+		Expect(etag).To(Equal(`"the-etag"`))
+	})
+
+	It("replaces the etag when it is set again", func() {
+		Expect(cache.Set(logger, imageURL, `"the-etag"`)).To(Succeed())
+		Expect(cache.Set(logger, imageURL, `"another-etag"`)).To(Succeed())
+
+		etag, ok := cache.Get(logger, imageURL)
+		Expect(ok).To(BeTrue())
+		Expect(etag).To(Equal(`"another-etag"`))
+	})
+
+	Context("when the url has not been cached", func() {
+		It("returns false", func() {
+			_, ok := cache.Get(logger, imageURL)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("when the entry is corrupted", func() {
+		It("returns false", func() {
+			Expect(cache.Set(logger, imageURL, `"the-etag"`)).To(Succeed())
+			entries, err := filepath.Glob(filepath.Join(cachePath, "meta", "etags", "*.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(ioutil.WriteFile(entries[0], []byte("not-json"), 0644)).To(Succeed())
+
+			_, ok := cache.Get(logger, imageURL)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
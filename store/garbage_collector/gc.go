@@ -20,6 +20,7 @@ type ImageCloner interface {
 
 type DependencyManager interface {
 	Dependencies(id string) ([]string, error)
+	IDs(prefix string) ([]string, error)
 }
 
 type VolumeDriver interface {
@@ -162,6 +163,19 @@ func (g *GarbageCollector) UnusedVolumes(logger lager.Logger) ([]string, error)
 		g.removeDependencyFromOrphanList(orphanedVolumes, usedVolumes)
 	}
 
+	pinIDs, err := g.dependencyManager.IDs(groot.PinReferencePrefix)
+	if err != nil {
+		return nil, errorspkg.Wrap(err, "failed to retrieve pins")
+	}
+
+	for _, pinID := range pinIDs {
+		pinnedVolumes, err := g.dependencyManager.Dependencies(pinID)
+		if err != nil {
+			return nil, err
+		}
+		g.removeDependencyFromOrphanList(orphanedVolumes, pinnedVolumes)
+	}
+
 	orphanedVolumeIDs := []string{}
 	for id := range orphanedVolumes {
 		orphanedVolumeIDs = append(orphanedVolumeIDs, id)
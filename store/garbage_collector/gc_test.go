@@ -73,6 +73,43 @@ var _ = Describe("Gc", func() {
 			Expect(unusedVolumes).To(ConsistOf("sha256ubuntu", "sha256privateubuntu", "unusedLayerVolume", "unusedLocalVolume-timestamp"))
 		})
 
+		Context("when volumes are pinned", func() {
+			BeforeEach(func() {
+				fakeDependencyManager.IDsStub = func(prefix string) ([]string, error) {
+					Expect(prefix).To(Equal("pin:"))
+					return []string{"pin:ubuntu"}, nil
+				}
+
+				fakeDependencyManager.DependenciesStub = func(id string) ([]string, error) {
+					return map[string][]string{
+						"image:idA":     []string{"volDocker1", "volDocker2"},
+						"image:idB":     []string{"volDocker1", "volDocker3"},
+						"image:idLocal": []string{"usedLocalVolume-timestamp"},
+						"pin:ubuntu":    []string{"sha256ubuntu"},
+					}[id], nil
+				}
+			})
+
+			It("does not return them", func() {
+				unusedVolumes, err := garbageCollector.UnusedVolumes(logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(unusedVolumes).To(ConsistOf("sha256privateubuntu", "unusedLayerVolume", "unusedLocalVolume-timestamp"))
+			})
+
+			Context("when retrieving the pins fails", func() {
+				BeforeEach(func() {
+					fakeDependencyManager.IDsStub = nil
+					fakeDependencyManager.IDsReturns(nil, errors.New("failed to list deps"))
+				})
+
+				It("returns an error", func() {
+					_, err := garbageCollector.UnusedVolumes(logger)
+					Expect(err).To(MatchError(ContainSubstring("failed to list deps")))
+				})
+			})
+		})
+
 		Context("when retrieving images fails", func() {
 			BeforeEach(func() {
 				fakeImageCloner.ImageIDsReturns(nil, errors.New("failed to retrieve images"))
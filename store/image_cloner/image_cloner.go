@@ -30,8 +30,9 @@ type ImageDriver interface {
 }
 
 type ImageCloner struct {
-	imageDriver ImageDriver
-	storePath   string
+	imageDriver      ImageDriver
+	storePath        string
+	progressReporter groot.ProgressReporter
 }
 
 func NewImageCloner(imageDriver ImageDriver, storePath string) *ImageCloner {
@@ -41,6 +42,11 @@ func NewImageCloner(imageDriver ImageDriver, storePath string) *ImageCloner {
 	}
 }
 
+func (b *ImageCloner) WithProgressReporter(progressReporter groot.ProgressReporter) *ImageCloner {
+	b.progressReporter = progressReporter
+	return b
+}
+
 func (b *ImageCloner) ImageIDs(logger lager.Logger) ([]string, error) {
 	images := []string{}
 
@@ -117,6 +123,14 @@ func (b *ImageCloner) Create(logger lager.Logger, spec groot.ImageSpec) (groot.I
 		return groot.ImageInfo{}, errorspkg.Wrap(err, "creating image object")
 	}
 
+	if b.progressReporter != nil {
+		b.progressReporter.Report(logger, groot.ProgressEvent{
+			Event:   groot.ProgressImageCreated,
+			ImageID: spec.ID,
+			Rootfs:  imageInfo.Rootfs,
+		})
+	}
+
 	return imageInfo, nil
 }
 
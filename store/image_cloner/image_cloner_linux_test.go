@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"code.cloudfoundry.org/grootfs/groot"
+	"code.cloudfoundry.org/grootfs/groot/grootfakes"
 	"code.cloudfoundry.org/grootfs/store"
 	imageclonerpkg "code.cloudfoundry.org/grootfs/store/image_cloner"
 	"code.cloudfoundry.org/grootfs/store/image_cloner/image_clonerfakes"
@@ -111,6 +112,40 @@ var _ = Describe("Image", func() {
 			Expect(spec.ImagePath).To(Equal(image.Path))
 		})
 
+		Context("when a progress reporter is provided", func() {
+			var fakeProgressReporter *grootfakes.FakeProgressReporter
+
+			JustBeforeEach(func() {
+				fakeProgressReporter = new(grootfakes.FakeProgressReporter)
+				imageCloner = imageCloner.WithProgressReporter(fakeProgressReporter)
+			})
+
+			It("reports the created image", func() {
+				image, err := imageCloner.Create(logger, groot.ImageSpec{ID: "some-id", BaseImage: imageConfig})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeProgressReporter.ReportCallCount()).To(Equal(1))
+				_, event := fakeProgressReporter.ReportArgsForCall(0)
+				Expect(event).To(Equal(groot.ProgressEvent{
+					Event:   groot.ProgressImageCreated,
+					ImageID: "some-id",
+					Rootfs:  image.Rootfs,
+				}))
+			})
+
+			Context("when creating the image fails", func() {
+				BeforeEach(func() {
+					fakeImageDriver.CreateImageReturns(groot.MountInfo{}, errors.New("failed"))
+				})
+
+				It("does not report it", func() {
+					_, err := imageCloner.Create(logger, groot.ImageSpec{ID: "some-id", BaseImage: imageConfig})
+					Expect(err).To(HaveOccurred())
+					Expect(fakeProgressReporter.ReportCallCount()).To(BeZero())
+				})
+			})
+		})
+
 		Context("when mounting is skipped", func() {
 			It("returns a image with mount information", func() {
 				image, err := imageCloner.Create(logger, groot.ImageSpec{ID: "some-id", BaseImage: imageConfig, Mount: false})
@@ -89,6 +89,20 @@ var _ = Describe("Measurer", func() {
 			Expect(cacheUsage).To(BeNumerically("==", 4096))
 		})
 
+		Context("when there are cached blobs", func() {
+			BeforeEach(func() {
+				blobsPath := filepath.Join(storePath, store.BlobsDirName, "sha256")
+				Expect(os.MkdirAll(blobsPath, 0755)).To(Succeed())
+				Expect(writeFile(filepath.Join(blobsPath, "blob"), 1024)).To(Succeed())
+			})
+
+			It("does not measure them", func() {
+				cacheUsage, err := storeMeasurer.TotalVolumesSize(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cacheUsage).To(BeNumerically("==", 4096))
+			})
+		})
+
 		Context("when getting volumes returns an error", func() {
 			BeforeEach(func() {
 				volumeDriver.VolumesReturns([]string{}, errors.New("failed here"))
@@ -7,6 +7,7 @@ const (
 	LocksDirName     = "locks"
 	MetaDirName      = "meta"
 	TempDirName      = "tmp"
+	BlobsDirName     = "blobs"
 	DefaultStorePath = "/var/lib/grootfs"
 )
 
@@ -1,6 +1,8 @@
 package testhelpers
 
 import (
+	"encoding/pem"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -15,14 +17,22 @@ type blobHandler struct {
 	order       int
 }
 
+type blobDrop struct {
+	afterBytes int64
+	times      int
+}
+
 type FakeRegistry struct {
 	ActualRegistryURL   *url.URL
 	blobHandlers        map[string]blobHandler
 	blobRequestsCounter map[string]int
+	blobDrops           map[string]*blobDrop
+	blobRangeRequests   map[string][]string
 	blobRegexp          *regexp.Regexp
 	manifestRegexp      *regexp.Regexp
 	failNextRequests    int
 	forceTokenAuthError bool
+	tokenUsernames      []string
 	revProxy            *httputil.ReverseProxy
 	server              *ghttp.Server
 	mutex               *sync.RWMutex
@@ -33,6 +43,8 @@ func NewFakeRegistry(actualRegistryURL *url.URL) *FakeRegistry {
 		ActualRegistryURL:   actualRegistryURL,
 		blobHandlers:        make(map[string]blobHandler),
 		blobRequestsCounter: make(map[string]int),
+		blobDrops:           make(map[string]*blobDrop),
+		blobRangeRequests:   make(map[string][]string),
 		mutex:               &sync.RWMutex{},
 	}
 }
@@ -75,6 +87,8 @@ func (r *FakeRegistry) ForceTokenAuthError() {
 func (r *FakeRegistry) serveToken(rw http.ResponseWriter, req *http.Request) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
+	username, _, _ := req.BasicAuth()
+	r.tokenUsernames = append(r.tokenUsernames, username)
 	if r.forceTokenAuthError {
 		rw.WriteHeader(http.StatusUnauthorized)
 		return
@@ -99,7 +113,7 @@ func (r *FakeRegistry) serveHTTP(rw http.ResponseWriter, req *http.Request) {
 func (r *FakeRegistry) serveManifest(rw http.ResponseWriter, req *http.Request) {
 	if r.failNextRequests > 0 {
 		r.failNextRequests--
-		rw.WriteHeader(http.StatusTeapot)
+		rw.WriteHeader(http.StatusServiceUnavailable)
 		_, _ = rw.Write([]byte("null"))
 		return
 	}
@@ -110,7 +124,7 @@ func (r *FakeRegistry) serveManifest(rw http.ResponseWriter, req *http.Request)
 func (r *FakeRegistry) serveBlob(rw http.ResponseWriter, req *http.Request) {
 	if r.failNextRequests > 0 {
 		r.failNextRequests--
-		rw.WriteHeader(http.StatusTeapot)
+		rw.WriteHeader(http.StatusServiceUnavailable)
 		_, _ = rw.Write([]byte("null"))
 		return
 	}
@@ -125,8 +139,23 @@ func (r *FakeRegistry) serveBlob(rw http.ResponseWriter, req *http.Request) {
 
 	r.mutex.Lock()
 	r.blobRequestsCounter[digest]++
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		r.blobRangeRequests[digest] = append(r.blobRangeRequests[digest], rangeHeader)
+	}
+	drop, shouldDrop := r.blobDrops[digest]
+	if shouldDrop {
+		drop.times--
+		if drop.times == 0 {
+			delete(r.blobDrops, digest)
+		}
+	}
 	r.mutex.Unlock()
 
+	if shouldDrop {
+		r.serveDroppedBlob(rw, req, drop.afterBytes)
+		return
+	}
+
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 	handler, ok := r.blobHandlers[digest]
@@ -143,6 +172,48 @@ func (r *FakeRegistry) serveBlob(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// serveDroppedBlob fetches the blob from the actual registry itself, as it
+// might redirect to a different host, and closes the connection after
+// afterBytes bytes of the body have been sent
+func (r *FakeRegistry) serveDroppedBlob(rw http.ResponseWriter, req *http.Request, afterBytes int64) {
+	upstreamURL := *r.ActualRegistryURL
+	upstreamURL.Path = req.URL.Path
+	upstreamReq, err := http.NewRequest("GET", upstreamURL.String(), nil)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, header := range []string{"Authorization", "Range", "Accept"} {
+		if value := req.Header.Get(header); value != "" {
+			upstreamReq.Header.Set(header, value)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(upstreamReq)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Content-Length", "Content-Range", "Docker-Content-Digest"} {
+		if value := resp.Header.Get(header); value != "" {
+			rw.Header().Set(header, value)
+		}
+	}
+	rw.WriteHeader(resp.StatusCode)
+	_, _ = io.CopyN(rw, resp.Body, afterBytes)
+
+	if flusher, ok := rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	if hijacker, ok := rw.(http.Hijacker); ok {
+		if conn, _, err := hijacker.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
 func (r *FakeRegistry) Stop() {
 	r.server.Close()
 }
@@ -151,6 +222,14 @@ func (r *FakeRegistry) Addr() string {
 	return r.server.Addr()
 }
 
+// CACertificate returns the PEM encoded certificate the registry serves with
+func (r *FakeRegistry) CACertificate() []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: r.server.HTTPTestServer.Certificate().Raw,
+	})
+}
+
 func (r *FakeRegistry) WhenGettingBlob(digest string, order int, httpHandler http.HandlerFunc) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -161,6 +240,34 @@ func (r *FakeRegistry) WhenGettingBlob(digest string, order int, httpHandler htt
 	}
 }
 
+// DropBlobConnections makes the next `times` requests for the blob stop
+// after `afterBytes` bytes of the body have been sent
+func (r *FakeRegistry) DropBlobConnections(digest string, afterBytes int64, times int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.blobDrops[digest] = &blobDrop{
+		afterBytes: afterBytes,
+		times:      times,
+	}
+}
+
+func (r *FakeRegistry) BlobRangeRequests(digest string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return append([]string{}, r.blobRangeRequests[digest]...)
+}
+
+// TokenUsernames returns the user of each token request, or an empty string
+// for anonymous requests
+func (r *FakeRegistry) TokenUsernames() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return append([]string{}, r.tokenUsernames...)
+}
+
 func (r *FakeRegistry) RequestedBlobs() []string {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()